@@ -0,0 +1,229 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	. "encryption/pkg"
+	"flag"
+	"fmt"
+	"math"
+	"math/rand"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Normalization of each command, named as in the normalization column of EXPERIMENTAL RESULTS/*.csv
+var normalizations = map[string]string{
+	"z_score":         "federated_z_score",
+	"minmax":          "federated_min_max",
+	"robust":          "federated_robust_scaling",
+	"power_transform": "federated_yeo_johnson",
+	"log_scaling":     "federated_log_scaling",
+}
+
+var header = []string{
+	"number of clients", "number of features", "logN", "normalization", "run",
+	"runtime_s", "party_time_s", "cloud_time_s", "upload_bytes", "download_bytes", "refreshes", "key_switches", "log2_precision",
+}
+
+func main() {
+
+	partyList := flag.String("parties", "2,4,8", "comma separated numbers of parties")
+	featureList := flag.String("features", "4", "comma separated numbers of features")
+	logNList := flag.String("logn", "13,14,15", "comma separated log2 of the ring degrees (below 15 the parameters are under 128 bit security)")
+	methodList := flag.String("methods", "z_score,minmax,robust,power_transform,log_scaling", "comma separated normalization commands")
+	runs := flag.Int("runs", 3, "number of runs of each configuration")
+	samples := flag.Int("samples", 50, "number of generated samples of each feature per party")
+	seed := flag.Int64("seed", 1, "seed of the generated data")
+	out := flag.String("out", "bench.csv", "CSV file the results are written to")
+	flag.Parse()
+
+	partyCounts, err := parseInts(*partyList)
+	if err != nil {
+		panic(err)
+	}
+	featureCounts, err := parseInts(*featureList)
+	if err != nil {
+		panic(err)
+	}
+	logNs, err := parseInts(*logNList)
+	if err != nil {
+		panic(err)
+	}
+	methods := ParseFeatures(*methodList)
+	for _, method := range methods {
+		if _, ok := normalizations[method]; !ok {
+			panic(fmt.Sprintf("unknown normalization command %q", method))
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "bench")
+	if err != nil {
+		panic(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The commands are built once and run in their own process, in the simulation mode. They are built by their import
+	// path, which resolves from any directory of the module.
+	for _, method := range methods {
+		build := exec.Command("go", "build", "-o", filepath.Join(dir, method), "encryption/"+method)
+		build.Stdout, build.Stderr = os.Stdout, os.Stderr
+		if err = build.Run(); err != nil {
+			panic(fmt.Sprintf("building %s: %v", method, err))
+		}
+	}
+
+	file, err := os.Create(*out)
+	if err != nil {
+		panic(err)
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	if err = w.Write(header); err != nil {
+		panic(err)
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	for _, N := range partyCounts {
+		for _, F := range featureCounts {
+
+			// Pooled data, split between the N parties by the commands
+			dataPath := filepath.Join(dir, fmt.Sprintf("data_%d_%d.csv", N, F))
+			if err = writeData(dataPath, rng, N*(*samples), F); err != nil {
+				panic(err)
+			}
+
+			for _, logN := range logNs {
+				for _, method := range methods {
+					for run := 0; run < *runs; run++ {
+						fmt.Printf("%s: %d parties, %d features, LogN %d, run %d\n", method, N, F, logN, run)
+
+						row, err := benchmark(dir, method, dataPath, N, F, logN, run)
+						if err != nil {
+							fmt.Fprintf(os.Stderr, "%s: %d parties, %d features, LogN %d, run %d: %v\n", method, N, F, logN, run, err)
+							continue
+						}
+						if err = w.Write(row); err != nil {
+							panic(err)
+						}
+						// The rows of the finished configurations are kept if the sweep is interrupted
+						if w.Flush(); w.Error() != nil {
+							panic(w.Error())
+						}
+					}
+				}
+			}
+		}
+	}
+}
+
+// Runs the command once on the data and returns its row of results
+func benchmark(dir, method, dataPath string, N, F, logN, run int) ([]string, error) {
+
+	metricsPath := filepath.Join(dir, method+".json")
+	cmd := exec.Command(filepath.Join(dir, method),
+		"-parties", strconv.Itoa(N), "-logn", strconv.Itoa(logN), "-data", dataPath, "-metrics", metricsPath)
+
+	var runtime time.Duration
+	var output []byte
+	var err error
+	runtime = RunTimed(func() {
+		output, err = cmd.CombinedOutput()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w\n%s", err, lastLines(string(output), 10))
+	}
+
+	data, err := os.ReadFile(metricsPath)
+	if err != nil {
+		return nil, err
+	}
+	metrics := NewMetrics()
+	if err = json.Unmarshal(data, metrics); err != nil {
+		return nil, err
+	}
+
+	var party, cloud time.Duration
+	var upload, download int64
+	for _, p := range metrics.Phases {
+		party += p.Party
+		cloud += p.Cloud
+		upload += p.Upload
+		download += p.Download
+	}
+
+	// Precision of the least precise statistic against the plaintext reference
+	precision := ""
+	if len(metrics.Precision) > 0 {
+		worst := math.Inf(1)
+		for _, p := range metrics.Precision {
+			worst = math.Min(worst, p.Log2Precision)
+		}
+		precision = strconv.FormatFloat(worst, 'f', 2, 64)
+	}
+
+	return []string{
+		strconv.Itoa(N), strconv.Itoa(F), strconv.Itoa(logN), normalizations[method], strconv.Itoa(run),
+		seconds(runtime), seconds(party), seconds(cloud),
+		strconv.FormatInt(upload, 10), strconv.FormatInt(download, 10),
+		strconv.Itoa(metrics.Refreshes), strconv.Itoa(metrics.KeySwitches), precision,
+	}, nil
+}
+
+// Writes n samples of F positive, log-normally distributed features with a skewness growing with the feature,
+// so that every command (Box-Cox included) accepts them
+func writeData(path string, rng *rand.Rand, n, F int) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	row := make([]string, F)
+	for j := range row {
+		row[j] = fmt.Sprintf("feature%d", j)
+	}
+	if err = w.Write(row); err != nil {
+		return err
+	}
+	for k := 0; k < n; k++ {
+		for j := range row {
+			row[j] = strconv.FormatFloat(math.Exp(2+rng.NormFloat64()*0.25*float64(j%4+1)), 'f', 6, 64)
+		}
+		if err = w.Write(row); err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func parseInts(list string) ([]int, error) {
+	var values []int
+	for _, s := range ParseFeatures(list) {
+		v, err := strconv.Atoi(s)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, v)
+	}
+	return values, nil
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 6, 64)
+}
+
+func lastLines(s string, n int) string {
+	lines := strings.Split(strings.TrimRight(s, "\n"), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return strings.Join(lines, "\n")
+}
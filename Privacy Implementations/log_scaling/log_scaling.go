@@ -0,0 +1,341 @@
+package main
+
+import (
+	. "encryption/pkg"
+	"flag"
+	"fmt"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Default public bound of the absolute values of the features for the comparisons of the minimum
+const defaultBound = 10000.0
+
+func main() {
+
+	cf := NewCommandFlags(flag.CommandLine)
+	boundList := flag.String("bounds", "", "comma separated public bound of the absolute values of each feature, 10000 for every feature if empty")
+	flag.Parse()
+
+	bounds, err := ParseValues(*boundList)
+	if err != nil {
+		panic(err)
+	}
+
+	// Set encryption parameters for CKKS
+	params, err := cf.Params()
+	if err != nil {
+		panic(err)
+	}
+
+	// A party process only holds its own data and secret key and answers the aggregator's rounds
+	if cf.Role == "party" {
+		if err = cf.ServeParty(params, GenPowerParty); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	var parties []*Party
+	if cf.Role == "sim" {
+		// Create each party and their secret keys
+		if parties, err = cf.SimParties(params, GenPowerParties); err != nil {
+			panic(err)
+		}
+
+		// See the parties' inputs
+		PrintZscorePartyInputs(parties)
+	}
+
+	cohort, closeCohort, err := cf.Cohort(params, parties)
+	if err != nil {
+		panic(err)
+	}
+	defer closeCohort()
+
+	// The numbers of samples and the minimums of the features are packed in one ciphertext per party
+	NFeatures := cf.NumFeatures(parties)
+	layout, err := logLayout(params, NFeatures)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Slot layout: \n%s", layout)
+
+	if len(bounds) == 0 {
+		bounds = make([]float64, NFeatures)
+		for i := range bounds {
+			bounds[i] = defaultBound
+		}
+	}
+	if len(bounds) < NFeatures {
+		panic(fmt.Sprintf("%d bounds for %d features", len(bounds), NFeatures))
+	}
+
+	session, err := NewSession(params, cohort, DefaultCRSSeed)
+	if err != nil {
+		panic(err)
+	}
+
+	// 1) Collective key generations
+
+	// Collective Public Key (published to the parties for encrypting their inputs), Relinearization Key,
+	// GaloisKey for the complex conjugation and Refresh Protocol
+	if err = session.Setup(params.GaloisElementForComplexConjugation()); err != nil {
+		panic(err)
+	}
+
+	// Threshold mode: the secret keys are shared so that the t active parties alone can decrypt and refresh
+	if err = cf.Thresholdize(session); err != nil {
+		panic(err)
+	}
+
+	// 2) to 6) Global minimum, shift, mean and inverse standard deviation of the log values, computed again without the
+	// parties that drop on the way so that they aggregate the same parties
+	var minValues, shift, meanValues, invStdValues []float64
+	contributors, err := session.Consistent(func() error {
+		var err error
+		minValues, shift, meanValues, invStdValues, err = logStatistics(session, layout, bounds)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// The floor of the variance is removed and constant features are left unscaled, as (log10(x + shift) - mean) / 1
+	log2max, err := logVarianceLogMax(bounds, shift)
+	if err != nil {
+		panic(err)
+	}
+	stdValues, invStdValues, err := StdFromInverse(invStdValues, VarianceLogMin, log2max)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Results:\n")
+	fmt.Printf("Min: ")
+	PrintValues(minValues)
+	fmt.Printf("Shift: ")
+	PrintValues(shift)
+	fmt.Printf("Mean: ")
+	PrintValues(meanValues)
+	fmt.Printf("Std: ")
+	PrintValues(stdValues)
+	fmt.Printf("1/Std: ")
+	PrintValues(invStdValues)
+
+	fmt.Printf("\n")
+	fmt.Printf("Contributors: %v\n", contributors)
+	PrintReleases(session.Releases)
+
+	// The aggregator does not hold the parties' data in the networked mode
+	if parties != nil {
+		// Validation against the pooled data of the contributors in plaintext, with the shift computed from the true minimum
+		expectedMin, expectedMean, expectedStd := pooledLogStatistics(PartiesOf(parties, contributors))
+
+		fmt.Printf("\n")
+		fmt.Printf("Validation:\n")
+		fmt.Printf("Min: ")
+		PrintValues(expectedMin)
+		fmt.Printf("Mean: ")
+		PrintValues(expectedMean)
+		fmt.Printf("Std: ")
+		PrintValues(expectedStd)
+
+		session.Metrics.RecordPrecision("min", minValues, expectedMin)
+		session.Metrics.RecordPrecision("mean", meanValues, expectedMean)
+		session.Metrics.RecordPrecision("std", stdValues, expectedStd)
+
+		fmt.Printf("\n")
+		fmt.Printf("Precision:\n")
+		PrintPrecision(session.Metrics.Precision)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Metrics:\n")
+	PrintMetrics(session.Metrics)
+	if err = cf.WriteMetrics(session.Metrics); err != nil {
+		panic(err)
+	}
+}
+
+// Log scaling statistics of every feature: the global minimum, the shift it gives, and the mean and the inverse of the
+// standard deviation of log10(x + shift). The layout is the one of logLayout.
+func logStatistics(session *Session, layout *SlotLayout, bounds []float64) (minValues, shift, meanValues, invStdValues []float64, err error) {
+
+	// 2) Global minimum of each feature, compared under encryption as in the minmax command, and number of samples
+	minValues, numberOfSamples, err := globalMin(session, layout, bounds)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// log10(x + 1) as norms.py for non negative features, the shift of the other features moves their minimum to 1
+	shift = make([]float64, len(minValues))
+	for i, v := range minValues {
+		shift[i] = 1 - math.Min(v, 0)
+	}
+
+	// 3) Encryption of each party's sums of log10(Xi + shift)
+	logSumCiphertexts, err := session.Input(Query{Name: QueryLogSum, Args: shift})
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// 4) Homomorphic operations for the mean of the log values, decrypted for the parties
+	mean, noOfSamplesInverse, err := logMean(session, logSumCiphertexts, []*rlwe.Ciphertext{numberOfSamples})
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	meanValues, err = session.RevealValues(mean)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// 5) Each party returns its sums of (log10(Xi + shift) - mean)^2, the variance is their average
+	partialSumsCiphertexts, err := session.Input(Query{Name: QueryLogPartialSum, Args: append(append([]float64{}, shift...), meanValues[:len(shift)]...)})
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Variance... \n")
+
+	variance, err := session.Average(partialSumsCiphertexts, noOfSamplesInverse)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Inverse of the Standard Deviation... \n")
+
+	log2max, err := logVarianceLogMax(bounds, shift)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	invStd, err := session.InverseStd(variance, VarianceLogMin, log2max)
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+
+	// 6) Decryption of the inverse of the standard deviation, the variance itself is never decrypted
+	invStdValues, err = session.RevealValues(invStd)
+	return minValues, shift, meanValues, invStdValues, err
+}
+
+// Domain of the variance of the log values for InverseStd: log10(x + shift) lies in [0, log10(bound + shift)] for the
+// values within the bound, and its variance is at most the square of half this range
+func logVarianceLogMax(bounds, shift []float64) (float64, error) {
+	halfRanges := make([]float64, len(shift))
+	for i := range shift {
+		halfRanges[i] = math.Log10(bounds[i]+shift[i]) / 2
+	}
+	return VarianceLogBound(halfRanges)
+}
+
+// Minimum of each feature over the parties, revealed to choose the shift, and total number of samples of each feature,
+// kept encrypted in the slots of the features for the mean
+func globalMin(session *Session, layout *SlotLayout, bounds []float64) ([]float64, *rlwe.Ciphertext, error) {
+
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Min... \n")
+
+	packedCiphertexts, err := session.InputLayout(layout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The comparisons ignore the slots of the counts
+	minResult, err := session.Min(packedCiphertexts[0], layout.Spread(0, math.Inf(1), map[string][]float64{StatMin: bounds}))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	minResultValues, err := session.RevealValues(minResult)
+	if err != nil {
+		return nil, nil, err
+	}
+	minValues, err := layout.FeatureValues([][]float64{minResultValues}, StatMin)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// The slots past the features count one sample, so that the inverse of the count stays in its domain
+	total, err := session.Aggregate(packedCiphertexts[0]...)
+	if err != nil {
+		return nil, nil, err
+	}
+	numberOfSamples, err := session.Extract(layout, total, StatCount, 1)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return minValues, numberOfSamples, nil
+}
+
+// Slot layout of the first round: the numbers of samples and the minimums of the features, the counts come first so
+// that they are in the slots of the features without a rotation
+func logLayout(params ckks.Parameters, NFeatures int) (*SlotLayout, error) {
+	layout := NewSlotLayout(params.MaxSlots(), NFeatures)
+	for _, stat := range []string{StatCount, StatMin} {
+		if err := layout.Add(stat); err != nil {
+			return nil, err
+		}
+	}
+	return layout, nil
+}
+
+// Finding the mean of the log values of the encrypted features
+// mean = 1/N * sum(log10(Xi + shift))
+func logMean(session *Session, logSumCiphertexts, numberOfSamplesCiphertexts []*rlwe.Ciphertext) (mean, noOfSamplesInverse *rlwe.Ciphertext, err error) {
+
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Mean... \n")
+
+	if noOfSamplesInverse, err = session.CountInverse(numberOfSamplesCiphertexts); err != nil {
+		return nil, nil, err
+	}
+
+	if mean, err = session.Average(logSumCiphertexts, noOfSamplesInverse); err != nil {
+		return nil, nil, err
+	}
+
+	return mean, noOfSamplesInverse, nil
+}
+
+// Minimum of every feature and mean and standard deviation of log10(x + shift) over the samples of all the parties
+func pooledLogStatistics(parties []*Party) (minValues, mean, std []float64) {
+
+	pooled := PooledSamples(parties)
+	minValues = PooledMin(parties)[:len(pooled)]
+
+	mean = make([]float64, len(pooled))
+	std = make([]float64, len(pooled))
+
+	for j, samples := range pooled {
+		shift := 1 - math.Min(minValues[j], 0)
+
+		for _, x := range samples {
+			mean[j] += math.Log10(x + shift)
+		}
+		mean[j] /= float64(len(samples))
+
+		var variance float64
+		for _, x := range samples {
+			d := math.Log10(x+shift) - mean[j]
+			variance += d * d
+		}
+		variance /= float64(len(samples))
+
+		// Constant features are left unscaled, as by the protocol
+		std[j] = 1
+		if variance >= math.Exp2(ConstantVarianceLog) {
+			std[j] = math.Sqrt(variance)
+		}
+	}
+
+	return minValues, mean, std
+}
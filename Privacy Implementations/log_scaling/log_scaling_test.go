@@ -0,0 +1,80 @@
+package main
+
+import (
+	. "encryption/pkg"
+	"encryption/pkg/pkgtest"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Absolute error tolerated on the minimum and relative errors tolerated on the mean and on the standard deviation, which
+// carries the error of the inverse square root
+const (
+	minTolerance  = 1e-3
+	meanTolerance = 1e-4
+	stdTolerance  = 1e-2
+)
+
+// Parties holding 100*(i+1) samples of 4 normally distributed features of negative mean, which are shifted before the log
+func genNegativeParties(params ckks.Parameters, N int) []*Party {
+	datasets := make([]*Dataset, N)
+	for i := range datasets {
+		data := &Dataset{Features: make([]string, 4), Samples: make([][]float64, 4), Missing: make([]int, 4)}
+		for j := range data.Samples {
+			data.Features[j] = fmt.Sprintf("feature%d", j)
+			data.Samples[j] = make([]float64, 100*(i+1))
+			for k := range data.Samples[j] {
+				data.Samples[j][k] = -5.0*float64(j) + rand.NormFloat64()*float64(j+1)
+			}
+		}
+		datasets[i] = data
+	}
+
+	parties, err := NewParties(params, datasets)
+	if err != nil {
+		panic(err)
+	}
+	return parties
+}
+
+// Minimum, mean and standard deviation of the log values against the pooled data, for 2 and 4 parties
+func TestLogScaling(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		gen   func(params ckks.Parameters, N int) []*Party
+		N     int
+		short bool
+	}{
+		{"log-normal", GenPowerParties, 2, true},
+		{"negative", genNegativeParties, 4, false},
+	} {
+		t.Run(fmt.Sprintf("%s/parties=%d", tc.name, tc.N), func(t *testing.T) {
+			pkgtest.SkipLong(t, tc.short)
+			params := pkgtest.Params(t, 12)
+			parties := tc.gen(params, tc.N)
+			session := pkgtest.NewSession(t, params, parties, params.GaloisElementForComplexConjugation())
+
+			layout, err := logLayout(params, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			bounds := []float64{defaultBound, defaultBound, defaultBound, defaultBound}
+			minValues, _, meanValues, invStdValues, err := logStatistics(session, layout, bounds)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stdValues := make([]float64, len(invStdValues))
+			for i, v := range invStdValues {
+				stdValues[i] = 1 / v
+			}
+
+			expectedMin, expectedMean, expectedStd := pooledLogStatistics(parties)
+			pkgtest.CheckAbsolute(t, "min", minValues, expectedMin, minTolerance)
+			pkgtest.CheckRelative(t, "mean", meanValues, expectedMean, meanTolerance)
+			pkgtest.CheckRelative(t, "std", stdValues, expectedStd, stdTolerance)
+		})
+	}
+}
@@ -2,220 +2,203 @@ package main
 
 import (
 	. "encryption/pkg"
+	"flag"
 	"fmt"
 
-	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
-	"github.com/tuneinsight/lattigo/v6/circuits/ckks/comparison"
-	"github.com/tuneinsight/lattigo/v6/circuits/ckks/minimax"
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
-	"github.com/tuneinsight/lattigo/v6/utils/sampling"
 )
 
+// Default public bound of the absolute values of the features of CSV data
+const defaultBound = 10000.0
 
 func main() {
 
-	// Set encryption parameters for CKKS
-	var err error
-	var params ckks.Parameters
-
-	// LogN:15
-	if params, err = ckks.NewParametersFromLiteral(
-		ckks.ParametersLiteral{
-			LogN: 15,                                     			  				 // log2(ring degree)
-			LogQ: []int{55, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45}, // log2(primes Q) (ciphertext modulus)
-			LogP:            []int{61},                             				 // log2(primes P) (auxiliary modulus)
-			LogDefaultScale: 45,
-		}); err != nil {
+	cf := NewCommandFlags(flag.CommandLine)
+	boundList := flag.String("bounds", "", "comma separated public bound of the absolute values of each feature, 10000 for every feature if empty, and 10000 for the even and 1000 for the odd slots of the generated data")
+	flag.Parse()
+
+	bounds, err := ParseValues(*boundList)
+	if err != nil {
 		panic(err)
 	}
-	
 
-	crs, err := sampling.NewKeyedPRNG([]byte{'l', 'a', 't', 't', 'i', 'g', 'o'})
+	// Set encryption parameters for CKKS
+	params, err := cf.Params()
 	if err != nil {
 		panic(err)
 	}
 
-	// Number of parties
-	N := 4
-
-	// Create each party and their secret keys
-	parties := GenMinMaxParties(params, N)
-
-	// See the parties' inputs
-	PrintMinMaxPartyInputs(parties)
+	// A party process only holds its own data and secret key and answers the aggregator's rounds
+	if cf.Role == "party" {
+		if err = cf.ServeParty(params, GenMinMaxParty); err != nil {
+			panic(err)
+		}
+		return
+	}
 
-	// 1) Collective key generations
+	var parties []*Party
+	if cf.Role == "sim" {
+		// Create each party and their secret keys
+		if parties, err = cf.SimParties(params, GenMinMaxParties); err != nil {
+			panic(err)
+		}
 
-	// Collective Public Key
-	
-	pk := CollectiveKeyGen(params, crs, parties)
+		// See the parties' inputs
+		PrintMinMaxPartyInputs(parties)
+	}
 
+	cohort, closeCohort, err := cf.Cohort(params, parties)
+	if err != nil {
+		panic(err)
+	}
+	defer closeCohort()
 
-	// Collective Relinearization Key
-	
-	rlk := RelinearizationKeyGeneration(params, crs, parties)
+	session, err := NewSession(params, cohort, DefaultCRSSeed)
+	if err != nil {
+		panic(err)
+	}
 
+	// The generated data fills every slot, the features of CSV data are given by -data or -features
+	NFeatures := cf.NumFeatures(parties)
+	if cf.Data == "" && len(cf.FeatureNames()) == 0 {
+		NFeatures = params.MaxSlots()
+		if len(bounds) == 0 {
+			bounds = generatedBounds(params)
+		}
+	}
+	if len(bounds) == 0 {
+		bounds = make([]float64, NFeatures)
+		for i := range bounds {
+			bounds[i] = defaultBound
+		}
+	}
 
-	// Collective GaloisKeys generation
-	
-	galKeys := Gkgphase2(params, crs, parties, N)
+	// 1) Collective key generations
 
+	// Collective Public Key (published to the parties for encrypting their inputs), Relinearization Key,
+	// GaloisKey for the complex conjugation and Refresh Protocol
+	if err = session.Setup(params.GaloisElementForComplexConjugation()); err != nil {
+		panic(err)
+	}
 
-	// Evaluation Key
-	evk := rlwe.NewMemEvaluationKeySet(rlk, galKeys) 
+	// Threshold mode: the secret keys are shared so that the t active parties alone can decrypt and refresh
+	if err = cf.Thresholdize(session); err != nil {
+		panic(err)
+	}
 
-	// Refresh Protocol (instance of bootstrapping.Bootstrapper)
-	refresher := NewRefresher(params, parties, crs, N)
+	// The min and max are computed again without the parties that drop on the way, so that they aggregate the same parties
+	var minValues, maxValues []float64
+	contributors, err := session.Consistent(func() error {
+		// 2) Encryption of each party's float64 values
+		minCiphertexts, err := session.Input(Query{Name: QueryMin})
+		if err != nil {
+			return err
+		}
+		maxCiphertexts, err := session.Input(Query{Name: QueryMax})
+		if err != nil {
+			return err
+		}
 
-	// 2) Encryption of each party's float64 values
-	minCiphertexts, maxCiphertexts := EncryptMinMaxValues(params, pk, parties)
+		// 3) Homomorphic operations for finding min and max values
+		minResults, maxResults, err := findMinMax(session, minCiphertexts, maxCiphertexts, bounds, NFeatures)
+		if err != nil {
+			return err
+		}
 
-	// 3) Homomorphic operations for finding min and max values
-	minResults, maxResults := findMinMax(params, minCiphertexts, maxCiphertexts, evk, refresher, parties)
+		// 4) Collective decryption, by the active parties in the threshold mode
+		if minValues, err = session.RevealValues(minResults); err != nil {
+			return err
+		}
+		maxValues, err = session.RevealValues(maxResults)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
 
 	fmt.Printf("Min Result: \n")
-	TestCollectiveDecryption(params, minResults, parties)
+	PrintValues(minValues)
 	fmt.Printf("Max Result: \n")
-	TestCollectiveDecryption(params, maxResults, parties)
-
+	PrintValues(maxValues)
 
-}
-
-
-func findMinMax(params ckks.Parameters, minCiphertexts []*rlwe.Ciphertext, maxCiphertexts []*rlwe.Ciphertext, evk rlwe.EvaluationKeySet, btp bootstrapping.Bootstrapper, parties []*Party) (minResults *rlwe.Ciphertext, maxResults *rlwe.Ciphertext ) {
-	
 	fmt.Printf("\n")
-	fmt.Printf("Normalizing the data... \n")
-	
-	var err error
-
-	// Evaluator
-	eval := ckks.NewEvaluator(params, evk)
+	fmt.Printf("Contributors: %v\n", contributors)
+	PrintReleases(session.Releases)
+
+	// The aggregator does not hold the parties' data in the networked mode
+	if parties != nil {
+		// Validation against the min and max of every slot over the contributors in plaintext
+		contributing := PartiesOf(parties, contributors)
+		expectedMin, expectedMax := PooledMin(contributing), PooledMax(contributing)
+
+		fmt.Printf("\n")
+		fmt.Printf("Validation:\n")
+		fmt.Printf("Min: ")
+		PrintValues(expectedMin)
+		fmt.Printf("Max: ")
+		PrintValues(expectedMax)
+
+		session.Metrics.RecordPrecision("min", minValues, expectedMin)
+		session.Metrics.RecordPrecision("max", maxValues, expectedMax)
+
+		fmt.Printf("\n")
+		fmt.Printf("Precision:\n")
+		PrintPrecision(session.Metrics.Precision)
+	}
 
-	// Minimax evaluator
-	minimaxEvl := minimax.NewEvaluator(params, eval, btp)
+	fmt.Printf("\n")
+	fmt.Printf("Metrics:\n")
+	PrintMetrics(session.Metrics)
+	if err = cf.WriteMetrics(session.Metrics); err != nil {
+		panic(err)
+	}
+}
 
-	// Default polynomial for the comparison
-	polys := minimax.NewPolynomial(comparison.DefaultCompositePolynomialForSign)
 
-	// Comparison evaluator
-	CmpEval := comparison.NewEvaluator(params, minimaxEvl, polys)
-	
+// Default bounds of the generated data, which fills every slot: normalize the even slots with a max value of
+// normalizationFactor1 and the odd slots with a max value of normalizationFactor2
+func generatedBounds(params ckks.Parameters) []float64 {
 	normalizationFactor1 := 10000.0 // (1/10000)
 	normalizationFactor2 := 1000.0 // (1/1000)
 
-	// Normalize each feature based on given max values. In this case we fill with even number features with max values of normalizationFactor1 and odd number features with max values of normalizationFactor2
-	normalizationVector := make([]float64, params.MaxSlots())
-	for i := range normalizationVector {
+	bounds := make([]float64, params.MaxSlots())
+	for i := range bounds {
 		if i % 2 == 0 {
-			normalizationVector[i] = 1/normalizationFactor1
+			bounds[i] = normalizationFactor1
 		} else{
-			normalizationVector[i] = 1/normalizationFactor2
+			bounds[i] = normalizationFactor2
 		}
-		
 	}
+	return bounds
+}
 
+// Min and max of the first NFeatures slots over the parties, the values of slot i must lie in [-bounds[i], bounds[i]]
+func findMinMax(session *Session, minCiphertexts []*rlwe.Ciphertext, maxCiphertexts []*rlwe.Ciphertext, bounds []float64, NFeatures int) (minResults *rlwe.Ciphertext, maxResults *rlwe.Ciphertext, err error) {
 
-	// Normalize each feature of every client's min max inputs
-	minCiphertextsNormalized := make([]*rlwe.Ciphertext, len(minCiphertexts))
-	for i := range minCiphertextsNormalized {
-		minCiphertextsNormalized[i], err = eval.MulRelinNew(minCiphertexts[i], normalizationVector)
-		if err != nil {
-			panic(err)
-		}
-		if err = eval.Rescale(minCiphertextsNormalized[i], minCiphertextsNormalized[i]); err != nil {
-			panic(err)
-		}
+	if len(bounds) < NFeatures {
+		return nil, nil, fmt.Errorf("%d bounds for %d features", len(bounds), NFeatures)
 	}
 
-	maxCipherTextsNormalized := make([]*rlwe.Ciphertext, len(maxCiphertexts))
-	for i := range maxCipherTextsNormalized {
-		maxCipherTextsNormalized[i], err = eval.MulRelinNew(maxCiphertexts[i], normalizationVector)
-		if err != nil {
-			panic(err)
-		}
-		if err = eval.Rescale(maxCipherTextsNormalized[i], maxCipherTextsNormalized[i]); err != nil {
-			panic(err)
-		}
-	}
+	fmt.Printf("\n")
+	fmt.Printf("Normalizing the data... \n")
 
 
 	fmt.Printf("\n")
 	fmt.Printf("Finding the Min... \n")
 	// Finding the min value
-	var min *rlwe.Ciphertext
-	for i := range minCiphertextsNormalized {
-		if i == 0 {
-			min = minCiphertextsNormalized[i].CopyNew()
-		} else {
-			
-			min, err = CmpEval.Min(min, minCiphertextsNormalized[i])
-			if err != nil {
-				panic(err)
-			}
-
-		}
-		min, _ = btp.Bootstrap(min)
+	if minResults, err = session.Min(minCiphertexts, bounds); err != nil {
+		return nil, nil, err
 	}
 	
 
 	fmt.Printf("\n")
 	fmt.Printf("Finding the Max... \n")
 	// Finding the max value
-	var max *rlwe.Ciphertext
-	for i := range maxCipherTextsNormalized {
-		if i == 0 {
-			max = maxCipherTextsNormalized[i].CopyNew()
-		} else {
-			
-			max, err = CmpEval.Max(max, maxCipherTextsNormalized[i])
-			if err != nil {
-				panic(err)
-			}
-
-
-		}
-		
-		max, _ = btp.Bootstrap(max)
-
-	}
-	
-
-	min, _ = btp.Bootstrap(min)
-
-	max, _ = btp.Bootstrap(max)
-
-
-	// Renormalizing the min and max values
-	reverseNormalizationVector := make([]float64, params.MaxSlots())
-	for i := range reverseNormalizationVector {
-		if i % 2 == 0 {
-			reverseNormalizationVector[i] = normalizationFactor1
-		} else{
-			reverseNormalizationVector[i] = normalizationFactor2
-		}
-		
-	}
-
-	var normalizedMin *rlwe.Ciphertext
-	normalizedMin, err = eval.MulRelinNew(min, reverseNormalizationVector)
-	if err != nil {
-		panic(err)
-	}
-	if err = eval.Rescale(normalizedMin, normalizedMin); err != nil {
-		panic(err)
-	}
-
-	var normalizedMax *rlwe.Ciphertext
-	normalizedMax, err = eval.MulRelinNew(max, reverseNormalizationVector)
-	if err != nil {
-		panic(err)
-	}
-	if err = eval.Rescale(normalizedMax, normalizedMax); err != nil {
-		panic(err)
+	if maxResults, err = session.Max(maxCiphertexts, bounds); err != nil {
+		return nil, nil, err
 	}
 
 
-	return normalizedMin, normalizedMax
+	return minResults, maxResults, nil
 }
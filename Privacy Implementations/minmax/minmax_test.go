@@ -0,0 +1,93 @@
+package main
+
+import (
+	. "encryption/pkg"
+	"encryption/pkg/pkgtest"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Absolute error tolerated on the min and max, of values within the default bounds
+const minMaxTolerance = 1e-3
+
+// Parties whose values are within 1% of the default bounds of findMinMax, of alternating signs between the parties,
+// so that the differences compared reach twice the bounds
+func genBoundParties(params ckks.Parameters, N int) []*Party {
+	kgen := rlwe.NewKeyGenerator(params)
+	parties := make([]*Party, N)
+	for i := range parties {
+		pi := &Party{ID: i, Sk: kgen.GenSecretKeyNew()}
+		pi.MinValues = make([]float64, params.MaxSlots())
+		pi.MaxValues = make([]float64, params.MaxSlots())
+		for j := range pi.MinValues {
+			bound := 1000.0
+			if j%2 == 0 {
+				bound = 10000.0
+			}
+			sign := float64(1 - 2*((i+j)%2))
+			pi.MinValues[j] = sign * bound * (0.99 + 0.01*rand.Float64())
+			pi.MaxValues[j] = -pi.MinValues[j]
+		}
+		parties[i] = pi
+	}
+	return parties
+}
+
+// Min and max of every slot against the pooled min and max, for 2 to 6 parties
+func TestMinMax(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		gen   func(params ckks.Parameters, N int) []*Party
+		N     int
+		short bool
+	}{
+		{"generated", GenMinMaxParties, 2, true},
+		{"generated", GenMinMaxParties, 6, false},
+		{"near bounds", genBoundParties, 3, false},
+	} {
+		t.Run(fmt.Sprintf("%s/parties=%d", tc.name, tc.N), func(t *testing.T) {
+			pkgtest.SkipLong(t, tc.short)
+			params := pkgtest.Params(t, 12)
+			parties := tc.gen(params, tc.N)
+			session := pkgtest.NewSession(t, params, parties, params.GaloisElementForComplexConjugation())
+
+			minCiphertexts, err := session.Input(Query{Name: QueryMin})
+			if err != nil {
+				t.Fatal(err)
+			}
+			maxCiphertexts, err := session.Input(Query{Name: QueryMax})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// Default bounds of the generated data, 10000 in the even slots and 1000 in the odd slots
+			minResults, maxResults, err := findMinMax(session, minCiphertexts, maxCiphertexts, generatedBounds(params), params.MaxSlots())
+			if err != nil {
+				t.Fatal(err)
+			}
+			minValues, err := session.RevealValues(minResults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			maxValues, err := session.RevealValues(maxResults)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pkgtest.CheckAbsolute(t, "min", minValues, PooledMin(parties), minMaxTolerance)
+			pkgtest.CheckAbsolute(t, "max", maxValues, PooledMax(parties), minMaxTolerance)
+		})
+	}
+}
+
+// Fewer bounds than features are refused before any comparison
+func TestMinMaxMissingBounds(t *testing.T) {
+	params := pkgtest.Params(t, 12)
+	if _, _, err := findMinMax(nil, nil, nil, generatedBounds(params)[:3], 4); err == nil {
+		t.Fatal("3 bounds for 4 features: no error")
+	}
+}
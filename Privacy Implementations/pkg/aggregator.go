@@ -0,0 +1,398 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/multiparty"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Aggregator is the server side of the networked protocol. Each party runs ServeParty in its own process
+// and holds its own secret key, the aggregator only ever sees shares and ciphertexts.
+// It implements Cohort, so the protocol phases run unchanged over TCP.
+type Aggregator struct {
+	params   ckks.Parameters
+	listener net.Listener
+	parties  []*partyConn
+	session  SessionID
+	round    uint32
+
+	// Time a party has to answer a round, no limit if 0. A party that does not answer in time is dropped.
+	Timeout time.Duration
+}
+
+type partyConn struct {
+	conn    net.Conn
+	r       *bufio.Reader
+	dropped bool
+}
+
+// Listen starts an aggregator on addr, parties can connect as soon as it returns
+func Listen(params ckks.Parameters, addr string) (*Aggregator, error) {
+	session, err := NewSessionID()
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	return &Aggregator{params: params, listener: listener, session: session}, nil
+}
+
+// Time a connection has to send its hello to the aggregator
+const helloTimeout = 30 * time.Second
+
+// Accept waits until the N parties, with IDs 0 to N-1, have connected. Connections that do not send a well formed
+// hello within helloTimeout are closed. The hello of each connection is read in its own goroutine, so a silent
+// connection does not hold up the parties that connect after it.
+func (a *Aggregator) Accept(N int) error {
+	a.parties = make([]*partyConn, N)
+
+	type hello struct {
+		id int
+		pc *partyConn
+	}
+	hellos := make(chan hello)
+	acceptErr := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+
+	// The connections that arrive or finish their hello once the N parties are in are closed. The accepting goroutine
+	// returns at the next connection or when the aggregator is closed.
+	go func() {
+		for {
+			conn, err := a.listener.Accept()
+			if err != nil {
+				acceptErr <- err
+				return
+			}
+			select {
+			case <-done:
+				conn.Close()
+				return
+			default:
+			}
+
+			go func(conn net.Conn) {
+				pc := &partyConn{conn: conn, r: bufio.NewReader(conn)}
+
+				conn.SetReadDeadline(time.Now().Add(helloTimeout))
+				m, err := readMessage(pc.r, maxHelloSize)
+				if err != nil || m.Type != MsgHello {
+					conn.Close()
+					return
+				}
+				conn.SetReadDeadline(time.Time{})
+
+				select {
+				case hellos <- hello{id: m.PartyID, pc: pc}:
+				case <-done:
+					conn.Close()
+				}
+			}(conn)
+		}
+	}()
+
+	for connected := 0; connected < N; {
+		select {
+		case err := <-acceptErr:
+			return err
+		case h := <-hellos:
+			if h.id < 0 || h.id >= N || a.parties[h.id] != nil {
+				h.pc.conn.Close()
+				continue
+			}
+			a.parties[h.id] = h.pc
+			connected++
+		}
+	}
+
+	return nil
+}
+
+// NewAggregator listens on addr and waits until the N parties have connected
+func NewAggregator(params ckks.Parameters, addr string, N int) (*Aggregator, error) {
+	a, err := Listen(params, addr)
+	if err != nil {
+		return nil, err
+	}
+	if err = a.Accept(N); err != nil {
+		a.Close()
+		return nil, err
+	}
+	return a, nil
+}
+
+// Session returns the ID of the session run by the aggregator
+func (a *Aggregator) Session() SessionID {
+	return a.session
+}
+
+// Addr returns the address the aggregator listens on
+func (a *Aggregator) Addr() net.Addr {
+	return a.listener.Addr()
+}
+
+// Close ends the session of every party and stops listening
+func (a *Aggregator) Close() error {
+	for _, pc := range a.parties {
+		if pc != nil && !pc.dropped {
+			WriteMessage(pc.conn, &Message{Type: MsgDone, Session: a.session, Round: a.round, PartyID: AggregatorID})
+			pc.conn.Close()
+		}
+	}
+	return a.listener.Close()
+}
+
+func (a *Aggregator) Len() int {
+	return len(a.parties)
+}
+
+// Sends the request to every party and gathers the replies, in party order
+func (a *Aggregator) broadcast(req Message) ([]*Message, error) {
+	return a.broadcastTo(nil, req)
+}
+
+// Sends the request to the active parties only, every party if active is nil. The replies are in party order,
+// the parties that were not contacted have a nil reply.
+func (a *Aggregator) broadcastTo(active []int, req Message) ([]*Message, error) {
+	reqs := make([]*Message, len(a.parties))
+	if active == nil {
+		for i := range reqs {
+			reqs[i] = &req
+		}
+	}
+	for _, i := range active {
+		if i < 0 || i >= len(a.parties) {
+			return nil, fmt.Errorf("%w: no party %d among %d parties", ErrMissingShare, i, len(a.parties))
+		}
+		reqs[i] = &req
+	}
+	return a.exchange(reqs)
+}
+
+// Sends reqs[i] to party i and gathers the replies, in party order. The parties of a nil request are not contacted.
+func (a *Aggregator) exchange(reqs []*Message) ([]*Message, error) {
+	a.round++
+	for _, req := range reqs {
+		if req != nil {
+			req.Session = a.session
+			req.Round = a.round
+			req.PartyID = AggregatorID
+			req.NParties = len(a.parties)
+		}
+	}
+
+	replies := make([]*Message, len(a.parties))
+	errs := make([]error, len(a.parties))
+
+	var wg sync.WaitGroup
+	for i, pc := range a.parties {
+		if reqs[i] == nil {
+			continue
+		}
+		wg.Add(1)
+		go func(i int, pc *partyConn, req *Message) {
+			defer wg.Done()
+
+			if pc.dropped {
+				errs[i] = &PartyError{Party: i, Err: ErrDropped}
+				return
+			}
+			if a.Timeout > 0 {
+				pc.conn.SetDeadline(time.Now().Add(a.Timeout))
+			}
+
+			if err := WriteMessage(pc.conn, req); err != nil {
+				errs[i] = pc.drop(i, err)
+				return
+			}
+
+			reply, err := ReadMessage(pc.r)
+			if err != nil {
+				errs[i] = pc.drop(i, err)
+				return
+			}
+
+			switch {
+			case reply.Session != a.session:
+				errs[i] = &PartyError{Party: i, Err: fmt.Errorf("reply for session %s in session %s", reply.Session, a.session)}
+			case reply.Type == MsgError:
+				errs[i] = &PartyError{Party: i, Err: &remoteError{msg: reply.Error}}
+			case reply.Type != MsgShare || reply.Round != req.Round || reply.PartyID != i:
+				errs[i] = &PartyError{Party: i, Err: fmt.Errorf("unexpected reply to round %d", req.Round)}
+			default:
+				replies[i] = reply
+			}
+		}(i, pc, reqs[i])
+	}
+	wg.Wait()
+
+	// Every party that failed is reported, so that all the dropped parties are known after the round
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+
+	return replies, nil
+}
+
+// Closes the connection of a party that timed out or whose connection failed, a late reply would be read as the reply
+// of the next round. The party is not contacted again.
+func (pc *partyConn) drop(i int, err error) error {
+	pc.dropped = true
+	pc.conn.Close()
+	return &PartyError{Party: i, Err: fmt.Errorf("%w: %v", ErrDropped, err)}
+}
+
+// Broadcasts the request and unmarshals the share of every party
+func gatherShares[T any, PT interface {
+	*T
+	encoding.BinaryUnmarshaler
+}](a *Aggregator, req Message) ([]T, error) {
+	return gatherSharesFrom[T, PT](a, nil, req)
+}
+
+// Broadcasts the request to the active parties and unmarshals their shares, in the order of active
+func gatherSharesFrom[T any, PT interface {
+	*T
+	encoding.BinaryUnmarshaler
+}](a *Aggregator, active []int, req Message) ([]T, error) {
+
+	replies, err := a.broadcastTo(active, req)
+	if err != nil {
+		return nil, err
+	}
+
+	if active == nil {
+		active = make([]int, len(replies))
+		for i := range active {
+			active[i] = i
+		}
+	}
+
+	shares := make([]T, len(active))
+	for k, i := range active {
+		if err = unmarshalObjects(replies[i], PT(&shares[k])); err != nil {
+			return nil, &PartyError{Party: i, Err: err}
+		}
+	}
+	return shares, nil
+}
+
+func (a *Aggregator) PublicKeyGenRound(seed []byte) ([]multiparty.PublicKeyGenShare, error) {
+	return gatherShares[multiparty.PublicKeyGenShare](a, Message{Type: MsgPublicKeyGen, Seed: seed})
+}
+
+func (a *Aggregator) PublishPublicKey(pk *rlwe.PublicKey) error {
+	objects, err := marshalObjects(pk)
+	if err != nil {
+		return err
+	}
+	_, err = a.broadcast(Message{Type: MsgPublicKey, Objects: objects})
+	return err
+}
+
+func (a *Aggregator) RelinearizationKeyGenRoundOne(seed []byte) ([]multiparty.RelinearizationKeyGenShare, error) {
+	return gatherShares[multiparty.RelinearizationKeyGenShare](a, Message{Type: MsgRelinKeyGenRoundOne, Seed: seed})
+}
+
+func (a *Aggregator) RelinearizationKeyGenRoundTwo(round1 multiparty.RelinearizationKeyGenShare) ([]multiparty.RelinearizationKeyGenShare, error) {
+	objects, err := marshalObjects(round1)
+	if err != nil {
+		return nil, err
+	}
+	return gatherShares[multiparty.RelinearizationKeyGenShare](a, Message{Type: MsgRelinKeyGenRoundTwo, Objects: objects})
+}
+
+func (a *Aggregator) GaloisKeyGenRound(seed []byte, galEl uint64) ([]multiparty.GaloisKeyGenShare, error) {
+	return gatherShares[multiparty.GaloisKeyGenShare](a, Message{Type: MsgGaloisKeyGen, Seed: seed, GaloisElement: galEl})
+}
+
+func (a *Aggregator) PublicKeySwitchRound(active []int, tpk *rlwe.PublicKey, ct *rlwe.Ciphertext) ([]multiparty.PublicKeySwitchShare, error) {
+	objects, err := marshalObjects(tpk, ct)
+	if err != nil {
+		return nil, err
+	}
+	return gatherSharesFrom[multiparty.PublicKeySwitchShare](a, active, Message{Type: MsgPublicKeySwitch, Active: active, Objects: objects})
+}
+
+func (a *Aggregator) RefreshRound(active []int, seed []byte, ct *rlwe.Ciphertext) ([]multiparty.RefreshShare, error) {
+	objects, err := marshalObjects(ct)
+	if err != nil {
+		return nil, err
+	}
+	return gatherSharesFrom[multiparty.RefreshShare](a, active, Message{Type: MsgRefresh, Active: active, Seed: seed, Objects: objects})
+}
+
+func (a *Aggregator) ThresholdKeyExchangeRound() ([]ExchangeKey, error) {
+	return gatherShares[ExchangeKey](a, Message{Type: MsgThresholdKeyExchange})
+}
+
+func (a *Aggregator) ShamirShareRound(threshold int, keys []ExchangeKey) ([][]SealedShare, error) {
+	objects := make([]Object, len(keys))
+	for i := range keys {
+		var err error
+		if objects[i], err = NewObject(keys[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	replies, err := a.broadcast(Message{Type: MsgShamirShares, Threshold: threshold, Objects: objects})
+	if err != nil {
+		return nil, err
+	}
+
+	// Party i sends one sealed share per recipient, in party order
+	sealed := make([][]SealedShare, len(replies))
+	for i, reply := range replies {
+		if len(reply.Objects) != len(a.parties) {
+			return nil, &PartyError{Party: i, Err: fmt.Errorf("%w: %d sealed shares for %d parties", ErrMissingShare, len(reply.Objects), len(a.parties))}
+		}
+		sealed[i] = make([]SealedShare, len(reply.Objects))
+		for j, obj := range reply.Objects {
+			if err = obj.Decode(&sealed[i][j]); err != nil {
+				return nil, &PartyError{Party: i, Err: err}
+			}
+		}
+	}
+	return sealed, nil
+}
+
+func (a *Aggregator) ShamirAggregateRound(sealed [][]SealedShare) error {
+
+	// Party j receives the shares sealed for it by every party, in party order
+	reqs := make([]*Message, len(a.parties))
+	for j := range reqs {
+		reqs[j] = &Message{Type: MsgShamirAggregate, Objects: make([]Object, len(sealed))}
+		for i := range sealed {
+			var err error
+			if reqs[j].Objects[i], err = NewObject(sealed[i][j]); err != nil {
+				return err
+			}
+		}
+	}
+
+	_, err := a.exchange(reqs)
+	return err
+}
+
+func (a *Aggregator) InputRound(parties []int, q Query) ([]*rlwe.Ciphertext, error) {
+	cts, err := gatherSharesFrom[rlwe.Ciphertext](a, parties, Message{Type: MsgInput, Query: q})
+	if err != nil {
+		return nil, err
+	}
+
+	ptrs := make([]*rlwe.Ciphertext, len(cts))
+	for i := range cts {
+		ptrs[i] = &cts[i]
+	}
+	return ptrs, nil
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Sweeps of the benchmarks, the ring degrees are below the 2^15 of the commands to keep a sweep short
+var (
+	benchParties = []int{2, 4, 8}
+	benchLogN    = []int{12, 13, 14}
+)
+
+// Runs f for every number of parties and ring degree of the sweep, with a session set up on generated parties and
+// with the Galois keys of the Galois elements galEls gives for the parameters
+func benchSweep(b *testing.B, gen func(params ckks.Parameters, N int) []*Party, galEls func(params ckks.Parameters) []uint64, f func(b *testing.B, s *Session)) {
+	for _, logN := range benchLogN {
+		for _, N := range benchParties {
+			b.Run(fmt.Sprintf("LogN=%d/parties=%d", logN, N), func(b *testing.B) {
+				params, err := ckks.NewParametersFromLiteral(ParametersLiteralLogN(logN))
+				if err != nil {
+					b.Fatal(err)
+				}
+				s, err := NewSession(params, NewLocalCohort(params, gen(params, N)), DefaultCRSSeed)
+				if err != nil {
+					b.Fatal(err)
+				}
+				var els []uint64
+				if galEls != nil {
+					els = galEls(params)
+				}
+				if err = s.Setup(els...); err != nil {
+					b.Fatal(err)
+				}
+				f(b, s)
+			})
+		}
+	}
+}
+
+// Reports the bytes of the rounds run since the metrics had rounds rounds, per iteration
+func reportTraffic(b *testing.B, m *Metrics, rounds int) {
+	var upload, download int
+	for _, r := range m.Rounds[rounds:] {
+		upload += total(r.Upload)
+		download += total(r.Download)
+	}
+	b.ReportMetric(float64(upload)/float64(b.N), "upload-B/op")
+	b.ReportMetric(float64(download)/float64(b.N), "download-B/op")
+}
+
+func BenchmarkSetup(b *testing.B) {
+	benchSweep(b, GenZscoreParties, nil, func(b *testing.B, s *Session) {
+		rounds := len(s.Metrics.Rounds)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if err := s.Setup(); err != nil {
+				b.Fatal(err)
+			}
+		}
+		reportTraffic(b, s.Metrics, rounds)
+	})
+}
+
+func BenchmarkReveal(b *testing.B) {
+	benchSweep(b, GenZscoreParties, nil, func(b *testing.B, s *Session) {
+		cts, err := s.Input(Query{Name: QueryZscoreSum})
+		if err != nil {
+			b.Fatal(err)
+		}
+		rounds := len(s.Metrics.Rounds)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err = s.RevealValues(cts[0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		reportTraffic(b, s.Metrics, rounds)
+	})
+}
+
+func BenchmarkRefresh(b *testing.B) {
+	benchSweep(b, GenZscoreParties, nil, func(b *testing.B, s *Session) {
+		cts, err := s.Input(Query{Name: QueryZscoreSum})
+		if err != nil {
+			b.Fatal(err)
+		}
+		rounds := len(s.Metrics.Rounds)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err = s.Refresher.Refresh(cts[0]); err != nil {
+				b.Fatal(err)
+			}
+		}
+		reportTraffic(b, s.Metrics, rounds)
+	})
+}
+
+// One round z-score: mean and inverse standard deviation from the sums, sums of squares and counts
+func BenchmarkZscore(b *testing.B) {
+	benchSweep(b, GenZscoreParties, nil, func(b *testing.B, s *Session) {
+		rounds := len(s.Metrics.Rounds)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			var cts [3][]*rlwe.Ciphertext
+			for k, name := range []string{QueryZscoreSum, QueryZscoreSumSquares, QueryZscoreCount} {
+				var err error
+				if cts[k], err = s.Input(Query{Name: name}); err != nil {
+					b.Fatal(err)
+				}
+			}
+			countInverse, err := s.CountInverse(cts[2])
+			if err != nil {
+				b.Fatal(err)
+			}
+			mean, err := s.Average(cts[0], countInverse)
+			if err != nil {
+				b.Fatal(err)
+			}
+			variance, err := s.VarianceFromSquares(cts[1], mean, countInverse)
+			if err != nil {
+				b.Fatal(err)
+			}
+			invStd, err := s.InverseStd(variance, VarianceLogMin, VarianceLogMax)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err = s.RevealValues(mean); err != nil {
+				b.Fatal(err)
+			}
+			if _, err = s.RevealValues(invStd); err != nil {
+				b.Fatal(err)
+			}
+		}
+		reportTraffic(b, s.Metrics, rounds)
+	})
+}
+
+// Galois element of the complex conjugation of the comparisons of Min and Max
+func conjugation(params ckks.Parameters) []uint64 {
+	return []uint64{params.GaloisElementForComplexConjugation()}
+}
+
+// Secure minimum of the generated values, within 1000 in the even slots and 100 in the odd slots
+func BenchmarkMin(b *testing.B) {
+	benchSweep(b, GenMinMaxParties, conjugation, func(b *testing.B, s *Session) {
+		bounds := make([]float64, s.Params.MaxSlots())
+		for j := range bounds {
+			bounds[j] = 100
+			if j%2 == 0 {
+				bounds[j] = 1000
+			}
+		}
+		rounds := len(s.Metrics.Rounds)
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			cts, err := s.Input(Query{Name: QueryMin})
+			if err != nil {
+				b.Fatal(err)
+			}
+			min, err := s.Min(cts, bounds)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if _, err = s.RevealValues(min); err != nil {
+				b.Fatal(err)
+			}
+		}
+		reportTraffic(b, s.Metrics, rounds)
+	})
+}
@@ -0,0 +1,323 @@
+package pkg
+
+import (
+	"bufio"
+	"encoding"
+	"fmt"
+	"net"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/multiparty"
+	"github.com/tuneinsight/lattigo/v6/multiparty/mpckks"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// ServeParty connects the party to the aggregator listening on addr and answers the protocol rounds
+// until the aggregator ends the session. The party's secret key never leaves this process.
+func ServeParty(params ckks.Parameters, addr string, p *Party) error {
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+
+	if err = WriteMessage(conn, &Message{Type: MsgHello, PartyID: p.ID}); err != nil {
+		return err
+	}
+
+	// The party joins the session of the first request it receives
+	var session SessionID
+	joined := false
+
+	for {
+		req, err := ReadMessage(r)
+		if err != nil {
+			return err
+		}
+
+		if !joined {
+			session, joined = req.Session, true
+		} else if req.Session != session {
+			return fmt.Errorf("party %d: request for session %s in session %s", p.ID, req.Session, session)
+		}
+
+		if req.Type == MsgDone {
+			return nil
+		}
+
+		reply, err := p.handle(params, req)
+		if err != nil {
+			reply = &Message{Type: MsgError, Error: err.Error()}
+		}
+		reply.Session = session
+		reply.Round = req.Round
+		reply.PartyID = p.ID
+
+		if err = WriteMessage(conn, reply); err != nil {
+			return err
+		}
+	}
+}
+
+// Runs the party side of one protocol round
+func (p *Party) handle(params ckks.Parameters, req *Message) (*Message, error) {
+
+	var share encoding.BinaryMarshaler
+	var err error
+
+	switch req.Type {
+	case MsgPublicKeyGen:
+		share, err = p.genPublicKeyGenShare(params, req.Seed)
+
+	case MsgPublicKey:
+		pk := rlwe.NewPublicKey(params)
+		if err = unmarshalObjects(req, pk); err != nil {
+			return nil, err
+		}
+		if err = checkPublicKey(params, pk); err != nil {
+			return nil, err
+		}
+		p.pk = pk
+		return &Message{Type: MsgShare}, nil
+
+	case MsgRelinKeyGenRoundOne:
+		share, err = p.genRelinearizationKeyGenShareRoundOne(params, req.Seed)
+
+	case MsgRelinKeyGenRoundTwo:
+		var round1 multiparty.RelinearizationKeyGenShare
+		if err = unmarshalObjects(req, &round1); err != nil {
+			return nil, err
+		}
+		share, err = p.genRelinearizationKeyGenShareRoundTwo(params, round1)
+
+	case MsgGaloisKeyGen:
+		share, err = p.genGaloisKeyGenShare(params, req.Seed, req.GaloisElement)
+
+	case MsgPublicKeySwitch:
+		tpk, ct := rlwe.NewPublicKey(params), new(rlwe.Ciphertext)
+		if err = unmarshalObjects(req, tpk, ct); err != nil {
+			return nil, err
+		}
+		share, err = p.genPublicKeySwitchShare(params, tpk, ct, req.Active)
+
+	case MsgRefresh:
+		ct := new(rlwe.Ciphertext)
+		if err = unmarshalObjects(req, ct); err != nil {
+			return nil, err
+		}
+		// The refresh masks are drawn for the number of parties taking part in it
+		N := req.NParties
+		if req.Active != nil {
+			N = len(req.Active)
+		}
+		share, err = p.genRefreshShare(params, req.Seed, ct, N, req.Active)
+
+	case MsgInput:
+		share, err = p.encryptAnswer(params, req.Query)
+
+	case MsgThresholdKeyExchange:
+		share, err = p.genExchangeKey()
+
+	case MsgShamirShares:
+		keys := make([]ExchangeKey, len(req.Objects))
+		for i := range keys {
+			if err = req.Objects[i].Decode(&keys[i]); err != nil {
+				return nil, err
+			}
+		}
+		sealed, err := p.genShamirShares(params, req.Threshold, keys)
+		if err != nil {
+			return nil, err
+		}
+
+		reply := &Message{Type: MsgShare, Objects: make([]Object, len(sealed))}
+		for j := range sealed {
+			if reply.Objects[j], err = NewObject(sealed[j]); err != nil {
+				return nil, err
+			}
+		}
+		return reply, nil
+
+	case MsgShamirAggregate:
+		sealed := make([]SealedShare, len(req.Objects))
+		for i := range sealed {
+			if err = req.Objects[i].Decode(&sealed[i]); err != nil {
+				return nil, err
+			}
+		}
+		if err = p.aggregateShamirShares(params, sealed); err != nil {
+			return nil, err
+		}
+		return &Message{Type: MsgShare}, nil
+
+	default:
+		return nil, fmt.Errorf("unexpected message type %d", req.Type)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	reply := &Message{Type: MsgShare}
+	if reply.Objects, err = marshalObjects(share); err != nil {
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (p *Party) genPublicKeyGenShare(params ckks.Parameters, seed []byte) (multiparty.PublicKeyGenShare, error) {
+	prng, err := newKeyedPRNG(seed)
+	if err != nil {
+		return multiparty.PublicKeyGenShare{}, err
+	}
+
+	ckg := multiparty.NewPublicKeyGenProtocol(params)
+	crp := ckg.SampleCRP(prng)
+
+	p.ckgShare = ckg.AllocateShare()
+	ckg.GenShare(p.Sk, crp, &p.ckgShare)
+	return p.ckgShare, nil
+}
+
+func (p *Party) genRelinearizationKeyGenShareRoundOne(params ckks.Parameters, seed []byte) (multiparty.RelinearizationKeyGenShare, error) {
+	prng, err := newKeyedPRNG(seed)
+	if err != nil {
+		return multiparty.RelinearizationKeyGenShare{}, err
+	}
+
+	rkg := multiparty.NewRelinearizationKeyGenProtocol(params)
+	crp := rkg.SampleCRP(prng)
+
+	p.rlkEphemSk, p.rkgShareOne, p.rkgShareTwo = rkg.AllocateShare()
+	rkg.GenShareRoundOne(p.Sk, crp, p.rlkEphemSk, &p.rkgShareOne)
+	return p.rkgShareOne, nil
+}
+
+func (p *Party) genRelinearizationKeyGenShareRoundTwo(params ckks.Parameters, round1 multiparty.RelinearizationKeyGenShare) (multiparty.RelinearizationKeyGenShare, error) {
+	if p.rlkEphemSk == nil {
+		return multiparty.RelinearizationKeyGenShare{}, fmt.Errorf("relinearization key round two before round one")
+	}
+
+	rkg := multiparty.NewRelinearizationKeyGenProtocol(params)
+	rkg.GenShareRoundTwo(p.rlkEphemSk, p.Sk, round1, &p.rkgShareTwo)
+	return p.rkgShareTwo, nil
+}
+
+func (p *Party) genGaloisKeyGenShare(params ckks.Parameters, seed []byte, galEl uint64) (multiparty.GaloisKeyGenShare, error) {
+	prng, err := newKeyedPRNG(seed)
+	if err != nil {
+		return multiparty.GaloisKeyGenShare{}, err
+	}
+
+	gkg := multiparty.NewGaloisKeyGenProtocol(params)
+	crp := gkg.SampleCRP(prng)
+
+	p.gkgShare = gkg.AllocateShare()
+	err = gkg.GenShare(p.Sk, galEl, crp, &p.gkgShare)
+	return p.gkgShare, err
+}
+
+func (p *Party) genPublicKeySwitchShare(params ckks.Parameters, tpk *rlwe.PublicKey, ct *rlwe.Ciphertext, active []int) (multiparty.PublicKeySwitchShare, error) {
+	sk, err := p.activeSecretKey(params, active)
+	if err != nil {
+		return multiparty.PublicKeySwitchShare{}, err
+	}
+	if err := checkPublicKey(params, tpk); err != nil {
+		return multiparty.PublicKeySwitchShare{}, err
+	}
+	if err := checkCiphertext(params, ct); err != nil {
+		return multiparty.PublicKeySwitchShare{}, err
+	}
+
+	pcks, err := newPublicKeySwitchProtocol(params)
+	if err != nil {
+		return multiparty.PublicKeySwitchShare{}, err
+	}
+
+	p.pcksShare = pcks.AllocateShare(ct.Level())
+	pcks.GenShare(sk, tpk, ct, &p.pcksShare)
+	return p.pcksShare, nil
+}
+
+func (p *Party) genRefreshShare(params ckks.Parameters, seed []byte, ct *rlwe.Ciphertext, N int, active []int) (multiparty.RefreshShare, error) {
+	sk, err := p.activeSecretKey(params, active)
+	if err != nil {
+		return multiparty.RefreshShare{}, err
+	}
+
+	minLevel, logBound, ok := mpckks.GetMinimumLevelForRefresh(128, params.DefaultScale(), N, params.Q())
+	if !ok {
+		return multiparty.RefreshShare{}, fmt.Errorf("%w: the parameters cannot refresh with 128 bit security", ErrInsufficientLevel)
+	}
+	if err := checkCiphertext(params, ct); err != nil {
+		return multiparty.RefreshShare{}, err
+	}
+	if ct.Level() < minLevel {
+		return multiparty.RefreshShare{}, fmt.Errorf("%w: ciphertext at level %d, refresh needs %d", ErrInsufficientLevel, ct.Level(), minLevel)
+	}
+
+	if p.RefreshProtocol, err = mpckks.NewRefreshProtocol(params, logBound, params.Xe()); err != nil {
+		return multiparty.RefreshShare{}, err
+	}
+
+	prng, err := newKeyedPRNG(seed)
+	if err != nil {
+		return multiparty.RefreshShare{}, err
+	}
+	crp := p.SampleCRP(params.MaxLevel(), prng)
+
+	p.refreshShare = p.AllocateShare(minLevel, params.MaxLevel())
+	err = p.GenShare(sk, logBound, ct, crp, &p.refreshShare)
+	return p.refreshShare, err
+}
+
+func (p *Party) encryptAnswer(params ckks.Parameters, q Query) (*rlwe.Ciphertext, error) {
+	values, err := p.Answer(q)
+	if err != nil {
+		return nil, err
+	}
+	return p.Encrypt(params, values)
+}
+
+// Encrypt encrypts the party's values under the collective public key the party received in the CKG phase
+func (p *Party) Encrypt(params ckks.Parameters, values []float64) (*rlwe.Ciphertext, error) {
+	if p.pk == nil {
+		return nil, &PartyError{Party: p.ID, Err: fmt.Errorf("%w: encryption before the collective public key was published", ErrMismatchedParameters)}
+	}
+
+	ct, err := encryptValues(params, p.pk, values)
+	if err != nil {
+		return nil, &PartyError{Party: p.ID, Err: err}
+	}
+	return ct, nil
+}
+
+// ShareMessage wraps the share the party produced in the last round of type t into a message,
+// so that it can be written with WriteMessage to another process or to disk
+func (p *Party) ShareMessage(t MessageType, session SessionID, round uint32) (*Message, error) {
+	var share encoding.BinaryMarshaler
+	switch t {
+	case MsgPublicKeyGen:
+		share = &p.ckgShare
+	case MsgRelinKeyGenRoundOne:
+		share = &p.rkgShareOne
+	case MsgRelinKeyGenRoundTwo:
+		share = &p.rkgShareTwo
+	case MsgGaloisKeyGen:
+		share = &p.gkgShare
+	case MsgPublicKeySwitch:
+		share = &p.pcksShare
+	case MsgRefresh:
+		share = &p.refreshShare
+	default:
+		return nil, fmt.Errorf("party %d: no share is kept for message type %d", p.ID, t)
+	}
+
+	objects, err := marshalObjects(share)
+	if err != nil {
+		return nil, err
+	}
+	return &Message{Type: MsgShare, Session: session, Round: round, PartyID: p.ID, Objects: objects}, nil
+}
@@ -0,0 +1,309 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/multiparty"
+	"github.com/tuneinsight/lattigo/v6/ring"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+	"github.com/tuneinsight/lattigo/v6/utils/sampling"
+)
+
+// Names of the party side computations that can be requested with an input round
+const (
+	QueryZscoreSum         = "zscore/sum"
+	QueryZscoreCount       = "zscore/count"
+	QueryZscorePartialSum  = "zscore/partial-sum"
+	QueryZscoreSumSquares  = "zscore/sum-squares"
+	QueryZscoreSamples     = "zscore/samples"
+	QueryMin               = "minmax/min"
+	QueryMax               = "minmax/max"
+	QueryRobustCount       = "robust/count"
+	QueryRobustLeft        = "robust/left"
+	QueryRobustRight       = "robust/right"
+	QueryRobustInterval    = "robust/interval"
+	QueryRobustIntervalSum = "robust/interval-sum"
+	QueryRobustHistogram   = "robust/histogram"
+	QueryPacked            = "packed"
+	QueryPowerLogSum       = "power/log-sum"
+	QueryPowerSum          = "power/sum"
+	QueryPowerSumSquares   = "power/sum-squares"
+	QueryPowerCount        = "power/count"
+	QueryLogSum            = "log/sum"
+	QueryLogPartialSum     = "log/partial-sum"
+)
+
+// Query names a party side computation, Args are the public values the aggregator sends along (e.g. the decrypted mean)
+type Query struct {
+	Name string
+	Args []float64
+}
+
+// Cohort is the aggregator's view of the parties. Each method is one message round of the protocol:
+// the request is sent to every party and one share (or ciphertext) per party is gathered, in party order.
+// Common reference polynomials are sampled by both sides from the seed sent with the request.
+type Cohort interface {
+	// Number of parties
+	Len() int
+
+	PublicKeyGenRound(seed []byte) ([]multiparty.PublicKeyGenShare, error)
+	PublishPublicKey(pk *rlwe.PublicKey) error
+
+	RelinearizationKeyGenRoundOne(seed []byte) ([]multiparty.RelinearizationKeyGenShare, error)
+	RelinearizationKeyGenRoundTwo(round1 multiparty.RelinearizationKeyGenShare) ([]multiparty.RelinearizationKeyGenShare, error)
+
+	GaloisKeyGenRound(seed []byte, galEl uint64) ([]multiparty.GaloisKeyGenShare, error)
+
+	// Only the active parties take part in the key switch and the refresh, and their shares are in the order of active.
+	// Every party takes part with its secret key if active is nil, else the active parties combine their threshold shares.
+	PublicKeySwitchRound(active []int, tpk *rlwe.PublicKey, ct *rlwe.Ciphertext) ([]multiparty.PublicKeySwitchShare, error)
+	RefreshRound(active []int, seed []byte, ct *rlwe.Ciphertext) ([]multiparty.RefreshShare, error)
+
+	// Threshold setup, see Session.Thresholdize: each party publishes an exchange key, then sends one sealed Shamir share
+	// of its secret key to every party, and each party aggregates the shares it received
+	ThresholdKeyExchangeRound() ([]ExchangeKey, error)
+	ShamirShareRound(threshold int, keys []ExchangeKey) ([][]SealedShare, error)
+	ShamirAggregateRound(sealed [][]SealedShare) error
+
+	// Each of the parties (every party if nil) answers the query and uploads its result encrypted under the collective
+	// public key, the ciphertexts are in the order of parties
+	InputRound(parties []int, q Query) ([]*rlwe.Ciphertext, error)
+}
+
+// LocalCohort runs every party in the aggregator's process, this is the simulation mode of the commands
+type LocalCohort struct {
+	params  ckks.Parameters
+	Parties []*Party
+
+	// Simulated dropouts: party i stops answering after it answered DropAfter[i] input rounds
+	DropAfter map[int]int
+	inputs    map[int]int
+}
+
+func NewLocalCohort(params ckks.Parameters, parties []*Party) *LocalCohort {
+	return &LocalCohort{params: params, Parties: parties, inputs: make(map[int]int)}
+}
+
+func (c *LocalCohort) Len() int {
+	return len(c.Parties)
+}
+
+func (c *LocalCohort) PublicKeyGenRound(seed []byte) ([]multiparty.PublicKeyGenShare, error) {
+	if err := c.dropped(nil); err != nil {
+		return nil, err
+	}
+	shares := make([]multiparty.PublicKeyGenShare, len(c.Parties))
+	for i, pi := range c.Parties {
+		var err error
+		if shares[i], err = pi.genPublicKeyGenShare(c.params, seed); err != nil {
+			return nil, partyError(i, err)
+		}
+	}
+	return shares, nil
+}
+
+func (c *LocalCohort) PublishPublicKey(pk *rlwe.PublicKey) error {
+	if err := c.dropped(nil); err != nil {
+		return err
+	}
+	for _, pi := range c.Parties {
+		pi.pk = pk
+	}
+	return nil
+}
+
+func (c *LocalCohort) RelinearizationKeyGenRoundOne(seed []byte) ([]multiparty.RelinearizationKeyGenShare, error) {
+	if err := c.dropped(nil); err != nil {
+		return nil, err
+	}
+	shares := make([]multiparty.RelinearizationKeyGenShare, len(c.Parties))
+	for i, pi := range c.Parties {
+		var err error
+		if shares[i], err = pi.genRelinearizationKeyGenShareRoundOne(c.params, seed); err != nil {
+			return nil, partyError(i, err)
+		}
+	}
+	return shares, nil
+}
+
+func (c *LocalCohort) RelinearizationKeyGenRoundTwo(round1 multiparty.RelinearizationKeyGenShare) ([]multiparty.RelinearizationKeyGenShare, error) {
+	if err := c.dropped(nil); err != nil {
+		return nil, err
+	}
+	shares := make([]multiparty.RelinearizationKeyGenShare, len(c.Parties))
+	for i, pi := range c.Parties {
+		var err error
+		if shares[i], err = pi.genRelinearizationKeyGenShareRoundTwo(c.params, round1); err != nil {
+			return nil, partyError(i, err)
+		}
+	}
+	return shares, nil
+}
+
+func (c *LocalCohort) GaloisKeyGenRound(seed []byte, galEl uint64) ([]multiparty.GaloisKeyGenShare, error) {
+	if err := c.dropped(nil); err != nil {
+		return nil, err
+	}
+	shares := make([]multiparty.GaloisKeyGenShare, len(c.Parties))
+	for i, pi := range c.Parties {
+		var err error
+		if shares[i], err = pi.genGaloisKeyGenShare(c.params, seed, galEl); err != nil {
+			return nil, partyError(i, err)
+		}
+	}
+	return shares, nil
+}
+
+func (c *LocalCohort) PublicKeySwitchRound(active []int, tpk *rlwe.PublicKey, ct *rlwe.Ciphertext) ([]multiparty.PublicKeySwitchShare, error) {
+	if err := c.dropped(active); err != nil {
+		return nil, err
+	}
+	parties, err := c.active(active)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]multiparty.PublicKeySwitchShare, len(parties))
+	for k, pi := range parties {
+		if shares[k], err = pi.genPublicKeySwitchShare(c.params, tpk, ct, active); err != nil {
+			return nil, partyError(pi.ID, err)
+		}
+	}
+	return shares, nil
+}
+
+func (c *LocalCohort) RefreshRound(active []int, seed []byte, ct *rlwe.Ciphertext) ([]multiparty.RefreshShare, error) {
+	if err := c.dropped(active); err != nil {
+		return nil, err
+	}
+	parties, err := c.active(active)
+	if err != nil {
+		return nil, err
+	}
+	shares := make([]multiparty.RefreshShare, len(parties))
+	for k, pi := range parties {
+		if shares[k], err = pi.genRefreshShare(c.params, seed, ct, len(parties), active); err != nil {
+			return nil, partyError(pi.ID, err)
+		}
+	}
+	return shares, nil
+}
+
+func (c *LocalCohort) ThresholdKeyExchangeRound() ([]ExchangeKey, error) {
+	if err := c.dropped(nil); err != nil {
+		return nil, err
+	}
+	keys := make([]ExchangeKey, len(c.Parties))
+	for i, pi := range c.Parties {
+		var err error
+		if keys[i], err = pi.genExchangeKey(); err != nil {
+			return nil, partyError(i, err)
+		}
+	}
+	return keys, nil
+}
+
+func (c *LocalCohort) ShamirShareRound(threshold int, keys []ExchangeKey) ([][]SealedShare, error) {
+	if err := c.dropped(nil); err != nil {
+		return nil, err
+	}
+	sealed := make([][]SealedShare, len(c.Parties))
+	for i, pi := range c.Parties {
+		var err error
+		if sealed[i], err = pi.genShamirShares(c.params, threshold, keys); err != nil {
+			return nil, partyError(i, err)
+		}
+	}
+	return sealed, nil
+}
+
+func (c *LocalCohort) ShamirAggregateRound(sealed [][]SealedShare) error {
+	if err := c.dropped(nil); err != nil {
+		return err
+	}
+	for j, pj := range c.Parties {
+		received := make([]SealedShare, len(sealed))
+		for i := range sealed {
+			received[i] = sealed[i][j]
+		}
+		if err := pj.aggregateShamirShares(c.params, received); err != nil {
+			return partyError(j, err)
+		}
+	}
+	return nil
+}
+
+func (c *LocalCohort) InputRound(parties []int, q Query) ([]*rlwe.Ciphertext, error) {
+	if err := c.dropped(parties); err != nil {
+		return nil, err
+	}
+	active, err := c.active(parties)
+	if err != nil {
+		return nil, err
+	}
+	cts := make([]*rlwe.Ciphertext, len(active))
+	for k, pi := range active {
+		if cts[k], err = pi.encryptAnswer(c.params, q); err != nil {
+			return nil, partyError(pi.ID, err)
+		}
+	}
+	if c.inputs == nil {
+		c.inputs = make(map[int]int)
+	}
+	for _, pi := range active {
+		c.inputs[pi.ID]++
+	}
+	return cts, nil
+}
+
+// Reports every party among ids (every party if nil) that dropped, see DropAfter
+func (c *LocalCohort) dropped(ids []int) error {
+	if ids == nil {
+		ids = make([]int, len(c.Parties))
+		for i := range ids {
+			ids[i] = i
+		}
+	}
+
+	var errs []error
+	for _, i := range ids {
+		if n, ok := c.DropAfter[i]; ok && c.inputs[i] >= n {
+			errs = append(errs, &PartyError{Party: i, Err: ErrDropped})
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Parties of the active IDs, every party if active is nil
+func (c *LocalCohort) active(active []int) ([]*Party, error) {
+	if active == nil {
+		return c.Parties, nil
+	}
+	parties := make([]*Party, len(active))
+	for k, i := range active {
+		if i < 0 || i >= len(c.Parties) {
+			return nil, fmt.Errorf("%w: no party %d among %d parties", ErrMissingShare, i, len(c.Parties))
+		}
+		parties[k] = c.Parties[i]
+	}
+	return parties, nil
+}
+
+// Draws a fresh seed from the common reference string for the common reference polynomials of one phase
+func newSeed(crs sampling.PRNG) ([]byte, error) {
+	seed := make([]byte, 32)
+	if _, err := crs.Read(seed); err != nil {
+		return nil, err
+	}
+	return seed, nil
+}
+
+func newKeyedPRNG(seed []byte) (sampling.PRNG, error) {
+	return sampling.NewKeyedPRNG(seed)
+}
+
+func newPublicKeySwitchProtocol(params ckks.Parameters) (multiparty.PublicKeySwitchProtocol, error) {
+	sigmaSmudging := 8 * rlwe.DefaultNoise
+	return multiparty.NewPublicKeySwitchProtocol(params, ring.DiscreteGaussian{Sigma: sigmaSmudging, Bound: 6 * sigmaSmudging})
+}
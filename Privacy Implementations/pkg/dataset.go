@@ -0,0 +1,251 @@
+package pkg
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Dataset is the local data of one party, the samples are stored per feature
+type Dataset struct {
+	Features []string
+
+	// Samples[j] holds the values of feature j, missing values are skipped
+	Samples [][]float64
+
+	// Missing[j] is the number of missing values skipped for feature j
+	Missing []int
+}
+
+// Cells read as a missing value
+var missingValues = map[string]bool{"": true, "?": true, "na": true, "nan": true, "null": true}
+
+// LoadCSV reads the dataset of a party from a CSV file with a header row, see ReadCSV
+func LoadCSV(path string, features []string) (*Dataset, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ReadCSV(f, features)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return data, nil
+}
+
+// ReadCSV reads the columns named by features from CSV data with a header row. If features is empty,
+// every named column whose values are all numeric is read (unnamed columns are row indices or come from a trailing separator).
+// Missing values ("", "?", "NA", "NaN", "null") and missing cells of short rows are skipped and counted,
+// any other non numeric value is an error.
+func ReadCSV(r io.Reader, features []string) (*Dataset, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(records) == 0 {
+		return nil, fmt.Errorf("no header row")
+	}
+
+	header := records[0]
+	if len(header) > 0 {
+		header[0] = strings.TrimPrefix(header[0], "\ufeff")
+	}
+	rows := records[1:]
+
+	var columns []int
+	if len(features) == 0 {
+		for j, name := range header {
+			if name == "" || !numericColumn(rows, j) {
+				continue
+			}
+			columns = append(columns, j)
+			features = append(features, name)
+		}
+		if len(columns) == 0 {
+			return nil, fmt.Errorf("no numeric column")
+		}
+	} else {
+		for _, name := range features {
+			j := indexOf(header, name)
+			if j < 0 {
+				return nil, fmt.Errorf("no column %q", name)
+			}
+			columns = append(columns, j)
+		}
+	}
+
+	data := &Dataset{
+		Features: features,
+		Samples:  make([][]float64, len(columns)),
+		Missing:  make([]int, len(columns)),
+	}
+
+	for i, row := range rows {
+		for j, column := range columns {
+			cell := cellOf(row, column)
+			if missingValues[strings.ToLower(cell)] {
+				data.Missing[j]++
+				continue
+			}
+
+			v, err := strconv.ParseFloat(cell, 64)
+			if err != nil || math.IsInf(v, 0) {
+				// Line numbers start at 1 and the header is line 1
+				return nil, fmt.Errorf("line %d, column %q: %q is not a number", i+2, features[j], cell)
+			}
+			data.Samples[j] = append(data.Samples[j], v)
+		}
+	}
+
+	return data, nil
+}
+
+func numericColumn(rows [][]string, j int) bool {
+	for _, row := range rows {
+		cell := cellOf(row, j)
+		if missingValues[strings.ToLower(cell)] {
+			continue
+		}
+		if _, err := strconv.ParseFloat(cell, 64); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func cellOf(row []string, j int) string {
+	if j >= len(row) {
+		return ""
+	}
+	return strings.TrimSpace(row[j])
+}
+
+func indexOf(header []string, name string) int {
+	for j, h := range header {
+		if h == name {
+			return j
+		}
+	}
+	return -1
+}
+
+// NSamples returns the number of samples of every feature
+func (d *Dataset) NSamples() []int {
+	n := make([]int, len(d.Samples))
+	for j := range d.Samples {
+		n[j] = len(d.Samples[j])
+	}
+	return n
+}
+
+// Split divides the samples of the dataset into N datasets of consecutive samples with the same features,
+// to simulate N parties holding one part each. N must be between 1 and the smallest number of samples of a feature
+// for every part to hold a sample of every feature, see LoadParties.
+func (d *Dataset) Split(N int) []*Dataset {
+	parts := make([]*Dataset, N)
+	for i := range parts {
+		parts[i] = &Dataset{
+			Features: d.Features,
+			Samples:  make([][]float64, len(d.Samples)),
+			Missing:  make([]int, len(d.Samples)),
+		}
+	}
+
+	for j, samples := range d.Samples {
+		for i := range parts {
+			start, end := i*len(samples)/N, (i+1)*len(samples)/N
+			parts[i].Samples[j] = samples[start:end]
+		}
+	}
+
+	return parts
+}
+
+// PrintMissing reports the missing values skipped while reading the dataset
+func (d *Dataset) PrintMissing() {
+	for j, n := range d.Missing {
+		if n > 0 {
+			fmt.Printf("Feature %s: %d missing values skipped\n", d.Features[j], n)
+		}
+	}
+}
+
+// ParseFeatures splits a comma separated list of feature names, an empty list selects every numeric column
+func ParseFeatures(list string) []string {
+	if strings.TrimSpace(list) == "" {
+		return nil
+	}
+	features := strings.Split(list, ",")
+	for i := range features {
+		features[i] = strings.TrimSpace(features[i])
+	}
+	return features
+}
+
+// ParseValues parses a comma separated list of numbers, e.g. one public value per feature
+func ParseValues(list string) ([]float64, error) {
+	fields := ParseFeatures(list)
+	values := make([]float64, len(fields))
+	for i, v := range fields {
+		var err error
+		if values[i], err = strconv.ParseFloat(v, 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", v)
+		}
+	}
+	return values, nil
+}
+
+// ParseParties parses a comma separated list of party IDs
+func ParseParties(list string) ([]int, error) {
+	fields := ParseFeatures(list)
+	ids := make([]int, len(fields))
+	for i, v := range fields {
+		var err error
+		if ids[i], err = strconv.Atoi(v); err != nil {
+			return nil, fmt.Errorf("%q is not a party ID", v)
+		}
+	}
+	return ids, nil
+}
+
+// LoadParty creates the i-th party from its CSV file, the missing values skipped are reported
+func LoadParty(params ckks.Parameters, i int, path string, features []string) (*Party, error) {
+	data, err := LoadCSV(path, features)
+	if err != nil {
+		return nil, err
+	}
+	data.PrintMissing()
+	return NewParty(params, i, data)
+}
+
+// LoadParties splits the pooled data of a CSV file between N simulated parties, the missing values skipped are reported
+func LoadParties(params ckks.Parameters, N int, path string, features []string) ([]*Party, error) {
+	data, err := LoadCSV(path, features)
+	if err != nil {
+		return nil, err
+	}
+	data.PrintMissing()
+
+	// Every party holds at least one sample of every feature
+	if N < 1 {
+		return nil, fmt.Errorf("cannot split the data of %s between %d parties", path, N)
+	}
+	for j, n := range data.NSamples() {
+		if N > n {
+			return nil, fmt.Errorf("cannot split the %d values of feature %q of %s between %d parties", n, data.Features[j], path, N)
+		}
+	}
+
+	return NewParties(params, data.Split(N))
+}
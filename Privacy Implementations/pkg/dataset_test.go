@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+func TestLoadPartiesSplit(t *testing.T) {
+	params, err := ckks.NewParametersFromLiteral(ParametersLiteralLogN(12))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Feature b has 2 values, the missing one is skipped
+	path := filepath.Join(t.TempDir(), "data.csv")
+	if err = os.WriteFile(path, []byte("a,b\n1,2\n3,\n5,6\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		N  int
+		ok bool
+	}{
+		{-1, false},
+		{0, false},
+		{1, true},
+		{2, true},
+		{3, false},
+	} {
+		parties, err := LoadParties(params, tc.N, path, nil)
+		if tc.ok && (err != nil || len(parties) != tc.N) {
+			t.Errorf("split between %d parties: %d parties, %v", tc.N, len(parties), err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("split between %d parties: no error", tc.N)
+		}
+	}
+}
@@ -1,126 +1,147 @@
 package pkg
 
 import (
+	"fmt"
 	"time"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/multiparty"
-	"github.com/tuneinsight/lattigo/v6/ring"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 )
 
-var elapsedPCKSCloud time.Duration
-var elapsedPCKSParty time.Duration
+// enable decryption for outside Party who has tsk
+func PcksPhase(params ckks.Parameters, tpk *rlwe.PublicKey, encRes *rlwe.Ciphertext, cohort Cohort) (encOut *rlwe.Ciphertext, err error) {
+	return ThresholdPcksPhase(params, tpk, encRes, cohort, nil)
+}
 
+// PcksPhase with the active parties of the threshold mode only, every party if active is nil
+func ThresholdPcksPhase(params ckks.Parameters, tpk *rlwe.PublicKey, encRes *rlwe.Ciphertext, cohort Cohort, active []int) (encOut *rlwe.Ciphertext, err error) {
+	encOut, _, _, err = thresholdPcksPhase(params, tpk, encRes, cohort, active)
+	return encOut, err
+}
 
-// enable decryption for outside Party who has tsk
-func PcksPhase(params ckks.Parameters, tpk *rlwe.PublicKey, encRes *rlwe.Ciphertext, P []*Party) (encOut *rlwe.Ciphertext) {
+// ThresholdPcksPhase, also returns the time spent by the parties and by the cloud
+func thresholdPcksPhase(params ckks.Parameters, tpk *rlwe.PublicKey, encRes *rlwe.Ciphertext, cohort Cohort, active []int) (encOut *rlwe.Ciphertext, party, cloud time.Duration, err error) {
+
+	if err = checkPublicKey(params, tpk); err != nil {
+		return nil, 0, 0, phaseError(PhasePCKS, err)
+	}
+	if err = checkCiphertext(params, encRes); err != nil {
+		return nil, 0, 0, phaseError(PhasePCKS, err)
+	}
 
 	// Collective key switching from the collective secret key to
 	// the target public key
-	sigmaSmudging := 8 * rlwe.DefaultNoise
-	pcks, err := multiparty.NewPublicKeySwitchProtocol(params, ring.DiscreteGaussian{Sigma: sigmaSmudging, Bound: 6 * sigmaSmudging})
+	pcks, err := newPublicKeySwitchProtocol(params)
 	if err != nil {
-		panic(err)
+		return nil, 0, 0, phaseError(PhasePCKS, err)
 	}
 
-	for _, pi := range P {
-		pi.pcksShare = pcks.AllocateShare(params.MaxLevel())
+	var shares []multiparty.PublicKeySwitchShare
+	// Number of parties taking part in the key switch
+	n := cohort.Len()
+	if active != nil {
+		n = len(active)
+	}
+
+	party = RunTimedParty(func() {
+		shares, err = cohort.PublicKeySwitchRound(active, tpk, encRes)
+	}, n)
+	if err != nil {
+		return nil, 0, 0, phaseError(PhasePCKS, err)
 	}
 
+	pcksCombined := pcks.AllocateShare(encRes.Level())
+	if err = checkShares(n, shares, pcksCombined); err != nil {
+		return nil, 0, 0, phaseError(PhasePCKS, activePartyError(active, err))
+	}
 
-	elapsedPCKSParty = RunTimedParty(func() {
-		for _, pi := range P {
-			/* #nosec G601 -- Implicit memory aliasing in for loop acknowledged */
-			pcks.GenShare(pi.Sk, tpk, encRes, &pi.pcksShare)
-		}
-	}, len(P))
-
-	pcksCombined := pcks.AllocateShare(params.MaxLevel())
-	encOut = ckks.NewCiphertext(params, 1, params.MaxLevel())
-	elapsedPCKSCloud = RunTimed(func() {
-		for _, pi := range P {
-			if err = pcks.AggregateShares(pi.pcksShare, pcksCombined, &pcksCombined); err != nil {
-				panic(err)
+	encOut = ckks.NewCiphertext(params, 1, encRes.Level())
+	cloud = RunTimed(func() {
+		for i, share := range shares {
+			if err = pcks.AggregateShares(share, pcksCombined, &pcksCombined); err != nil {
+				err = activePartyError(active, &PartyError{Party: i, Err: err})
+				return
 			}
 		}
 
 		pcks.KeySwitch(encRes, pcksCombined, encOut)
 	})
+	if err != nil {
+		return nil, 0, 0, phaseError(PhasePCKS, err)
+	}
 
-	return
+	return encOut, party, cloud, nil
 }
 
 // Decrypts and prints the result
-func CollectiveDecryption(params ckks.Parameters, tsk *rlwe.SecretKey, ciphertext *rlwe.Ciphertext, tpk *rlwe.PublicKey, parties []*Party) (result []float64) {
-	// Decryptor
-	dec := rlwe.NewDecryptor(params, tsk)
+func CollectiveDecryption(params ckks.Parameters, tsk *rlwe.SecretKey, ciphertext *rlwe.Ciphertext, tpk *rlwe.PublicKey, cohort Cohort) (result []float64, err error) {
+	encOut, err := PcksPhase(params, tpk, ciphertext, cohort)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeValues(params, tsk, encOut)
+}
 
-	encOut := PcksPhase(params, tpk, ciphertext, parties)
+// Decrypts the ciphertext with sk and decodes its slots
+func decodeValues(params ckks.Parameters, sk *rlwe.SecretKey, ct *rlwe.Ciphertext) ([]float64, error) {
+	if sk == nil || sk.Value.Q.N() != params.N() {
+		return nil, phaseError(PhaseDecrypt, fmt.Errorf("%w: secret key does not belong to the ring of the parameters", ErrMismatchedParameters))
+	}
+
+	// Decryptor
+	dec := rlwe.NewDecryptor(params, sk)
 
 	// Encoder
 	ecd := ckks.NewEncoder(params)
 
 	// Decrypt
-	plaintext := dec.DecryptNew(encOut)
+	plaintext := dec.DecryptNew(ct)
 
 	// Decode
 	values := make([]float64, params.MaxSlots())
 	if err := ecd.Decode(plaintext, values); err != nil {
-		panic(err)
+		return nil, phaseError(PhaseDecrypt, err)
 	}
 
-	return values
+	return values, nil
 }
 
 // Decrypts and prints the result
-func TestCollectiveDecryption(params ckks.Parameters, ciphertext *rlwe.Ciphertext, parties []*Party) {
+func TestCollectiveDecryption(params ckks.Parameters, ciphertext *rlwe.Ciphertext, cohort Cohort) error {
 
 	tsk, tpk := rlwe.NewKeyGenerator(params).GenKeyPairNew()
 
-	values := CollectiveDecryption(params, tsk, ciphertext, tpk, parties)
+	values, err := CollectiveDecryption(params, tsk, ciphertext, tpk, cohort)
+	if err != nil {
+		return err
+	}
 
 	PrintValues(values)
-
+	return nil
 }
 
-func IdealSecretKeyDecryption(params ckks.Parameters, ciphertext *rlwe.Ciphertext, P []*Party) (result []float64){
+func IdealSecretKeyDecryption(params ckks.Parameters, ciphertext *rlwe.Ciphertext, P []*Party) (result []float64, err error){
 	// Ideal Key Generation
 	skIdealOut := rlwe.NewSecretKey(params)
 	for _, pi := range P {
 		params.RingQ().Add(skIdealOut.Value.Q, pi.Sk.Value.Q, skIdealOut.Value.Q)
 	}
-	decryptor := rlwe.NewDecryptor(params, skIdealOut)
-	encoder := ckks.NewEncoder(params)
-
 
-	plaintext := decryptor.DecryptNew(ciphertext)
-	// Decode
-	values := make([]float64, params.MaxSlots())
-	if err := encoder.Decode(plaintext, values); err != nil {
-		panic(err)
+	if err = checkCiphertext(params, ciphertext); err != nil {
+		return nil, phaseError(PhaseDecrypt, err)
 	}
 
-	// PrintValues(values)
-	return values
+	return decodeValues(params, skIdealOut, ciphertext)
 }
 
-func TestIdealSecretKeyDecryption(params ckks.Parameters, ciphertext *rlwe.Ciphertext, P []*Party) {
-	// Ideal Key Generation
-	skIdealOut := rlwe.NewSecretKey(params)
-	for _, pi := range P {
-		params.RingQ().Add(skIdealOut.Value.Q, pi.Sk.Value.Q, skIdealOut.Value.Q)
-	}
-	decryptor := rlwe.NewDecryptor(params, skIdealOut)
-	encoder := ckks.NewEncoder(params)
-
-
-	plaintext := decryptor.DecryptNew(ciphertext)
-	// Decode
-	values := make([]float64, params.MaxSlots())
-	if err := encoder.Decode(plaintext, values); err != nil {
-		panic(err)
+func TestIdealSecretKeyDecryption(params ckks.Parameters, ciphertext *rlwe.Ciphertext, P []*Party) error {
+	values, err := IdealSecretKeyDecryption(params, ciphertext, P)
+	if err != nil {
+		return err
 	}
 
 	PrintValues(values)
-}
\ No newline at end of file
+	return nil
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Release records the parties behind one revealed ciphertext: the parties whose inputs it aggregates and the parties
+// that decrypted it. Run numbers the runs of Session.Consistent, a statistic released by an earlier run was discarded.
+type Release struct {
+	Run          int
+	Contributors []int
+	Decryptors   []int
+}
+
+// Contributors returns the parties that did not drop, in party order. The input rounds only query these parties.
+func (s *Session) Contributors() []int {
+	var ids []int
+	for i := 0; i < s.Cohort.Len(); i++ {
+		if !s.dropped[i] {
+			ids = append(ids, i)
+		}
+	}
+	return ids
+}
+
+// Consistent runs f, and runs it again over the remaining parties whenever a party dropped while it ran, so that every
+// statistic f releases aggregates the inputs of the same parties. It returns these parties. The inputs of a dropped
+// party are thus never mixed with statistics that exclude it, e.g. its sums with the counts of the other parties.
+func (s *Session) Consistent(f func() error) ([]int, error) {
+	for {
+		contributors := s.Contributors()
+		if len(contributors) == 0 {
+			return nil, fmt.Errorf("%w: every party dropped", ErrMissingShare)
+		}
+
+		s.run++
+		s.contributed = make(map[int]bool)
+		if err := f(); err != nil {
+			return nil, err
+		}
+
+		if len(s.Contributors()) == len(contributors) {
+			return contributors, nil
+		}
+	}
+}
+
+// PartiesOf returns the parties of the IDs, e.g. to validate the results against the data of the contributors
+func PartiesOf(parties []*Party, ids []int) []*Party {
+	subset := make([]*Party, len(ids))
+	for k, i := range ids {
+		subset[k] = parties[i]
+	}
+	return subset
+}
+
+// Excludes the dropped parties from the next rounds and replaces the dropped active parties of the threshold mode with
+// other parties, it returns the new active parties
+func (s *Session) drop(ids []int) ([]int, error) {
+	for _, i := range ids {
+		s.dropped[i] = true
+	}
+	if s.Active == nil {
+		return nil, nil
+	}
+
+	var active []int
+	isActive := make(map[int]bool)
+	for _, i := range s.Active {
+		if !s.dropped[i] {
+			active = append(active, i)
+			isActive[i] = true
+		}
+	}
+	for _, i := range s.Contributors() {
+		if len(active) < s.Threshold && !isActive[i] {
+			active = append(active, i)
+		}
+	}
+	if len(active) < s.Threshold {
+		return nil, fmt.Errorf("%w: %d parties left for a threshold of %d", ErrMissingShare, len(active), s.Threshold)
+	}
+
+	return active, s.SetActive(active)
+}
+
+// Records the parties behind a revealed ciphertext
+func (s *Session) release() {
+	r := Release{Run: s.run, Decryptors: s.Active}
+	for i := range s.contributed {
+		r.Contributors = append(r.Contributors, i)
+	}
+	sort.Ints(r.Contributors)
+	if r.Decryptors == nil {
+		for i := 0; i < s.Cohort.Len(); i++ {
+			r.Decryptors = append(r.Decryptors, i)
+		}
+	}
+	s.Releases = append(s.Releases, r)
+}
+
+// IDs of the parties that err reports as dropped, the errors of a round are joined with errors.Join
+func droppedParties(err error) []int {
+	var ids []int
+	var walk func(err error)
+	walk = func(err error) {
+		switch e := err.(type) {
+		case nil:
+		case *PartyError:
+			if errors.Is(e.Err, ErrDropped) {
+				ids = append(ids, e.Party)
+			}
+		case interface{ Unwrap() []error }:
+			for _, err := range e.Unwrap() {
+				walk(err)
+			}
+		case interface{ Unwrap() error }:
+			walk(e.Unwrap())
+		}
+	}
+	walk(err)
+	return ids
+}
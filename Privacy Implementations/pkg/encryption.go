@@ -1,157 +1,123 @@
 package pkg
 
 import (
-	"time"
+	"fmt"
+	"math"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 )
 
-var elapsedEncryptParty time.Duration
-var elapsedEncryptCloud time.Duration
-
-
 // Encrypts each Party's Input values for z score computation
-func EncryptZscoreValues(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party) ([]*rlwe.Ciphertext, []*rlwe.Ciphertext) {
-	encryptor := ckks.NewEncryptor(params, pk)
-	encoder := ckks.NewEncoder(params)
-
+func EncryptZscoreValues(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party) ([]*rlwe.Ciphertext, []*rlwe.Ciphertext, error) {
 	inputCiphertexts := make([]*rlwe.Ciphertext, len(parties))
 	for i, pi := range parties {
-		plaintext := ckks.NewPlaintext(params, params.MaxLevel())
-
 		var err error
-		if err = encoder.Encode(pi.Input, plaintext); err != nil {
-			panic(err)
-		}
-		if inputCiphertexts[i], err = encryptor.EncryptNew(plaintext); err != nil {
-			panic(err)
+		if inputCiphertexts[i], err = encryptValues(params, pk, pi.Input); err != nil {
+			return nil, nil, phaseError(PhaseEncrypt, &PartyError{Party: i, Err: err})
 		}
 	}
 
 	numberOfSamplesCiphertexts := make([]*rlwe.Ciphertext, len(parties))
 	for i, pi := range parties {
-		plaintext := ckks.NewPlaintext(params, params.MaxLevel())
-
 		var err error
-		if err = encoder.Encode(pi.NumberOfSamples, plaintext); err != nil {
-			panic(err)
-		}
-		if numberOfSamplesCiphertexts[i], err = encryptor.EncryptNew(plaintext); err != nil {
-			panic(err)
+		if numberOfSamplesCiphertexts[i], err = encryptValues(params, pk, pi.NumberOfSamples); err != nil {
+			return nil, nil, phaseError(PhaseEncrypt, &PartyError{Party: i, Err: err})
 		}
 	}
 
-	return inputCiphertexts, numberOfSamplesCiphertexts
+	return inputCiphertexts, numberOfSamplesCiphertexts, nil
 }
 
 // Encrypts each Party's Input values for minmax computation
-func EncryptMinMaxValues(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party) ([]*rlwe.Ciphertext, []*rlwe.Ciphertext) {
-	encryptor := ckks.NewEncryptor(params, pk)
-	encoder := ckks.NewEncoder(params)
-
+func EncryptMinMaxValues(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party) ([]*rlwe.Ciphertext, []*rlwe.Ciphertext, error) {
 	maxCiphertexts := make([]*rlwe.Ciphertext, len(parties))
 	for i, pi := range parties {
-		
-		plaintext := ckks.NewPlaintext(params, params.MaxLevel())
-
 		var err error
-		if err = encoder.Encode(pi.MaxValues, plaintext); err != nil {
-			panic(err)
-		}
-		if maxCiphertexts[i], err = encryptor.EncryptNew(plaintext); err != nil {
-			panic(err)
+		if maxCiphertexts[i], err = encryptValues(params, pk, pi.MaxValues); err != nil {
+			return nil, nil, phaseError(PhaseEncrypt, &PartyError{Party: i, Err: err})
 		}
 
 	}
 
 	minCiphertexts := make([]*rlwe.Ciphertext, len(parties))
 	for i, pi := range parties {
-		plaintext := ckks.NewPlaintext(params, params.MaxLevel())
-
 		var err error
-		if err = encoder.Encode(pi.MinValues, plaintext); err != nil {
-			panic(err)
-		}
-		if minCiphertexts[i], err = encryptor.EncryptNew(plaintext); err != nil {
-			panic(err)
+		if minCiphertexts[i], err = encryptValues(params, pk, pi.MinValues); err != nil {
+			return nil, nil, phaseError(PhaseEncrypt, &PartyError{Party: i, Err: err})
 		}
 	}
 
-	return minCiphertexts, maxCiphertexts
+	return minCiphertexts, maxCiphertexts, nil
 }
 
 // Encrypts each Party's Number Of Samples values for Robust Scaling computation
-func EncryptRobustSampleValues(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party) []*rlwe.Ciphertext {
-	encryptor := ckks.NewEncryptor(params, pk)
-	encoder := ckks.NewEncoder(params)
-
+func EncryptRobustSampleValues(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party) ([]*rlwe.Ciphertext, error) {
 	numberOfSamplesCiphertexts := make([]*rlwe.Ciphertext, len(parties))
 	for i, pi := range parties {
-		plaintext := ckks.NewPlaintext(params, params.MaxLevel())
-
 		var err error
-		if err = encoder.Encode(pi.RobustScalingNSamples, plaintext); err != nil {
-			panic(err)
-		}
-		if numberOfSamplesCiphertexts[i], err = encryptor.EncryptNew(plaintext); err != nil {
-			panic(err)
+		if numberOfSamplesCiphertexts[i], err = encryptValues(params, pk, pi.RobustScalingNSamples); err != nil {
+			return nil, phaseError(PhaseEncrypt, &PartyError{Party: i, Err: err})
 		}
 	}
 
-	return numberOfSamplesCiphertexts
+	return numberOfSamplesCiphertexts, nil
 }
 
 // Encrypts each Party's number of Left and Right values for Robust Scaling computation
-func EncryptRobustLRValues(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party) ([]*rlwe.Ciphertext, []*rlwe.Ciphertext) {
-	encryptor := ckks.NewEncryptor(params, pk)
-	encoder := ckks.NewEncoder(params)
-
+func EncryptRobustLRValues(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party) ([]*rlwe.Ciphertext, []*rlwe.Ciphertext, error) {
 	lCounts := make([]*rlwe.Ciphertext, len(parties))
 	for i, pi := range parties {
-		plaintext := ckks.NewPlaintext(params, params.MaxLevel())
-
 		var err error
-		if err = encoder.Encode(pi.RobustScalingLCount, plaintext); err != nil {
-			panic(err)
-		}
-		if lCounts[i], err = encryptor.EncryptNew(plaintext); err != nil {
-			panic(err)
+		if lCounts[i], err = encryptValues(params, pk, pi.RobustScalingLCount); err != nil {
+			return nil, nil, phaseError(PhaseEncrypt, &PartyError{Party: i, Err: err})
 		}
 	}
 
 	rCounts := make([]*rlwe.Ciphertext, len(parties))
 	for i, pi := range parties {
-		plaintext := ckks.NewPlaintext(params, params.MaxLevel())
-
 		var err error
-		if err = encoder.Encode(pi.RobustScalingRCount, plaintext); err != nil {
-			panic(err)
-		}
-		if rCounts[i], err = encryptor.EncryptNew(plaintext); err != nil {
-			panic(err)
+		if rCounts[i], err = encryptValues(params, pk, pi.RobustScalingRCount); err != nil {
+			return nil, nil, phaseError(PhaseEncrypt, &PartyError{Party: i, Err: err})
 		}
 	}
 
-	return lCounts, rCounts
+	return lCounts, rCounts, nil
 }
 
 
 // Encrypts one array of float64 values
-func EncryptOneValue(params ckks.Parameters, pk *rlwe.PublicKey, value []float64) *rlwe.Ciphertext {
-	encryptor := ckks.NewEncryptor(params, pk)
-	encoder := ckks.NewEncoder(params)
+func EncryptOneValue(params ckks.Parameters, pk *rlwe.PublicKey, value []float64) (*rlwe.Ciphertext, error) {
+	return encryptValues(params, pk, value)
+}
 
-	plaintext := ckks.NewPlaintext(params, params.MaxLevel())
+// Encodes the values at the default scale and encrypts them under pk
+func encryptValues(params ckks.Parameters, pk *rlwe.PublicKey, values []float64) (*rlwe.Ciphertext, error) {
+	if err := checkPublicKey(params, pk); err != nil {
+		return nil, err
+	}
+	if err := checkEncoding(params, values); err != nil {
+		return nil, err
+	}
 
-	var err error
-	if err = encoder.Encode(value, plaintext); err != nil {
-		panic(err)
+	plaintext := ckks.NewPlaintext(params, params.MaxLevel())
+	if err := ckks.NewEncoder(params).Encode(values, plaintext); err != nil {
+		return nil, err
 	}
-	ciphertext, err := encryptor.EncryptNew(plaintext)
-	if err != nil {
-		panic(err)
+	return ckks.NewEncryptor(params, pk).EncryptNew(plaintext)
+}
+
+// Checks that the values fit in the slots and that, scaled by the default scale, they stay below half the ciphertext modulus
+func checkEncoding(params ckks.Parameters, values []float64) error {
+	if len(values) > params.MaxSlots() {
+		return fmt.Errorf("%w: %d values for %d slots", ErrEncodingOverflow, len(values), params.MaxSlots())
 	}
 
-	return ciphertext
-}
\ No newline at end of file
+	bound := math.Exp2(float64(params.LogQLvl(params.MaxLevel()) - params.LogDefaultScale() - 1))
+	for i, v := range values {
+		if math.IsNaN(v) || math.Abs(v) >= bound {
+			return fmt.Errorf("%w: value %g in slot %d", ErrEncodingOverflow, v, i)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Errors of the protocol phases, callers can test for them with errors.Is
+var (
+	// The ciphertext is below the level the operation needs, e.g. the minimum level of the refresh protocol
+	ErrInsufficientLevel = errors.New("insufficient level")
+
+	// The values do not fit in the slots of a plaintext, or are too large to be encoded at the default scale
+	ErrEncodingOverflow = errors.New("encoding overflow")
+
+	// A key, share or ciphertext was not produced with the parameters of the session
+	ErrMismatchedParameters = errors.New("mismatched parameters")
+
+	// A party did not send its share (or ciphertext) in a round
+	ErrMissingShare = errors.New("missing share")
+
+	// The query is unknown to the party or its arguments do not match the party's data
+	ErrInvalidQuery = errors.New("invalid query")
+
+	// The party did not answer a round in time or its connection failed, it is excluded from the next rounds
+	ErrDropped = errors.New("party dropped")
+
+	// A revealed result shows that the input of an approximation was outside its domain, e.g. a variance outside that of InverseStd
+	ErrOutOfDomain = errors.New("out of domain")
+)
+
+var sentinelErrors = []error{ErrInsufficientLevel, ErrEncodingOverflow, ErrMismatchedParameters, ErrMissingShare, ErrInvalidQuery, ErrDropped, ErrOutOfDomain}
+
+// Error reported by a party process, only its message travels over the wire.
+// It matches the errors of this package that the message names, so errors.Is works across processes.
+type remoteError struct {
+	msg string
+}
+
+func (e *remoteError) Error() string {
+	return e.msg
+}
+
+func (e *remoteError) Is(target error) bool {
+	for _, sentinel := range sentinelErrors {
+		if target == sentinel && strings.Contains(e.msg, sentinel.Error()) {
+			return true
+		}
+	}
+	return false
+}
+
+// Names of the protocol phases reported in a PhaseError
+const (
+	PhaseCKG       = "CKG"
+	PhaseRKG       = "RKG"
+	PhaseGKG       = "GKG"
+	PhaseInput     = "Input"
+	PhaseEncrypt   = "Encrypt"
+	PhasePCKS      = "PCKS"
+	PhaseDecrypt   = "Decrypt"
+	PhaseRefresh   = "Refresh"
+	PhaseThreshold = "Threshold"
+)
+
+// PhaseError is returned by the protocol phases, it records the phase that failed.
+// The cause is available with errors.Is and errors.As.
+type PhaseError struct {
+	Phase string
+	Err   error
+}
+
+func (e *PhaseError) Error() string {
+	return fmt.Sprintf("%s phase: %v", e.Phase, e.Err)
+}
+
+func (e *PhaseError) Unwrap() error {
+	return e.Err
+}
+
+// PartyError records the party a failure comes from
+type PartyError struct {
+	Party int
+	Err   error
+}
+
+func (e *PartyError) Error() string {
+	return fmt.Sprintf("party %d: %v", e.Party, e.Err)
+}
+
+func (e *PartyError) Unwrap() error {
+	return e.Err
+}
+
+// Wraps err into a PhaseError, unless it is nil or already reports the phase
+func phaseError(phase string, err error) error {
+	var pe *PhaseError
+	if err == nil || (errors.As(err, &pe) && pe.Phase == phase) {
+		return err
+	}
+	return &PhaseError{Phase: phase, Err: err}
+}
+
+// Wraps err into a PartyError, unless it is nil or already reports a party
+func partyError(party int, err error) error {
+	var pe *PartyError
+	if err == nil || errors.As(err, &pe) {
+		return err
+	}
+	return &PartyError{Party: party, Err: err}
+}
+
+// Maps the PartyError of the k-th share of a round of the active parties to the ID of the party, active[k]
+func activePartyError(active []int, err error) error {
+	var pe *PartyError
+	if active == nil || !errors.As(err, &pe) || pe.Party < 0 || pe.Party >= len(active) {
+		return err
+	}
+	return &PartyError{Party: active[pe.Party], Err: pe.Err}
+}
+
+// Checks that every one of the n parties sent a share of the same shape as reference, a share allocated by the aggregator
+func checkShares[T interface{ BinarySize() int }](n int, shares []T, reference T) error {
+	if len(shares) != n {
+		return fmt.Errorf("%w: %d shares for %d parties", ErrMissingShare, len(shares), n)
+	}
+	for i, share := range shares {
+		if share.BinarySize() != reference.BinarySize() {
+			return &PartyError{Party: i, Err: fmt.Errorf("%w: share of %d bytes, expected %d", ErrMismatchedParameters, share.BinarySize(), reference.BinarySize())}
+		}
+	}
+	return nil
+}
+
+// Checks that the ciphertext is a degree 1 ciphertext of the ring of params
+func checkCiphertext(params ckks.Parameters, ct *rlwe.Ciphertext) error {
+	switch {
+	case ct == nil:
+		return ErrMissingShare
+	case ct.Degree() != 1 || ct.N() != params.N() || ct.Level() > params.MaxLevel():
+		return fmt.Errorf("%w: ciphertext of degree %d, ring degree %d and level %d", ErrMismatchedParameters, ct.Degree(), ct.N(), ct.Level())
+	}
+	return nil
+}
+
+// Checks that the public key belongs to the ring of params
+func checkPublicKey(params ckks.Parameters, pk *rlwe.PublicKey) error {
+	switch {
+	case pk == nil:
+		return fmt.Errorf("%w: no public key", ErrMismatchedParameters)
+	case len(pk.Value) != 2 || pk.Value[0].Q.N() != params.N() || pk.LevelQ() != params.MaxLevelQ() || pk.LevelP() != params.MaxLevelP():
+		return fmt.Errorf("%w: public key does not belong to the ring of the parameters", ErrMismatchedParameters)
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"flag"
+	"fmt"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// CommandFlags are the flags shared by the normalization commands: the role of the process and its cohort, the
+// threshold mode, the simulated dropouts, the ring degree and the metrics file
+type CommandFlags struct {
+	Role      string
+	Addr      string
+	Parties   int
+	ID        int
+	Data      string
+	Features  string
+	NFeatures int
+
+	Threshold int
+	Active    string
+
+	Timeout      time.Duration
+	Dropout      int
+	DropoutAfter int
+
+	LogN    int
+	Metrics string
+}
+
+// NewCommandFlags defines the shared flags on the flag set, they are set when the flag set is parsed
+func NewCommandFlags(fs *flag.FlagSet) *CommandFlags {
+	f := &CommandFlags{}
+	fs.StringVar(&f.Role, "role", "sim", "sim (every party in this process), aggregator or party")
+	fs.StringVar(&f.Addr, "addr", "127.0.0.1:7000", "address the aggregator listens on")
+	fs.IntVar(&f.Parties, "parties", 4, "number of parties")
+	fs.IntVar(&f.ID, "id", 0, "ID of this party (0 to parties-1), for -role party")
+	fs.StringVar(&f.Data, "data", "", "CSV file of the party's data (-role party) or of the pooled data split between the parties (-role sim), generated data if empty")
+	fs.StringVar(&f.Features, "features", "", "comma separated feature columns of the CSV file, every numeric column if empty")
+	fs.IntVar(&f.NFeatures, "nfeatures", 4, "number of features of the parties' data when -features is empty, for -role aggregator, and of the generated data of robust")
+	fs.IntVar(&f.Threshold, "threshold", 0, "number t of parties that suffice to decrypt and refresh (t-out-of-N threshold mode), every party is needed if 0")
+	fs.StringVar(&f.Active, "active", "", "comma separated IDs of the t parties that decrypt and refresh in the threshold mode, the first t parties if empty")
+	fs.DurationVar(&f.Timeout, "timeout", 2*time.Minute, "time a party has to answer a round before it is dropped, for -role aggregator (no limit if 0)")
+	fs.IntVar(&f.Dropout, "dropout", -1, "ID of a party that drops during the protocol, for -role sim (no party drops if -1)")
+	fs.IntVar(&f.DropoutAfter, "dropout-after", 1, "number of input rounds the party of -dropout answers before it drops")
+	fs.IntVar(&f.LogN, "logn", DefaultParametersLiteral.LogN, "log2 of the ring degree, the aggregator and the parties must agree on it (below 15 the parameters are under 128 bit security, for benchmarks only)")
+	fs.StringVar(&f.Metrics, "metrics", "", "JSON file the phase times, refresh and key switch counts of the session are written to, not written if empty")
+	return f
+}
+
+// Params returns the CKKS parameters of the ring degree of -logn, once the role and the number of features are checked
+func (f *CommandFlags) Params() (ckks.Parameters, error) {
+	if f.Role != "sim" && f.Role != "aggregator" && f.Role != "party" {
+		return ckks.Parameters{}, fmt.Errorf("unknown role %q", f.Role)
+	}
+	if f.NFeatures < 1 {
+		return ckks.Parameters{}, fmt.Errorf("%d features", f.NFeatures)
+	}
+	return ckks.NewParametersFromLiteral(ParametersLiteralLogN(f.LogN))
+}
+
+// FeatureNames returns the feature columns of -features, nil for every numeric column
+func (f *CommandFlags) FeatureNames() []string {
+	return ParseFeatures(f.Features)
+}
+
+// NumFeatures returns the number of features of the session: those of the data of the parties of the simulation, else
+// those of -features, else -nfeatures
+func (f *CommandFlags) NumFeatures(parties []*Party) int {
+	if len(parties) > 0 && parties[0].Data != nil {
+		return len(parties[0].Data.Features)
+	}
+	if features := f.FeatureNames(); len(features) > 0 {
+		return len(features)
+	}
+	return f.NFeatures
+}
+
+// ServeParty runs the party process of -role party: the party of -id, read from -data or generated by gen, answers
+// the rounds of the aggregator until the end of the session
+func (f *CommandFlags) ServeParty(params ckks.Parameters, gen func(params ckks.Parameters, i int) *Party) error {
+	party := gen(params, f.ID)
+	if f.Data != "" {
+		var err error
+		if party, err = LoadParty(params, f.ID, f.Data, f.FeatureNames()); err != nil {
+			return err
+		}
+	}
+	return ServeParty(params, f.Addr, party)
+}
+
+// SimParties returns the -parties parties of the simulation, the pooled data of -data split between them or the
+// parties generated by gen
+func (f *CommandFlags) SimParties(params ckks.Parameters, gen func(params ckks.Parameters, N int) []*Party) ([]*Party, error) {
+	if f.Data != "" {
+		return LoadParties(params, f.Parties, f.Data, f.FeatureNames())
+	}
+	return gen(params, f.Parties), nil
+}
+
+// Cohort returns the cohort of the role, an aggregator waiting for the -parties parties with the -timeout of a round,
+// or the local cohort of the parties of the simulation in which the party of -dropout drops. The returned function
+// ends the session of the aggregator.
+func (f *CommandFlags) Cohort(params ckks.Parameters, parties []*Party) (Cohort, func(), error) {
+	if f.Role == "aggregator" {
+		aggregator, err := NewAggregator(params, f.Addr, f.Parties)
+		if err != nil {
+			return nil, nil, err
+		}
+		aggregator.Timeout = f.Timeout
+		return aggregator, func() { aggregator.Close() }, nil
+	}
+
+	local := NewLocalCohort(params, parties)
+	if f.Dropout >= 0 {
+		local.DropAfter = map[int]int{f.Dropout: f.DropoutAfter}
+	}
+	return local, func() {}, nil
+}
+
+// Thresholdize shares the secret keys of the session for the -threshold mode with the -active parties, nothing is
+// done if -threshold is 0
+func (f *CommandFlags) Thresholdize(session *Session) error {
+	if f.Threshold <= 0 {
+		return nil
+	}
+
+	active, err := ParseParties(f.Active)
+	if err != nil {
+		return err
+	}
+	if err = session.Thresholdize(f.Threshold); err != nil {
+		return err
+	}
+	if len(active) > 0 {
+		if err = session.SetActive(active); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("Threshold %d-out-of-%d, active parties: %v\n", session.Threshold, session.Cohort.Len(), session.Active)
+	return nil
+}
+
+// WriteMetrics writes the metrics to the file of -metrics, if any
+func (f *CommandFlags) WriteMetrics(m *Metrics) error {
+	if f.Metrics == "" {
+		return nil
+	}
+	return m.WriteFile(f.Metrics)
+}
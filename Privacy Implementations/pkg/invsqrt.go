@@ -0,0 +1,161 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/polynomial"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+	"github.com/tuneinsight/lattigo/v6/utils/bignum"
+)
+
+// Degree of the Chebyshev interpolant that starts the Newton iteration of InverseSqrtNew, it consumes 8 levels
+const inverseSqrtDegree = 127
+
+// Bound of the relative error of the start of InverseSqrtNew to the function it interpolates
+const inverseSqrtStartError = 0.125
+
+// InverseSqrtNew homomorphically computes 1/sqrt(x + 2^log2min) for x in the interval [0, 2^log2max].
+// The floor 2^log2min keeps features of zero variance in the domain, their result is close to 2^(-log2min/2).
+//
+// The Chebyshev interpolant of degree inverseSqrtDegree of 1/sqrt(v + a) over v in [0, 2^(log2max+1)] gives the start
+// of the Newton iteration y = y * (3 - v * y^2) / 2, which is then run until the relative error is below the precision
+// of the scale. The offset a = 2^(log2max+1) / inverseSqrtDegree^2 keeps the interpolant within inverseSqrtStartError
+// of 1/sqrt(v + a), so the relative error y * sqrt(v) of the start is below 1 + inverseSqrtStartError everywhere and is
+// smallest, about inverseSqrtDegree * sqrt(2^(log2min-log2max-1)), for v = 2^log2min. No polynomial of a practical
+// degree reaches the precision of the scale by itself over the 2^(log2max-log2min) ratio of the domain. Each Newton
+// iteration consumes 3 levels, the ciphertexts are bootstrapped with btp when they run out of levels.
+//
+// The result is only meaningful in the domain, and no error can be raised under encryption. The iteration diverges
+// for x + 2^log2min <= 0, e.g. for an approximation error of a variance larger than -2^log2min in magnitude, and the
+// interpolant grows quickly past x + 2^log2min = 2^(log2max+1). Out of the domain the result is garbage: negative,
+// far above 2^(-log2min/2) or not finite once decrypted. StdFromInverse reports it as ErrOutOfDomain when the result
+// is revealed.
+func InverseSqrtNew(params ckks.Parameters, eval *ckks.Evaluator, btp bootstrapping.Bootstrapper, ct *rlwe.Ciphertext, log2min, log2max float64) (*rlwe.Ciphertext, error) {
+
+	if log2min >= log2max {
+		return nil, fmt.Errorf("cannot InverseSqrtNew: log2min=%f >= log2max=%f", log2min, log2max)
+	}
+
+	levelsPerRescaling := params.LevelsConsumedPerRescaling()
+	minLevel := 3*levelsPerRescaling + btp.MinimumInputLevel()
+
+	// x + 2^log2min
+	v, err := eval.AddNew(ct, math.Exp2(log2min))
+	if err != nil {
+		return nil, err
+	}
+
+	start := inverseSqrtStart(log2max)
+	if v.Level() < (start.Depth()+1)*levelsPerRescaling+btp.MinimumInputLevel() {
+		if v, err = btp.Bootstrap(v); err != nil {
+			return nil, err
+		}
+	}
+
+	// -(x + 2^log2min) / 2, the factor -1/2 of the iteration is folded in
+	xHalf, err := eval.MulNew(v, -0.5)
+	if err != nil {
+		return nil, err
+	}
+	if err = eval.Rescale(xHalf, xHalf); err != nil {
+		return nil, err
+	}
+	if xHalf.Level() < minLevel {
+		if xHalf, err = btp.Bootstrap(xHalf); err != nil {
+			return nil, err
+		}
+	}
+
+	// Change of basis of the interval of the interpolant to [-1, 1], and the interpolant
+	scalar, constant := start.ChangeOfBasis()
+	if err = eval.Mul(v, scalar, v); err != nil {
+		return nil, err
+	}
+	if err = eval.Add(v, constant, v); err != nil {
+		return nil, err
+	}
+	if err = eval.Rescale(v, v); err != nil {
+		return nil, err
+	}
+	y, err := polynomial.NewEvaluator(params, eval).Evaluate(v, polynomial.NewPolynomial(start), params.DefaultScale())
+	if err != nil {
+		return nil, err
+	}
+
+	a := math.Exp2(log2max+1) / (inverseSqrtDegree * inverseSqrtDegree)
+	vMin := math.Exp2(log2min)
+	lo := (1 - inverseSqrtStartError) * math.Sqrt(vMin/(vMin+a))
+
+	for i := 0; i < inverseSqrtIterations(params, ct.Scale, lo, 1+inverseSqrtStartError); i++ {
+
+		if y.Level() < minLevel {
+			if y, err = btp.Bootstrap(y); err != nil {
+				return nil, err
+			}
+		}
+
+		// y^2
+		var t *rlwe.Ciphertext
+		if t, err = eval.MulRelinNew(y, y); err != nil {
+			return nil, err
+		}
+		if err = eval.Rescale(t, t); err != nil {
+			return nil, err
+		}
+
+		// 3/2 - x * y^2 / 2
+		if err = eval.MulRelin(t, xHalf, t); err != nil {
+			return nil, err
+		}
+		if err = eval.Rescale(t, t); err != nil {
+			return nil, err
+		}
+		if err = eval.Add(t, 1.5, t); err != nil {
+			return nil, err
+		}
+
+		// y * (3 - x * y^2) / 2
+		if err = eval.MulRelin(y, t, y); err != nil {
+			return nil, err
+		}
+		if err = eval.Rescale(y, y); err != nil {
+			return nil, err
+		}
+	}
+
+	return y, nil
+}
+
+// Chebyshev interpolant of 1/sqrt(v + a) over v in [0, 2^(log2max+1)], a = 2^(log2max+1) / inverseSqrtDegree^2
+func inverseSqrtStart(log2max float64) bignum.Polynomial {
+	b := math.Exp2(log2max + 1)
+	a := b / (inverseSqrtDegree * inverseSqrtDegree)
+	interval := bignum.Interval{
+		A:     *bignum.NewFloat(0, 128),
+		B:     *bignum.NewFloat(b, 128),
+		Nodes: inverseSqrtDegree,
+	}
+	return bignum.ChebyshevApproximation(func(v float64) float64 { return 1 / math.Sqrt(v+a) }, interval)
+}
+
+// Number of Newton iterations after which the relative error of 1/sqrt(x) is below the precision of the scale from every
+// start y0 with y0 * sqrt(x) in [lo, hi], 0 < lo <= 1 <= hi < sqrt(3). The relative error y * sqrt(x) grows by a factor
+// 3/2 per iteration while it is small and then converges quadratically, a start above 1 falls below 1 after one
+// iteration. The lowest start needs the most iterations.
+func inverseSqrtIterations(params ckks.Parameters, scale rlwe.Scale, lo, hi float64) int {
+
+	// 2^{-(prec - LogN + 1)}, as for the Goldschmidt division of the inverse evaluator
+	prec := float64(params.N()/2) / scale.Float64()
+
+	iters := 0
+	for math.Abs(1-lo) >= prec || math.Abs(1-hi) >= prec {
+		lo = lo * (3 - lo*lo) / 2
+		hi = hi * (3 - hi*hi) / 2
+		iters++
+	}
+
+	return iters
+}
@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+// The start of InverseSqrtNew stays within inverseSqrtStartError of the function it interpolates over its interval
+func TestInverseSqrtStart(t *testing.T) {
+	for _, log2max := range []float64{-4, 6, VarianceLogMax, 40} {
+		start := inverseSqrtStart(log2max)
+		coeffs := make([]float64, len(start.Coeffs))
+		for k, c := range start.Coeffs {
+			coeffs[k], _ = c[0].Float64()
+		}
+		b := math.Exp2(log2max + 1)
+		a := b / (inverseSqrtDegree * inverseSqrtDegree)
+		for i := 0; i <= 4096; i++ {
+			// Denser near 0, where 1/sqrt(v + a) varies the most
+			v := b * math.Pow(float64(i)/4096, 4)
+
+			// Clenshaw recurrence of the Chebyshev series at v mapped to [-1, 1]
+			x := 2*v/b - 1
+			var b1, b2 float64
+			for k := len(coeffs) - 1; k > 0; k-- {
+				b1, b2 = 2*x*b1-b2+coeffs[k], b1
+			}
+			y := x*b1 - b2 + coeffs[0]
+			if e := math.Abs(y*math.Sqrt(v+a) - 1); e > inverseSqrtStartError {
+				t.Fatalf("log2max %v: relative error %v at %v, want at most %v", log2max, e, v, inverseSqrtStartError)
+			}
+		}
+	}
+}
+
+// Inverse of the standard deviation at the bounds and inside of domains of the variance, in every slot
+func TestInverseStd(t *testing.T) {
+	if testing.Short() {
+		t.Skip("runs the refreshes of the whole iteration")
+	}
+
+	params := newTestParams(t, 12)
+	parties := GenZscoreParties(params, 3)
+	s := newTestSession(t, params, parties)
+	for _, log2max := range []float64{6, VarianceLogMax} {
+		t.Run(fmt.Sprintf("log2max=%v", log2max), func(t *testing.T) {
+			variances := []float64{0, math.Exp2(VarianceLogMin), 1, math.Exp2(log2max / 2), math.Exp2(log2max)}
+			values := make([]float64, params.MaxSlots())
+			expected := make([]float64, params.MaxSlots())
+			for i := range values {
+				values[i] = variances[i%len(variances)]
+				expected[i] = 1 / math.Sqrt(values[i]+math.Exp2(VarianceLogMin))
+			}
+
+			ct, err := EncryptOneValue(params, s.Pk, values)
+			if err != nil {
+				t.Fatal(err)
+			}
+			invStd, err := s.InverseStd(ct, VarianceLogMin, log2max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			decrypted, err := IdealSecretKeyDecryption(params, invStd, parties)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i := range decrypted {
+				if e := math.Abs(decrypted[i]/expected[i] - 1); !(e <= 1e-4) {
+					t.Fatalf("1/sqrt(%v + 2^%v): %v, want %v", values[i], VarianceLogMin, decrypted[i], expected[i])
+				}
+			}
+		})
+	}
+}
@@ -9,119 +9,166 @@ import (
 	"github.com/tuneinsight/lattigo/v6/utils/sampling"
 )
 
-var elapsedCKGCloud time.Duration
-var elapsedCKGParty time.Duration
-var elapsedRKGCloud time.Duration
-var elapsedRKGParty time.Duration
-var elapsedGKGCloud time.Duration
-var elapsedGKGParty time.Duration
+// Performs collective public key generation and publishes the key to the parties
+func CollectiveKeyGen(params ckks.Parameters, crs sampling.PRNG, cohort Cohort) (*rlwe.PublicKey, error) {
+	pk, _, _, err := collectiveKeyGen(params, crs, cohort)
+	return pk, err
+}
 
-// Performs collective public key generation
-func CollectiveKeyGen(params ckks.Parameters, crs sampling.PRNG, P []*Party) *rlwe.PublicKey {
+// CollectiveKeyGen, also returns the time spent by the parties and by the cloud
+func collectiveKeyGen(params ckks.Parameters, crs sampling.PRNG, cohort Cohort) (pk *rlwe.PublicKey, party, cloud time.Duration, err error) {
 
 	ckg := multiparty.NewPublicKeyGenProtocol(params) // Public key generation
 	ckgCombined := ckg.AllocateShare()
-	for _, pi := range P {
-		pi.ckgShare = ckg.AllocateShare()
-	}
 
-	crp := ckg.SampleCRP(crs)
-
-	elapsedCKGParty = RunTimedParty(func() {
-		for _, pi := range P {
-			/* #nosec G601 -- Implicit memory aliasing in for loop acknowledged */
-			ckg.GenShare(pi.Sk, crp, &pi.ckgShare)
-		}
-	}, len(P))
+	seed, err := newSeed(crs)
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseCKG, err)
+	}
+	prng, err := newKeyedPRNG(seed)
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseCKG, err)
+	}
+	crp := ckg.SampleCRP(prng)
+
+	var shares []multiparty.PublicKeyGenShare
+	party = RunTimedParty(func() {
+		shares, err = cohort.PublicKeyGenRound(seed)
+	}, cohort.Len())
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseCKG, err)
+	}
+	if err = checkShares(cohort.Len(), shares, ckgCombined); err != nil {
+		return nil, 0, 0, phaseError(PhaseCKG, err)
+	}
 
-	pk := rlwe.NewPublicKey(params)
+	pk = rlwe.NewPublicKey(params)
 
-	elapsedCKGCloud = RunTimed(func() {
-		for _, pi := range P {
-			ckg.AggregateShares(pi.ckgShare, ckgCombined, &ckgCombined)
+	cloud = RunTimed(func() {
+		for _, share := range shares {
+			ckg.AggregateShares(share, ckgCombined, &ckgCombined)
 		}
 		ckg.GenPublicKey(ckgCombined, crp, pk)
 	})
 
-	return pk
+	if err = cohort.PublishPublicKey(pk); err != nil {
+		return nil, 0, 0, phaseError(PhaseCKG, err)
+	}
+
+	return pk, party, cloud, nil
 }
 
-func RelinearizationKeyGeneration(params ckks.Parameters, crs sampling.PRNG, P []*Party) *rlwe.RelinearizationKey {
+func RelinearizationKeyGeneration(params ckks.Parameters, crs sampling.PRNG, cohort Cohort) (*rlwe.RelinearizationKey, error) {
+	rlk, _, _, err := relinearizationKeyGeneration(params, crs, cohort)
+	return rlk, err
+}
+
+// RelinearizationKeyGeneration, also returns the time spent by the parties and by the cloud
+func relinearizationKeyGeneration(params ckks.Parameters, crs sampling.PRNG, cohort Cohort) (rlk *rlwe.RelinearizationKey, party, cloud time.Duration, err error) {
 
 	rkg := multiparty.NewRelinearizationKeyGenProtocol(params) // Relineariation key generation
 
 	_, rkgCombined1, rkgCombined2 := rkg.AllocateShare()
 
-	for _, pi := range P {
-		pi.rlkEphemSk, pi.rkgShareOne, pi.rkgShareTwo = rkg.AllocateShare()
+	seed, err := newSeed(crs)
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseRKG, err)
 	}
 
-	crp := rkg.SampleCRP(crs)
-
-	elapsedRKGParty = RunTimedParty(func() {
-		for _, pi := range P {
-			/* #nosec G601 -- Implicit memory aliasing in for loop acknowledged */
-			rkg.GenShareRoundOne(pi.Sk, crp, pi.rlkEphemSk, &pi.rkgShareOne)
-		}
-	}, len(P))
+	var shares []multiparty.RelinearizationKeyGenShare
+	party = RunTimedParty(func() {
+		shares, err = cohort.RelinearizationKeyGenRoundOne(seed)
+	}, cohort.Len())
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseRKG, err)
+	}
+	if err = checkShares(cohort.Len(), shares, rkgCombined1); err != nil {
+		return nil, 0, 0, phaseError(PhaseRKG, err)
+	}
 
-	elapsedRKGCloud = RunTimed(func() {
-		for _, pi := range P {
-			/* #nosec G601 -- Implicit memory aliasing in for loop acknowledged */
-			rkg.AggregateShares(pi.rkgShareOne, rkgCombined1, &rkgCombined1)
+	cloud = RunTimed(func() {
+		for _, share := range shares {
+			rkg.AggregateShares(share, rkgCombined1, &rkgCombined1)
 		}
 	})
 
-	elapsedRKGParty += RunTimedParty(func() {
-		for _, pi := range P {
-			/* #nosec G601 -- Implicit memory aliasing in for loop acknowledged */
-			rkg.GenShareRoundTwo(pi.rlkEphemSk, pi.Sk, rkgCombined1, &pi.rkgShareTwo)
-		}
-	}, len(P))
+	party += RunTimedParty(func() {
+		shares, err = cohort.RelinearizationKeyGenRoundTwo(rkgCombined1)
+	}, cohort.Len())
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseRKG, err)
+	}
+	if err = checkShares(cohort.Len(), shares, rkgCombined2); err != nil {
+		return nil, 0, 0, phaseError(PhaseRKG, err)
+	}
 
-	rlk := rlwe.NewRelinearizationKey(params)
-	elapsedRKGCloud += RunTimed(func() {
-		for _, pi := range P {
-			/* #nosec G601 -- Implicit memory aliasing in for loop acknowledged */
-			rkg.AggregateShares(pi.rkgShareTwo, rkgCombined2, &rkgCombined2)
+	rlk = rlwe.NewRelinearizationKey(params)
+	cloud += RunTimed(func() {
+		for _, share := range shares {
+			rkg.AggregateShares(share, rkgCombined2, &rkgCombined2)
 		}
 		rkg.GenRelinearizationKey(rkgCombined1, rkgCombined2, rlk)
 	})
 
-	return rlk
+	return rlk, party, cloud, nil
 }
 
 
-func Gkgphase2(params ckks.Parameters, crs sampling.PRNG, P []*Party, N int) (galKeys *rlwe.GaloisKey) {
-	
-	gkg := make([]multiparty.GaloisKeyGenProtocol, N)
-	for i := range gkg {
-		if i == 0 {
-			gkg[i] = multiparty.NewGaloisKeyGenProtocol(params)
-		} else {
-			gkg[i] = gkg[0].ShallowCopy()
-		}
-	}
+// Performs the collective generation of one Galois key per element of galEls, e.g. the complex conjugation
+// (params.GaloisElementForComplexConjugation) or the rotations of RotationGaloisElements and InnerSumGaloisElements
+func GaloisKeysGeneration(params ckks.Parameters, crs sampling.PRNG, cohort Cohort, galEls []uint64) ([]*rlwe.GaloisKey, error) {
+	galKeys, _, _, err := galoisKeysGeneration(params, crs, cohort, galEls)
+	return galKeys, err
+}
 
-	for i, pi := range P {
-		pi.gkgShare = gkg[i].AllocateShare()
-	}
+// GaloisKeysGeneration, also returns the time spent by the parties and by the cloud on every key
+func galoisKeysGeneration(params ckks.Parameters, crs sampling.PRNG, cohort Cohort, galEls []uint64) (galKeys []*rlwe.GaloisKey, party, cloud time.Duration, err error) {
 
-	crp := gkg[0].SampleCRP(crs)
+	gkg := multiparty.NewGaloisKeyGenProtocol(params) // Galois key generation
 
-	galEl := params.GaloisElementForComplexConjugation()
+	galKeys = make([]*rlwe.GaloisKey, len(galEls))
+	for i, galEl := range galEls {
 
-	for i, pi := range P {
-		gkg[i].GenShare(pi.Sk, galEl, crp, &pi.gkgShare)
-	}
+		// One round per key, every key has its own common reference polynomial
+		seed, err := newSeed(crs)
+		if err != nil {
+			return nil, 0, 0, phaseError(PhaseGKG, err)
+		}
+		prng, err := newKeyedPRNG(seed)
+		if err != nil {
+			return nil, 0, 0, phaseError(PhaseGKG, err)
+		}
+		crp := gkg.SampleCRP(prng)
+
+		// The shares of another Galois element fail to aggregate with the combined share
+		gkgCombined := gkg.AllocateShare()
+		gkgCombined.GaloisElement = galEl
+
+		var shares []multiparty.GaloisKeyGenShare
+		party += RunTimedParty(func() {
+			shares, err = cohort.GaloisKeyGenRound(seed, galEl)
+		}, cohort.Len())
+		if err != nil {
+			return nil, 0, 0, phaseError(PhaseGKG, err)
+		}
+		if err = checkShares(cohort.Len(), shares, gkgCombined); err != nil {
+			return nil, 0, 0, phaseError(PhaseGKG, err)
+		}
 
-	for i, pi := range P {
-		if i != 0 {
-			gkg[0].AggregateShares(P[0].gkgShare, pi.gkgShare, &P[0].gkgShare)
+		galKeys[i] = rlwe.NewGaloisKey(params)
+		cloud += RunTimed(func() {
+			for j, share := range shares {
+				if err = gkg.AggregateShares(share, gkgCombined, &gkgCombined); err != nil {
+					err = &PartyError{Party: j, Err: err}
+					return
+				}
+			}
+			err = gkg.GenGaloisKey(gkgCombined, crp, galKeys[i])
+		})
+		if err != nil {
+			return nil, 0, 0, phaseError(PhaseGKG, err)
 		}
 	}
 
-	galoisKey := rlwe.NewGaloisKey(params)
-	gkg[0].GenGaloisKey(P[0].gkgShare, crp, galoisKey)
-	return galoisKey
+	return galKeys, party, cloud, nil
 }
@@ -0,0 +1,422 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Statistics a party can pack in a slot layout, computed over its samples of each feature
+const (
+	StatSum        = "sum"
+	StatCount      = "count"
+	StatSumSquares = "sum-squares"
+	StatMin        = "min"
+	StatMax        = "max"
+	StatHistogram  = "histogram"
+)
+
+// Order of the statistics in the query arguments
+var slotStats = []string{StatSum, StatCount, StatSumSquares, StatMin, StatMax, StatHistogram}
+
+// SlotLayout packs statistics of NFeatures features into the slots of shared ciphertexts, so that a party sends one
+// ciphertext for several statistics and the aggregated statistics can be revealed at once.
+//
+// Each statistic is a block of Width values per feature, Width being 1 except for the histograms. The blocks are
+// added one after the other: value v of feature j of a block is in slot Offset + j*Width + v of ciphertext Ciphertext.
+// A block never spans two ciphertexts, a new ciphertext is opened when the block does not fit in the current one.
+// String prints the slot map.
+//
+// Shift, if set, is subtracted from the samples of feature j before their sums and sums of squares, as the shifted sums
+// of the one round z-score. Extract aligns a block of the aggregated ciphertexts with the slots of the features.
+type SlotLayout struct {
+	Slots     int
+	NFeatures int
+	Shift     []float64
+	Blocks    []SlotBlock
+}
+
+// SlotBlock is the statistic Stat of every feature. The Width buckets of a histogram split [Lo[j], Hi[j]) of feature j evenly.
+type SlotBlock struct {
+	Stat       string
+	Width      int
+	Ciphertext int
+	Offset     int
+	Lo, Hi     []float64
+}
+
+// NewSlotLayout creates an empty layout of NFeatures features over ciphertexts of the given number of slots
+func NewSlotLayout(slots, NFeatures int) *SlotLayout {
+	return &SlotLayout{Slots: slots, NFeatures: NFeatures}
+}
+
+// Add adds a block of one value per feature for stat, which cannot be StatHistogram
+func (l *SlotLayout) Add(stat string) error {
+	if stat == StatHistogram {
+		return fmt.Errorf("%w: the histograms are added with AddHistogram", ErrInvalidQuery)
+	}
+	return l.add(SlotBlock{Stat: stat, Width: 1})
+}
+
+// AddHistogram adds a block of B buckets per feature, the buckets of feature j split [lo[j], hi[j])
+func (l *SlotLayout) AddHistogram(B int, lo, hi []float64) error {
+	if B < 1 || len(lo) != l.NFeatures || len(hi) != l.NFeatures {
+		return fmt.Errorf("%w: histogram of %d buckets with %d and %d bounds for %d features", ErrInvalidQuery, B, len(lo), len(hi), l.NFeatures)
+	}
+	return l.add(SlotBlock{Stat: StatHistogram, Width: B, Lo: lo, Hi: hi})
+}
+
+func (l *SlotLayout) add(b SlotBlock) error {
+	if statCode(b.Stat) < 0 {
+		return fmt.Errorf("%w: unknown statistic %q", ErrInvalidQuery, b.Stat)
+	}
+	if _, ok := l.Block(b.Stat); ok {
+		return fmt.Errorf("%w: statistic %q is already in the layout", ErrInvalidQuery, b.Stat)
+	}
+
+	size := b.Width * l.NFeatures
+	if size > l.Slots {
+		return fmt.Errorf("%w: %d values of %q for %d slots", ErrEncodingOverflow, size, b.Stat, l.Slots)
+	}
+
+	if n := len(l.Blocks); n > 0 {
+		last := l.Blocks[n-1]
+		b.Ciphertext, b.Offset = last.Ciphertext, last.Offset+last.Width*l.NFeatures
+		if b.Offset+size > l.Slots {
+			b.Ciphertext, b.Offset = b.Ciphertext+1, 0
+		}
+	}
+
+	l.Blocks = append(l.Blocks, b)
+	return nil
+}
+
+// Block returns the block of stat
+func (l *SlotLayout) Block(stat string) (SlotBlock, bool) {
+	for _, b := range l.Blocks {
+		if b.Stat == stat {
+			return b, true
+		}
+	}
+	return SlotBlock{}, false
+}
+
+// GaloisElements returns the Galois elements of the rotations of Extract, one per block that does not start at slot 0
+func (l *SlotLayout) GaloisElements(params ckks.Parameters) []uint64 {
+	var galEls []uint64
+	for _, b := range l.Blocks {
+		if b.Offset > 0 {
+			galEls = append(galEls, params.GaloisElement(b.Offset))
+		}
+	}
+	return galEls
+}
+
+// Ciphertexts returns the number of ciphertexts of the layout
+func (l *SlotLayout) Ciphertexts() int {
+	if len(l.Blocks) == 0 {
+		return 0
+	}
+	return l.Blocks[len(l.Blocks)-1].Ciphertext + 1
+}
+
+// Spread returns the slots of ciphertext c with values[stat][j] in every slot of feature j of the block of stat, and fill
+// in the slots of the other blocks and in the free slots
+func (l *SlotLayout) Spread(c int, fill float64, values map[string][]float64) []float64 {
+	vector := make([]float64, l.Slots)
+	for i := range vector {
+		vector[i] = fill
+	}
+	for _, b := range l.Blocks {
+		perFeature, ok := values[b.Stat]
+		if b.Ciphertext != c || !ok {
+			continue
+		}
+		for j := 0; j < l.NFeatures && j < len(perFeature); j++ {
+			for v := 0; v < b.Width; v++ {
+				vector[b.Offset+j*b.Width+v] = perFeature[j]
+			}
+		}
+	}
+	return vector
+}
+
+// Mask returns the slots of ciphertext c with 1 in the blocks of stats and 0 elsewhere
+func (l *SlotLayout) Mask(c int, stats ...string) []float64 {
+	ones := make([]float64, l.NFeatures)
+	for j := range ones {
+		ones[j] = 1
+	}
+	values := make(map[string][]float64, len(stats))
+	for _, stat := range stats {
+		values[stat] = ones
+	}
+	return l.Spread(c, 0, values)
+}
+
+// Values returns the Width values of every feature of stat from the decrypted slots of the ciphertexts of the layout
+func (l *SlotLayout) Values(vectors [][]float64, stat string) ([][]float64, error) {
+	b, ok := l.Block(stat)
+	if !ok {
+		return nil, fmt.Errorf("%w: statistic %q is not in the layout", ErrInvalidQuery, stat)
+	}
+	if b.Ciphertext >= len(vectors) || len(vectors[b.Ciphertext]) < b.Offset+b.Width*l.NFeatures {
+		return nil, fmt.Errorf("%w: slots of %q are missing", ErrMissingShare, stat)
+	}
+
+	values := make([][]float64, l.NFeatures)
+	for j := range values {
+		start := b.Offset + j*b.Width
+		values[j] = append([]float64{}, vectors[b.Ciphertext][start:start+b.Width]...)
+	}
+	return values, nil
+}
+
+// FeatureValues returns the value of every feature of a statistic of width 1, see Values
+func (l *SlotLayout) FeatureValues(vectors [][]float64, stat string) ([]float64, error) {
+	values, err := l.Values(vectors, stat)
+	if err != nil {
+		return nil, err
+	}
+	perFeature := make([]float64, len(values))
+	for j, v := range values {
+		perFeature[j] = v[0]
+	}
+	return perFeature, nil
+}
+
+// String returns the slot map of the layout, one line per block
+func (l *SlotLayout) String() string {
+	var sb strings.Builder
+	for _, b := range l.Blocks {
+		fmt.Fprintf(&sb, "ciphertext %d, slots [%d, %d): %s, %d value(s) per feature\n", b.Ciphertext, b.Offset, b.Offset+b.Width*l.NFeatures, b.Stat, b.Width)
+	}
+	return sb.String()
+}
+
+// Args encodes the blocks of ciphertext c as the arguments of QueryPacked: NFeatures, the number of shifts (0 or
+// NFeatures) and the shifts, the number of blocks and, for each block, the code of the statistic, its width, its offset
+// and, for a histogram, the lower and then the upper bounds
+func (l *SlotLayout) Args(c int) []float64 {
+	args := append(append([]float64{float64(l.NFeatures), float64(len(l.Shift))}, l.Shift...), 0)
+	nBlocks := len(args) - 1
+	for _, b := range l.Blocks {
+		if b.Ciphertext != c {
+			continue
+		}
+		args[nBlocks]++
+		args = append(args, float64(statCode(b.Stat)), float64(b.Width), float64(b.Offset))
+		if b.Stat == StatHistogram {
+			args = append(args, b.Lo...)
+			args = append(args, b.Hi...)
+		}
+	}
+	return args
+}
+
+func parseSlotBlocks(args []float64) (NFeatures int, shift []float64, blocks []SlotBlock, err error) {
+	malformed := fmt.Errorf("%w: malformed slot layout", ErrInvalidQuery)
+	if len(args) < 2 {
+		return 0, nil, nil, malformed
+	}
+
+	NFeatures, nShifts := int(args[0]), int(args[1])
+	if (nShifts != 0 && nShifts != NFeatures) || len(args) < 3+nShifts {
+		return 0, nil, nil, malformed
+	}
+	shift, nBlocks := args[2:2+nShifts], int(args[2+nShifts])
+	args = args[3+nShifts:]
+	for i := 0; i < nBlocks; i++ {
+		if len(args) < 3 {
+			return 0, nil, nil, malformed
+		}
+		code, width, offset := int(args[0]), int(args[1]), int(args[2])
+		args = args[3:]
+		if code < 0 || code >= len(slotStats) || width < 1 || offset < 0 {
+			return 0, nil, nil, malformed
+		}
+
+		b := SlotBlock{Stat: slotStats[code], Width: width, Offset: offset}
+		if b.Stat == StatHistogram {
+			if len(args) < 2*NFeatures {
+				return 0, nil, nil, malformed
+			}
+			b.Lo, b.Hi, args = args[:NFeatures], args[NFeatures:2*NFeatures], args[2*NFeatures:]
+		}
+		blocks = append(blocks, b)
+	}
+	if len(args) != 0 {
+		return 0, nil, nil, malformed
+	}
+	return NFeatures, shift, blocks, nil
+}
+
+func statCode(stat string) int {
+	for i, s := range slotStats {
+		if s == stat {
+			return i
+		}
+	}
+	return -1
+}
+
+// InputLayout runs one input round of QueryPacked per ciphertext of the layout, cts[c][i] is the c-th ciphertext of party i
+func (s *Session) InputLayout(l *SlotLayout) (cts [][]*rlwe.Ciphertext, err error) {
+	cts = make([][]*rlwe.Ciphertext, l.Ciphertexts())
+	for c := range cts {
+		if cts[c], err = s.Input(Query{Name: QueryPacked, Args: l.Args(c)}); err != nil {
+			return nil, err
+		}
+	}
+	return cts, nil
+}
+
+// Extract returns the block of stat of ct, an aggregated ciphertext of the layout, rotated to the slots
+// [0, Width*NFeatures) with fill in the other slots, so that it combines slot by slot with the ciphertexts of one value
+// per feature (e.g. fill 1 keeps a count invertible in every slot). The rotation needs the GaloisElements of the layout,
+// it consumes one level.
+func (s *Session) Extract(l *SlotLayout, ct *rlwe.Ciphertext, stat string, fill float64) (*rlwe.Ciphertext, error) {
+	b, ok := l.Block(stat)
+	if !ok {
+		return nil, fmt.Errorf("%w: statistic %q is not in the layout", ErrInvalidQuery, stat)
+	}
+
+	eval := ckks.NewEvaluator(s.Params, s.Evk)
+
+	// The other blocks are cleared before the rotation brings them in the slots of the features
+	block, err := eval.MulNew(ct, l.Mask(b.Ciphertext, stat))
+	if err != nil {
+		return nil, err
+	}
+	if err = eval.Rescale(block, block); err != nil {
+		return nil, err
+	}
+	if b.Offset > 0 {
+		if err = eval.Rotate(block, b.Offset, block); err != nil {
+			return nil, err
+		}
+	}
+
+	if fill != 0 {
+		outside := make([]float64, l.Slots)
+		for i := b.Width * l.NFeatures; i < l.Slots; i++ {
+			outside[i] = fill
+		}
+		if err = eval.Add(block, outside, block); err != nil {
+			return nil, err
+		}
+	}
+	return block, nil
+}
+
+// Combine returns the sum of the ciphertexts multiplied by their masks, e.g. to reveal statistics computed in different
+// ciphertexts with a single collective key switch. The result is at the default scale, it consumes two levels.
+func (s *Session) Combine(cts []*rlwe.Ciphertext, masks [][]float64) (*rlwe.Ciphertext, error) {
+	if len(cts) == 0 || len(cts) != len(masks) {
+		return nil, fmt.Errorf("%w: %d ciphertexts for %d masks", ErrMissingShare, len(cts), len(masks))
+	}
+
+	eval := ckks.NewEvaluator(s.Params, s.Evk)
+
+	var sum *rlwe.Ciphertext
+	for i, ct := range cts {
+		masked, err := eval.MulNew(ct, masks[i])
+		if err != nil {
+			return nil, err
+		}
+		if err = eval.Rescale(masked, masked); err != nil {
+			return nil, err
+		}
+
+		// The ciphertexts can have different scales, they are added at the default scale
+		if err = eval.SetScale(masked, s.Params.DefaultScale()); err != nil {
+			return nil, err
+		}
+
+		if sum == nil {
+			sum = masked
+		} else if err = eval.Add(sum, masked, sum); err != nil {
+			return nil, err
+		}
+	}
+	return sum, nil
+}
+
+// Samples of each feature of the party
+func (p *Party) samples() [][]float64 {
+	if p.Data != nil {
+		return p.Data.Samples
+	}
+	return p.RobustScalingInput
+}
+
+// Statistics of the blocks of one ciphertext of a slot layout, args is SlotLayout.Args
+func (p *Party) packed(args []float64) ([]float64, error) {
+	NFeatures, shift, blocks, err := parseSlotBlocks(args)
+	if err != nil {
+		return nil, err
+	}
+	samples := p.samples()
+	if samples == nil {
+		return nil, fmt.Errorf("%w: the party holds no samples", ErrInvalidQuery)
+	}
+	if NFeatures != len(samples) {
+		return nil, fmt.Errorf("%w: slot layout of %d features, the party holds %d", ErrInvalidQuery, NFeatures, len(samples))
+	}
+
+	size := 0
+	for _, b := range blocks {
+		size = max(size, b.Offset+b.Width*NFeatures)
+	}
+
+	values := make([]float64, size)
+	for _, b := range blocks {
+		for j, xs := range samples {
+			block := values[b.Offset+j*b.Width : b.Offset+(j+1)*b.Width]
+			if len(xs) == 0 {
+				continue
+			}
+			switch b.Stat {
+			case StatSum:
+				for _, x := range xs {
+					block[0] += x
+				}
+				if len(shift) > 0 {
+					block[0] -= float64(len(xs)) * shift[j]
+				}
+			case StatCount:
+				block[0] = float64(len(xs))
+			case StatSumSquares:
+				s := 0.0
+				if len(shift) > 0 {
+					s = shift[j]
+				}
+				for _, x := range xs {
+					block[0] += (x - s) * (x - s)
+				}
+			case StatMin, StatMax:
+				block[0] = xs[0]
+				for _, x := range xs {
+					if b.Stat == StatMin {
+						block[0] = math.Min(block[0], x)
+					} else {
+						block[0] = math.Max(block[0], x)
+					}
+				}
+			case StatHistogram:
+				width := (b.Hi[j] - b.Lo[j]) / float64(b.Width)
+				for _, x := range xs {
+					if x < b.Lo[j] || x >= b.Hi[j] {
+						continue
+					}
+					k := min(int((x-b.Lo[j])/width), b.Width-1)
+					block[k]++
+				}
+			}
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// The shifted statistics of a packed query, and their blocks extracted to the slots of the features
+func TestSlotLayoutExtract(t *testing.T) {
+	params, err := ckks.NewParametersFromLiteral(ParametersLiteralLogN(12))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parties := GenZscoreParties(params, 2)
+	NFeatures := len(parties[0].Data.Samples)
+
+	layout := NewSlotLayout(params.MaxSlots(), NFeatures)
+	layout.Shift = []float64{50, 45, 40, 35}
+	for _, stat := range []string{StatSum, StatSumSquares, StatCount} {
+		if err = layout.Add(stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	values, err := parties[0].Answer(Query{Name: QueryPacked, Args: layout.Args(0)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[string][]float64)
+	for j, samples := range parties[0].Data.Samples {
+		var sum, sumSquares float64
+		for _, x := range samples {
+			sum += x - layout.Shift[j]
+			sumSquares += (x - layout.Shift[j]) * (x - layout.Shift[j])
+		}
+		expected[StatSum] = append(expected[StatSum], sum)
+		expected[StatSumSquares] = append(expected[StatSumSquares], sumSquares)
+		expected[StatCount] = append(expected[StatCount], float64(len(samples)))
+	}
+	for stat, want := range expected {
+		got, err := layout.FeatureValues([][]float64{values}, stat)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValues(t, got, want, 1e-9)
+	}
+
+	s := newTestSession(t, params, parties, layout.GaloisElements(params)...)
+
+	// Values of the scale of the counts in every block
+	packed := make([]float64, params.MaxSlots())
+	for i := 0; i < 3*NFeatures; i++ {
+		packed[i] = float64(i + 1)
+	}
+	ct, err := EncryptOneValue(params, s.Pk, packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for k, stat := range []string{StatSum, StatSumSquares, StatCount} {
+		fill := float64(k)
+		extracted, err := s.Extract(layout, ct, stat, fill)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := IdealSecretKeyDecryption(params, extracted, parties)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := make([]float64, params.MaxSlots())
+		for i := range want {
+			want[i] = fill
+			if i < NFeatures {
+				want[i] = packed[k*NFeatures+i]
+			}
+		}
+		if p := ComparePrecision(stat, decrypted, want); p.NonFinite > 0 || p.MaxAbsError > 1e-3 {
+			t.Errorf("%s, tolerance %.1e", p, 1e-3)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"encoding"
+	"fmt"
+)
+
+type MessageType uint8
+
+// Message types exchanged between the aggregator and the parties. Every request of the aggregator
+// is answered by the party with MsgShare (or MsgError), carrying the same round number.
+const (
+	MsgHello MessageType = iota + 1
+	MsgPublicKeyGen
+	MsgPublicKey
+	MsgRelinKeyGenRoundOne
+	MsgRelinKeyGenRoundTwo
+	MsgGaloisKeyGen
+	MsgPublicKeySwitch
+	MsgRefresh
+	MsgInput
+	MsgShare
+	MsgError
+	MsgDone
+	MsgThresholdKeyExchange
+	MsgShamirShares
+	MsgShamirAggregate
+)
+
+// Message is one protocol message, shares, keys and ciphertexts travel marshalled in Objects.
+// See WriteMessage for its wire format.
+type Message struct {
+	Type    MessageType
+	Session SessionID
+	Round   uint32
+	PartyID int
+
+	NParties      int
+	Threshold     int
+	Active        []int
+	Seed          []byte
+	GaloisElement uint64
+	Query         Query
+	Error         string
+
+	Objects []Object
+}
+
+func marshalObjects(objs ...encoding.BinaryMarshaler) ([]Object, error) {
+	objects := make([]Object, len(objs))
+	for i, obj := range objs {
+		var err error
+		if objects[i], err = NewObject(obj); err != nil {
+			return nil, err
+		}
+	}
+	return objects, nil
+}
+
+func unmarshalObjects(msg *Message, objs ...encoding.BinaryUnmarshaler) error {
+	if len(msg.Objects) != len(objs) {
+		return fmt.Errorf("%w: message type %d from party %d has %d objects, expected %d", ErrMissingShare, msg.Type, msg.PartyID, len(msg.Objects), len(objs))
+	}
+	for i, obj := range objs {
+		if err := msg.Objects[i].Decode(obj); err != nil {
+			return fmt.Errorf("message type %d from party %d: %w", msg.Type, msg.PartyID, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"encoding/json"
+	"os"
+	"time"
+)
+
+// Metrics gathers the costs of a session for the cost tables of the experiments. The session fills it in Setup,
+// Thresholdize, the input rounds, Aggregate, the key switches of Reveal and the refreshes of its Refresher, and the
+// communication of every round of its cohort.
+type Metrics struct {
+	// Costs of each phase, by phase name (PhaseCKG, PhaseRKG, PhaseGKG, PhaseThreshold, PhaseEncrypt, PhasePCKS and PhaseRefresh)
+	Phases map[string]*PhaseMetrics `json:"phases"`
+
+	// Number of refreshes, the bootstraps of the circuits, and of collective key switches
+	Refreshes   int `json:"refreshes"`
+	KeySwitches int `json:"key_switches"`
+
+	// Levels consumed by the homomorphic evaluation: the sum of MaxLevel minus the level of every ciphertext that is
+	// refreshed or key switched, and the lowest of these levels
+	LevelsConsumed int `json:"levels_consumed"`
+	MinLevel       int `json:"min_level"`
+
+	// Bytes each party sent to the aggregator and received from it over the session, by party ID
+	PartyUpload   []int64 `json:"party_upload"`
+	PartyDownload []int64 `json:"party_download"`
+
+	// Communication of every round, in the order they were run
+	Rounds []RoundTraffic `json:"rounds"`
+
+	// Precision of each revealed statistic against its plaintext reference, when the aggregator holds the parties'
+	// data (simulation mode)
+	Precision []StatPrecision `json:"precision,omitempty"`
+}
+
+// PhaseMetrics is the cost of the rounds of one phase. Party is the time of one party, the time of a round divided by
+// the number of parties taking part as in RunTimedParty, and Cloud the time of the aggregator. Upload and Download
+// are the bytes all the parties sent to the aggregator and received from it.
+type PhaseMetrics struct {
+	Rounds   int           `json:"rounds"`
+	Party    time.Duration `json:"party_ns"`
+	Cloud    time.Duration `json:"cloud_ns"`
+	Upload   int64         `json:"upload_bytes"`
+	Download int64         `json:"download_bytes"`
+}
+
+// NewMetrics creates empty metrics
+func NewMetrics() *Metrics {
+	return &Metrics{Phases: make(map[string]*PhaseMetrics), MinLevel: -1}
+}
+
+// Adds rounds of the phase and their times
+func (m *Metrics) add(phase string, rounds int, party, cloud time.Duration) {
+	if m == nil {
+		return
+	}
+	p, ok := m.Phases[phase]
+	if !ok {
+		p = &PhaseMetrics{}
+		m.Phases[phase] = p
+	}
+	p.Rounds += rounds
+	p.Party += party
+	p.Cloud += cloud
+}
+
+// Records the communication of a round in its phase and in the totals of its parties
+func (m *Metrics) traffic(r RoundTraffic) {
+	if m == nil {
+		return
+	}
+	m.add(r.Phase, 0, 0, 0)
+	p := m.Phases[r.Phase]
+	for k, i := range r.Parties {
+		for len(m.PartyUpload) <= i {
+			m.PartyUpload = append(m.PartyUpload, 0)
+			m.PartyDownload = append(m.PartyDownload, 0)
+		}
+		m.PartyUpload[i] += int64(r.Upload[k])
+		m.PartyDownload[i] += int64(r.Download[k])
+		p.Upload += int64(r.Upload[k])
+		p.Download += int64(r.Download[k])
+	}
+	m.Rounds = append(m.Rounds, r)
+}
+
+// Records a refresh of a ciphertext at the level
+func (m *Metrics) refresh(maxLevel, level int, party, cloud time.Duration) {
+	if m == nil {
+		return
+	}
+	m.add(PhaseRefresh, 1, party, cloud)
+	m.Refreshes++
+	m.consume(maxLevel, level)
+}
+
+// Records a collective key switch of a ciphertext at the level
+func (m *Metrics) keySwitch(maxLevel, level int, party, cloud time.Duration) {
+	if m == nil {
+		return
+	}
+	m.add(PhasePCKS, 1, party, cloud)
+	m.KeySwitches++
+	m.consume(maxLevel, level)
+}
+
+// Records the level of a ciphertext that is refreshed or key switched
+func (m *Metrics) consume(maxLevel, level int) {
+	m.LevelsConsumed += maxLevel - level
+	if m.MinLevel < 0 || level < m.MinLevel {
+		m.MinLevel = level
+	}
+}
+
+// RecordPrecision compares the values of the statistic with their plaintext reference and returns the comparison
+func (m *Metrics) RecordPrecision(stat string, values, expected []float64) StatPrecision {
+	p := ComparePrecision(stat, values, expected)
+	if m != nil {
+		m.Precision = append(m.Precision, p)
+	}
+	return p
+}
+
+// JSON returns the metrics as indented JSON, the durations are in nanoseconds
+func (m *Metrics) JSON() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// WriteFile writes the metrics as JSON to the file of the path
+func (m *Metrics) WriteFile(path string) error {
+	data, err := m.JSON()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append(data, '\n'), 0o644)
+}
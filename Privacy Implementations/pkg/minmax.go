@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/comparison"
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/minimax"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Min returns the slot-wise minimum of the parties' ciphertexts, see Extremum
+func (s *Session) Min(cts []*rlwe.Ciphertext, bounds []float64) (*rlwe.Ciphertext, error) {
+	return s.Extremum(cts, bounds, false)
+}
+
+// Max returns the slot-wise maximum of the parties' ciphertexts, see Extremum
+func (s *Session) Max(cts []*rlwe.Ciphertext, bounds []float64) (*rlwe.Ciphertext, error) {
+	return s.Extremum(cts, bounds, true)
+}
+
+// Extremum returns the slot-wise maximum (or minimum) of the parties' ciphertexts with the comparison evaluator,
+// which needs the Galois key of the complex conjugation. The comparisons evaluate the sign of the difference of two
+// values, which must lie in [-1, 1]: the values of slot j are divided by 2*bounds[j] before the comparisons and
+// multiplied back after, so every value of slot j must lie in [-bounds[j], bounds[j]]. The slots past bounds have a
+// bound of 1, the slots of infinite bound are ignored and hold 0 in the result, e.g. the slots of other statistics of
+// a SlotLayout.
+func (s *Session) Extremum(cts []*rlwe.Ciphertext, bounds []float64, max bool) (*rlwe.Ciphertext, error) {
+
+	if len(cts) == 0 {
+		return nil, fmt.Errorf("%w: no ciphertext to compare", ErrMissingShare)
+	}
+	if len(bounds) > s.Params.MaxSlots() {
+		return nil, fmt.Errorf("%w: %d bounds for %d slots", ErrEncodingOverflow, len(bounds), s.Params.MaxSlots())
+	}
+
+	params := s.Params
+	btp := s.Refresher
+
+	// Evaluator
+	eval := ckks.NewEvaluator(params, s.Evk)
+
+	// Comparison evaluator with the default polynomial for the sign
+	cmpEval := comparison.NewEvaluator(params, minimax.NewEvaluator(params, eval, btp), minimax.NewPolynomial(comparison.DefaultCompositePolynomialForSign))
+
+	normalizationVector := make([]float64, params.MaxSlots())
+	reverseNormalizationVector := make([]float64, params.MaxSlots())
+	for i := range normalizationVector {
+		normalizationVector[i], reverseNormalizationVector[i] = 0.5, 2
+		if i < len(bounds) {
+			if !(bounds[i] > 0) {
+				return nil, fmt.Errorf("bound %v of slot %d is not positive", bounds[i], i)
+			}
+			// The difference of two values of [-bounds[i], bounds[i]] lies in [-1, 1] once divided by 2*bounds[i]
+			normalizationVector[i], reverseNormalizationVector[i] = 1/(2*bounds[i]), 2*bounds[i]
+			if math.IsInf(bounds[i], 1) {
+				normalizationVector[i], reverseNormalizationVector[i] = 0, 0
+			}
+		}
+	}
+
+	// Normalize each feature of every client's inputs
+	normalized := make([]*rlwe.Ciphertext, len(cts))
+	for i := range normalized {
+		if err := checkCiphertext(params, cts[i]); err != nil {
+			return nil, &PartyError{Party: i, Err: err}
+		}
+
+		var err error
+		if normalized[i], err = eval.MulRelinNew(cts[i], normalizationVector); err != nil {
+			return nil, err
+		}
+		if err = eval.Rescale(normalized[i], normalized[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	// Comparing the running extremum with the input of each client
+	var extremum *rlwe.Ciphertext
+	for i := range normalized {
+		var err error
+		switch {
+		case i == 0:
+			extremum = normalized[i].CopyNew()
+		case max:
+			extremum, err = cmpEval.Max(extremum, normalized[i])
+		default:
+			extremum, err = cmpEval.Min(extremum, normalized[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if extremum, err = btp.Bootstrap(extremum); err != nil {
+			return nil, err
+		}
+	}
+
+	// Renormalizing the extremum
+	result, err := eval.MulRelinNew(extremum, reverseNormalizationVector)
+	if err != nil {
+		return nil, err
+	}
+	if err = eval.Rescale(result, result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
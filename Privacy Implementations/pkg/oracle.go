@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"math"
+	"sort"
+)
+
+// Plaintext reference of the statistics the protocols compute, over the pooled data of the parties. It needs the
+// parties' data, so only the simulation mode of the commands and the tests can use it.
+
+// PooledSamples returns the samples of every feature of all the parties, sorted. These are the values of the robust
+// search, which are the samples of the dataset of each party (not set for the generated minmax parties).
+func PooledSamples(parties []*Party) [][]float64 {
+	var pooled [][]float64
+	for _, pi := range parties {
+		for j, samples := range pi.RobustScalingInput {
+			if j >= len(pooled) {
+				pooled = append(pooled, nil)
+			}
+			pooled[j] = append(pooled[j], samples...)
+		}
+	}
+	for j := range pooled {
+		sort.Float64s(pooled[j])
+	}
+	return pooled
+}
+
+// PooledMean returns the mean of every feature over the samples of all the parties
+func PooledMean(parties []*Party) []float64 {
+	pooled := PooledSamples(parties)
+	mean := make([]float64, len(pooled))
+	for j, samples := range pooled {
+		mean[j] = meanOf(samples)
+	}
+	return mean
+}
+
+// PooledVariance returns the population variance of every feature over the samples of all the parties, as the
+// StandardScaler of Experiments/norms.py
+func PooledVariance(parties []*Party) []float64 {
+	pooled := PooledSamples(parties)
+	variance := make([]float64, len(pooled))
+	for j, samples := range pooled {
+		mean := meanOf(samples)
+		for _, x := range samples {
+			variance[j] += (x - mean) * (x - mean)
+		}
+		variance[j] /= float64(len(samples))
+	}
+	return variance
+}
+
+// PooledMin returns the minimum of every slot of the parties' MinValues, which are the minima of the features of
+// their datasets
+func PooledMin(parties []*Party) []float64 {
+	return pooledExtremum(parties, func(pi *Party) []float64 { return pi.MinValues }, math.Min)
+}
+
+// PooledMax returns the maximum of every slot of the parties' MaxValues, which are the maxima of the features of
+// their datasets
+func PooledMax(parties []*Party) []float64 {
+	return pooledExtremum(parties, func(pi *Party) []float64 { return pi.MaxValues }, math.Max)
+}
+
+// PooledPercentile returns the percentile of every feature over the samples of all the parties, with the linear
+// interpolation of numpy.percentile
+func PooledPercentile(parties []*Party, percentile float64) []float64 {
+	pooled := PooledSamples(parties)
+	values := make([]float64, len(pooled))
+	for j, samples := range pooled {
+		values[j] = Percentile(samples, percentile)
+	}
+	return values
+}
+
+// Percentile of sorted values with the linear interpolation of numpy.percentile
+func Percentile(sorted []float64, percentile float64) float64 {
+	if len(sorted) == 0 {
+		return math.NaN()
+	}
+
+	pos := percentile / 100.0 * float64(len(sorted)-1)
+	lo := int(math.Floor(pos))
+	if lo+1 >= len(sorted) {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lo] + (pos-float64(lo))*(sorted[lo+1]-sorted[lo])
+}
+
+func pooledExtremum(parties []*Party, values func(pi *Party) []float64, pick func(a, b float64) float64) []float64 {
+	if len(parties) == 0 {
+		return nil
+	}
+	result := append([]float64{}, values(parties[0])...)
+	for _, pi := range parties[1:] {
+		for j, v := range values(pi) {
+			result[j] = pick(result[j], v)
+		}
+	}
+	return result
+}
+
+func meanOf(samples []float64) (mean float64) {
+	for _, x := range samples {
+		mean += x
+	}
+	return mean / float64(len(samples))
+}
@@ -1,6 +1,9 @@
 package pkg
 
 import (
+	"crypto/ecdh"
+	"fmt"
+	"math"
 	"math/rand"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
@@ -11,9 +14,13 @@ import (
 
 type Party struct {
 	mpckks.RefreshProtocol
+	ID         int
 	Sk         *rlwe.SecretKey
 	rlkEphemSk *rlwe.SecretKey
 
+	// Collective public key, known to the party after the CKG phase
+	pk *rlwe.PublicKey
+
 	ckgShare     multiparty.PublicKeyGenShare
 	gkgShare    multiparty.GaloisKeyGenShare
 	rkgShareOne  multiparty.RelinearizationKeyGenShare
@@ -22,6 +29,12 @@ type Party struct {
 	pcksShare    multiparty.PublicKeySwitchShare
 	refreshShare multiparty.RefreshShare
 
+	// Threshold share of the collective secret key, set by the threshold setup
+	exchangeKey    *ecdh.PrivateKey
+	exchangeKeys   []ExchangeKey
+	threshold      int
+	thresholdShare *multiparty.ShamirSecretShare
+
 	Input           []float64
 	NumberOfSamples []float64
 	TempVarianceSum []float64
@@ -33,110 +46,463 @@ type Party struct {
 	RobustScalingLCount []float64
 	RobustScalingRCount []float64
 
+	// Local dataset of the party, nil for the generated parties
+	Data *Dataset
+}
+
+// Creates the parties and their secret keys from their local datasets, see NewParty
+func NewParties(params ckks.Parameters, datasets []*Dataset) ([]*Party, error) {
+	parties := make([]*Party, len(datasets))
+	for i, data := range datasets {
+		var err error
+		if parties[i], err = NewParty(params, i, data); err != nil {
+			return nil, err
+		}
+	}
+	return parties, nil
+}
+
+// Creates the i-th party and its secret key from its local dataset. The local sums, counts, minima, maxima
+// and samples of feature j fill slot j of the inputs of the z score, minmax and robust computations.
+func NewParty(params ckks.Parameters, i int, data *Dataset) (*Party, error) {
+	if len(data.Features) > params.MaxSlots() {
+		return nil, &PartyError{Party: i, Err: fmt.Errorf("%w: %d features for %d slots", ErrEncodingOverflow, len(data.Features), params.MaxSlots())}
+	}
+
+	// Every party contributes to the min and max of every feature, so it needs at least one value
+	for j, samples := range data.Samples {
+		if len(samples) == 0 {
+			return nil, &PartyError{Party: i, Err: fmt.Errorf("feature %q has no values", data.Features[j])}
+		}
+	}
+
+	return newParty(params, i, data), nil
+}
+
+func newParty(params ckks.Parameters, i int, data *Dataset) *Party {
+	NFeatures := len(data.Features)
+
+	kgen := rlwe.NewKeyGenerator(params)
+
+	pi := &Party{ID: i, Data: data}
+	pi.Sk = kgen.GenSecretKeyNew() // Generate secret key for each party
+
+	pi.Input = make([]float64, params.MaxSlots())
+	pi.NumberOfSamples = make([]float64, params.MaxSlots())
+	pi.MinValues = make([]float64, params.MaxSlots())
+	pi.MaxValues = make([]float64, params.MaxSlots())
+
+	pi.RobustScalingInput = make([][]float64, NFeatures)
+	pi.RobustScalingNSamples = make([]float64, NFeatures)
+
+	for j, samples := range data.Samples {
+		pi.MinValues[j], pi.MaxValues[j] = samples[0], samples[0]
+		for _, x := range samples {
+			pi.Input[j] += x
+			pi.MinValues[j] = math.Min(pi.MinValues[j], x)
+			pi.MaxValues[j] = math.Max(pi.MaxValues[j], x)
+		}
+		pi.NumberOfSamples[j] = float64(len(samples))
+
+		pi.RobustScalingInput[j] = samples
+		pi.RobustScalingNSamples[j] = float64(len(samples))
+	}
+
+	// The slots past the features count one sample of value 0, so that the inverse of the total count stays
+	// in the domain of the inverse evaluator in every slot
+	for j := NFeatures; j < params.MaxSlots(); j++ {
+		pi.NumberOfSamples[j] = 1
+	}
+
+	return pi
 }
 
 // Generates parties and their secret keys for z score computation
 func GenZscoreParties(params ckks.Parameters, N int) []*Party {
-	kgen := rlwe.NewKeyGenerator(params)
 	parties := make([]*Party, N)
-
 	for i := 0; i < N; i++ {
-		pi := &Party{}
-		pi.Sk = kgen.GenSecretKeyNew() // Generate secret key for each party
+		parties[i] = GenZscoreParty(params, i)
+	}
+	return parties
+}
 
+// Generates the i-th party and its secret key for z score computation
+func GenZscoreParty(params ckks.Parameters, i int) *Party {
 
-		pi.Input = make([]float64, params.MaxSlots())
-		for j := range pi.Input {
-			if j < 4 {
-				pi.Input[j] = (10000.0 * float64(i+1)) - (float64(j) * 500.0) // Each party holds a float64 value for Input (e.g., 10.0, 20.0) for all slots
-			} else{
-				pi.Input[j] = 10000.0 * float64(i+1) // Each party holds a float64 value for Input (e.g., 10.0, 20.0) for all slots
-			}
-		}
+	NFeatures := 4
 
-		pi.NumberOfSamples = make([]float64, params.MaxSlots())
-		for j := range pi.NumberOfSamples {
-			if j < 4 {
-				pi.NumberOfSamples[j] = (200.0 * float64(i+1)) + (float64(j) * 50.0)  // Each party holds a float64 value NumberOfSamples (e.g., 2.0, 4.0) for all slots
-			} else{
-				pi.NumberOfSamples[j] = 200.0 * float64(i+1) // Each party holds a float64 value NumberOfSamples (e.g., 2.0, 4.0) for all slots
-			}
-			
+	data := &Dataset{
+		Features: make([]string, NFeatures),
+		Samples:  make([][]float64, NFeatures),
+		Missing:  make([]int, NFeatures),
+	}
+
+	for j := 0; j < NFeatures; j++ {
+		data.Features[j] = fmt.Sprintf("feature%d", j)
+
+		// Party i holds 200*(i+1) + 50*j samples of feature j, normally distributed around 50 - 5*j with a standard deviation of j+1
+		length := 200*(i+1) + 50*j
+		data.Samples[j] = make([]float64, length)
+		for k := range data.Samples[j] {
+			data.Samples[j][k] = 50.0 - 5.0*float64(j) + rand.NormFloat64()*float64(j+1)
 		}
+	}
 
-		parties[i] = pi
+	return newParty(params, i, data)
+}
+
+// Generates parties and their secret keys for power transform computation
+func GenPowerParties(params ckks.Parameters, N int) []*Party {
+	parties := make([]*Party, N)
+	for i := 0; i < N; i++ {
+		parties[i] = GenPowerParty(params, i)
 	}
 	return parties
 }
 
+// Generates the i-th party and its secret key for power transform computation
+func GenPowerParty(params ckks.Parameters, i int) *Party {
+
+	NFeatures := 4
+
+	data := &Dataset{
+		Features: make([]string, NFeatures),
+		Samples:  make([][]float64, NFeatures),
+		Missing:  make([]int, NFeatures),
+	}
+
+	for j := 0; j < NFeatures; j++ {
+		data.Features[j] = fmt.Sprintf("feature%d", j)
+
+		// Party i holds 200*(i+1) samples of feature j, log-normally distributed with a skewness growing with j
+		length := 200 * (i + 1)
+		data.Samples[j] = make([]float64, length)
+		for k := range data.Samples[j] {
+			data.Samples[j][k] = math.Exp(2 + rand.NormFloat64()*0.25*float64(j+1))
+		}
+	}
+
+	return newParty(params, i, data)
+}
+
 // Generates parties and their secret keys for minmax computation
 func GenMinMaxParties(params ckks.Parameters, N int) []*Party {
-	kgen := rlwe.NewKeyGenerator(params)
 	parties := make([]*Party, N)
+	for i := 0; i < N; i++ {
+		parties[i] = GenMinMaxParty(params, i)
+	}
+	return parties
+}
+
+// Generates the i-th party and its secret key for minmax computation
+func GenMinMaxParty(params ckks.Parameters, i int) *Party {
+	kgen := rlwe.NewKeyGenerator(params)
 
 	min1, max1 := -99.0, 99.0
 	min2, max2 := -999.0, 999.0
-    
-	for i := 0; i < N; i++ {
-		pi := &Party{}
 
-		pi.Sk = kgen.GenSecretKeyNew() // Generate secret key for each party
+	pi := &Party{ID: i}
 
-		pi.MinValues = make([]float64, params.MaxSlots())
-		for j := range pi.MinValues {
+	pi.Sk = kgen.GenSecretKeyNew() // Generate secret key for each party
 
-			if j % 2 == 0 {
-				pi.MinValues[j] = min2 + rand.Float64()*(max2-min2)
-			} else{
-				pi.MinValues[j] = min1 + rand.Float64()*(max1-min1)
-			}
+	pi.MinValues = make([]float64, params.MaxSlots())
+	for j := range pi.MinValues {
 
+		if j % 2 == 0 {
+			pi.MinValues[j] = min2 + rand.Float64()*(max2-min2)
+		} else{
+			pi.MinValues[j] = min1 + rand.Float64()*(max1-min1)
 		}
 
-		pi.MaxValues = make([]float64, params.MaxSlots())
-		for j := range pi.MaxValues {
-			if j == 0{
-				pi.MaxValues[j] = 0.1001 * float64(i)
-			}else if j % 2 == 0 {
-				pi.MaxValues[j] = min2 + rand.Float64()*(max2-min2)
-			} else{
-				pi.MaxValues[j] = min1 + rand.Float64()*(max1-min1)
-			}
-		}
+	}
 
-		parties[i] = pi
+	pi.MaxValues = make([]float64, params.MaxSlots())
+	for j := range pi.MaxValues {
+		if j == 0{
+			pi.MaxValues[j] = 0.1001 * float64(i)
+		}else if j % 2 == 0 {
+			pi.MaxValues[j] = min2 + rand.Float64()*(max2-min2)
+		} else{
+			pi.MaxValues[j] = min1 + rand.Float64()*(max1-min1)
+		}
 	}
-	return parties
+
+	return pi
 }
 
 // Generates parties and their secret keys for k-th element (robust scaling) computation
 func GenRobustParties(params ckks.Parameters, N int, NFeatures int) []*Party {
-	kgen := rlwe.NewKeyGenerator(params)
 	parties := make([]*Party, N)
+	for i := 0; i < N; i++ {
+		parties[i] = GenRobustParty(params, i, NFeatures)
+	}
+	return parties
+}
+
+// Generates the i-th party and its secret key for k-th element (robust scaling) computation
+func GenRobustParty(params ckks.Parameters, i int, NFeatures int) *Party {
+	kgen := rlwe.NewKeyGenerator(params)
 
 	maxLength := 4
 
 	min1, max1 := -2.0, 2.0
-    
-	for i := 0; i < N; i++ {
-		pi := &Party{}
-		pi.Sk = kgen.GenSecretKeyNew() // Generate secret key for each party
 
-		pi.RobustScalingInput = make([][]float64, NFeatures)
-		pi.RobustScalingNSamples = make([]float64, NFeatures)
-		for j := 0; j < NFeatures; j++ {
-			length := rand.Intn(maxLength) + 1
-			array := make([]float64, length)
+	pi := &Party{ID: i}
+	pi.Sk = kgen.GenSecretKeyNew() // Generate secret key for each party
+
+	pi.RobustScalingInput = make([][]float64, NFeatures)
+	pi.RobustScalingNSamples = make([]float64, NFeatures)
+	pi.MinValues = make([]float64, params.MaxSlots())
+	pi.MaxValues = make([]float64, params.MaxSlots())
+	for j := 0; j < NFeatures; j++ {
+		length := rand.Intn(maxLength) + 1
+		array := make([]float64, length)
+
+		for k := 0; k < length; k++ {
+			array[k] = min1 + rand.Float64()*(max1-min1)
+		}
+
+		pi.RobustScalingInput[j] = array
+		pi.RobustScalingNSamples[j] = float64(int64(length))
+
+		// The min and max bound the search of the k-th element
+		pi.MinValues[j], pi.MaxValues[j] = array[0], array[0]
+		for _, x := range array {
+			pi.MinValues[j] = math.Min(pi.MinValues[j], x)
+			pi.MaxValues[j] = math.Max(pi.MaxValues[j], x)
+		}
+	}
+
+	return pi
+}
+
+// Answer runs the party side computation named by the query and returns the vector the party encrypts and uploads.
+// The arguments are not checked against the protocol of the command, the party trusts the aggregator to choose them:
+// an interval of QueryRobustIntervalSum that holds a single value of the party reveals it once aggregated.
+func (p *Party) Answer(q Query) ([]float64, error) {
+	switch q.Name {
+	case QueryZscoreSum:
+		if len(q.Args) == 0 {
+			return p.Input, nil
+		}
+		return p.shiftedSums(q.Args)
+	case QueryZscoreCount:
+		return p.NumberOfSamples, nil
+	case QueryZscorePartialSum:
+		if err := p.clientSidePartialSums(q.Args); err != nil {
+			return nil, err
+		}
+		return p.TempVarianceSum, nil
+	case QueryZscoreSumSquares:
+		return p.sumSquares(q.Args)
+	case QueryZscoreSamples:
+		return p.packedSamples(q.Args)
+	case QueryMin:
+		return p.MinValues, nil
+	case QueryMax:
+		return p.MaxValues, nil
+	case QueryRobustCount:
+		return p.RobustScalingNSamples, nil
+	case QueryRobustLeft, QueryRobustRight:
+		if len(q.Args) < len(p.RobustScalingInput) || (len(p.RobustScalingInput) > 0 && len(q.Args)%len(p.RobustScalingInput) != 0) {
+			return nil, fmt.Errorf("%w: %s with %d midpoints for %d features", ErrInvalidQuery, q.Name, len(q.Args), len(p.RobustScalingInput))
+		}
+		p.calculateCounts(q.Args)
+		if q.Name == QueryRobustLeft {
+			return p.RobustScalingLCount, nil
+		}
+		return p.RobustScalingRCount, nil
+	case QueryRobustInterval, QueryRobustIntervalSum:
+		return p.intervalSums(q.Args, q.Name == QueryRobustIntervalSum)
+	case QueryPacked:
+		return p.packed(q.Args)
+	case QueryRobustHistogram:
+		return p.histogram(q.Args)
+	case QueryPowerLogSum:
+		return p.powerLogSums(q.Args)
+	case QueryPowerSum, QueryPowerSumSquares:
+		return p.powerSums(q.Args, q.Name == QueryPowerSumSquares)
+	case QueryPowerCount:
+		return p.powerCounts(q.Args)
+	case QueryLogSum:
+		return p.logSums(q.Args, nil)
+	case QueryLogPartialSum:
+		if len(q.Args)%2 != 0 {
+			return nil, fmt.Errorf("%w: %s with %d arguments", ErrInvalidQuery, q.Name, len(q.Args))
+		}
+		return p.logSums(q.Args[:len(q.Args)/2], q.Args[len(q.Args)/2:])
+	default:
+		return nil, fmt.Errorf("%w: unknown query %q", ErrInvalidQuery, q.Name)
+	}
+}
 
-			for k := 0; k < length; k++ {
-				array[k] = min1 + rand.Float64()*(max1-min1)
+// Each client calculates the sum of (Xi - mean)^2 for each feature
+// Later, these partial sums are summed and divided by the total number of data points to calculate variance
+func (p *Party) clientSidePartialSums(mean []float64) error {
+	if p.Data == nil {
+		return fmt.Errorf("%w: the party holds no samples", ErrInvalidQuery)
+	}
+	if len(mean) < len(p.Data.Samples) {
+		return fmt.Errorf("%w: %d means for %d features", ErrInvalidQuery, len(mean), len(p.Data.Samples))
+	}
+
+	p.TempVarianceSum = make([]float64, len(mean))
+	for j, samples := range p.Data.Samples {
+		for _, x := range samples {
+			p.TempVarianceSum[j] += (x - mean[j]) * (x - mean[j])
+		}
+	}
+	return nil
+}
+
+// Sum of (Xi - shift) for each feature, the shift is a public constant per feature that brings the values close to 0
+// so that the sums of squares of large magnitude features do not lose precision
+func (p *Party) shiftedSums(shift []float64) ([]float64, error) {
+	if p.Data == nil {
+		return nil, fmt.Errorf("%w: the party holds no samples", ErrInvalidQuery)
+	}
+	if len(shift) < len(p.Data.Samples) {
+		return nil, fmt.Errorf("%w: %d shifts for %d features", ErrInvalidQuery, len(shift), len(p.Data.Samples))
+	}
+
+	sums := make([]float64, len(p.Input))
+	for j := range sums {
+		sums[j] = p.Input[j]
+		if j < len(shift) {
+			sums[j] -= p.NumberOfSamples[j] * shift[j]
+		}
+	}
+	return sums, nil
+}
+
+// Sum of (Xi - shift)^2 for each feature, with no shift if shift is empty
+func (p *Party) sumSquares(shift []float64) ([]float64, error) {
+	if p.Data == nil {
+		return nil, fmt.Errorf("%w: the party holds no samples", ErrInvalidQuery)
+	}
+	if len(shift) > 0 && len(shift) < len(p.Data.Samples) {
+		return nil, fmt.Errorf("%w: %d shifts for %d features", ErrInvalidQuery, len(shift), len(p.Data.Samples))
+	}
+
+	sums := make([]float64, len(p.Input))
+	for j, samples := range p.Data.Samples {
+		s := 0.0
+		if len(shift) > 0 {
+			s = shift[j]
+		}
+		for _, x := range samples {
+			sums[j] += (x - s) * (x - s)
+		}
+	}
+	return sums, nil
+}
+
+// Number (or sum) of the elements in the interval (lo, hi] for every feature, args holds the lower bounds and then the upper bounds.
+// As for calculateCounts, several intervals per feature can be given: interval r is an interval of feature r % NFeatures
+func (p *Party) intervalSums(args []float64, sums bool) ([]float64, error) {
+	NFeatures := len(p.RobustScalingInput)
+	if NFeatures == 0 || len(args)%(2*NFeatures) != 0 {
+		return nil, fmt.Errorf("%w: %d interval bounds for %d features", ErrInvalidQuery, len(args), NFeatures)
+	}
+	lo, hi := args[:len(args)/2], args[len(args)/2:]
+
+	values := make([]float64, len(lo))
+	for r := range lo {
+		for _, val := range p.RobustScalingInput[r%NFeatures] {
+			if lo[r] < val && val <= hi[r] {
+				if sums {
+					values[r] += val
+				} else {
+					values[r]++
+				}
 			}
+		}
+	}
+	return values, nil
+}
 
-			pi.RobustScalingInput[j] = array
-			pi.RobustScalingNSamples[j] = float64(int64(length))
+// Histograms of B buckets over the intervals [lo, hi), args holds B, the lower bounds and then the upper bounds.
+// Slot r*B + b counts the elements of feature r % NFeatures in the b-th bucket of the r-th interval, the elements out of the interval are not counted
+func (p *Party) histogram(args []float64) ([]float64, error) {
+	NFeatures := len(p.RobustScalingInput)
+	if len(args) < 1 || args[0] < 1 || NFeatures == 0 || (len(args)-1)%(2*NFeatures) != 0 {
+		return nil, fmt.Errorf("%w: malformed histogram of %d arguments for %d features", ErrInvalidQuery, len(args), NFeatures)
+	}
+	lo, hi := args[1:1+(len(args)-1)/2], args[1+(len(args)-1)/2:]
+
+	// The counts of every range fill B slots of one ciphertext
+	slots := len(p.MinValues)
+	if !(args[0] <= float64(slots/len(lo))) {
+		return nil, fmt.Errorf("%w: histogram of %v buckets for %d ranges and %d slots", ErrInvalidQuery, args[0], len(lo), slots)
+	}
+	B := int(args[0])
+
+	for r := range lo {
+		if math.IsInf(lo[r], 0) || math.IsInf(hi[r], 0) || !(lo[r] < hi[r]) {
+			return nil, fmt.Errorf("%w: histogram range [%v, %v)", ErrInvalidQuery, lo[r], hi[r])
 		}
+	}
 
-		parties[i] = pi
+	counts := make([]float64, len(lo)*B)
+	for r := range lo {
+		width := (hi[r] - lo[r]) / float64(B)
+		for _, val := range p.RobustScalingInput[r%NFeatures] {
+			if val < lo[r] || val >= hi[r] {
+				continue
+			}
+			b := int((val - lo[r]) / width)
+			if b >= B {
+				b = B - 1
+			}
+			counts[r*B+b]++
+		}
 	}
-	return parties
-}
\ No newline at end of file
+	return counts, nil
+}
+
+// Sum of log10(Xi + shift) for each feature, or of (log10(Xi + shift) - mean)^2 when the mean is given.
+// The shift is a public constant per feature that makes every value positive.
+func (p *Party) logSums(shift []float64, mean []float64) ([]float64, error) {
+	if p.Data == nil {
+		return nil, fmt.Errorf("%w: the party holds no samples", ErrInvalidQuery)
+	}
+	if len(shift) < len(p.Data.Samples) {
+		return nil, fmt.Errorf("%w: %d shifts for %d features", ErrInvalidQuery, len(shift), len(p.Data.Samples))
+	}
+
+	sums := make([]float64, len(p.Input))
+	for j, samples := range p.Data.Samples {
+		for _, x := range samples {
+			if x+shift[j] <= 0 {
+				return nil, fmt.Errorf("%w: shift %v of feature %q leaves the value %v non positive", ErrInvalidQuery, shift[j], p.Data.Features[j], x)
+			}
+			y := math.Log10(x + shift[j])
+			if mean != nil {
+				y = (y - mean[j]) * (y - mean[j])
+			}
+			sums[j] += y
+		}
+	}
+	return sums, nil
+}
+
+// Count elements smaller and greater than midpoint m for every feature (individual calculation for the party, not summed yet)
+// Several ranks can be searched in the same round, m holds one midpoint per feature for each of them: m[t*NFeatures + i] is a midpoint of feature i
+func (p *Party) calculateCounts(m []float64) {
+
+	NFeatures := len(p.RobustScalingInput)
+
+	// Reseting the RobustScalingLCount and RobustScalingRCount for the new round
+	p.RobustScalingLCount = make([]float64, len(m))
+	p.RobustScalingRCount = make([]float64, len(m))
+
+	for s := 0; s < len(m) && NFeatures > 0; s++ {
+		for _, val := range p.RobustScalingInput[s%NFeatures] {
+			if val < m[s] {
+				p.RobustScalingLCount[s]++
+			} else if val > m[s] {
+				p.RobustScalingRCount[s]++
+			}
+		}
+	}
+}
@@ -0,0 +1,60 @@
+package pkg
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+func TestHistogramQuery(t *testing.T) {
+	params, err := ckks.NewParametersFromLiteral(ParametersLiteralLogN(12))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The generated values of the 2 features are in [-2, 2]
+	p := GenRobustParty(params, 0, 2)
+	histogram := func(B float64, lo, hi []float64) ([]float64, error) {
+		return p.Answer(Query{Name: QueryRobustHistogram, Args: append(append([]float64{B}, lo...), hi...)})
+	}
+
+	counts, err := histogram(4, []float64{-3, -3}, []float64{3, 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for j, n := range p.RobustScalingNSamples {
+		var sum float64
+		for _, c := range counts[4*j : 4*(j+1)] {
+			sum += c
+		}
+		if sum != n {
+			t.Errorf("feature %d: %v values in the buckets, want %v", j, sum, n)
+		}
+	}
+
+	// The 2 ranges of B buckets fill at most the slots of one ciphertext
+	B := float64(params.MaxSlots() / 2)
+	if _, err = histogram(B, []float64{-3, -3}, []float64{3, 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		B      float64
+		lo, hi float64
+	}{
+		{"too many buckets", B + 1, -3, 3},
+		{"no bucket", 0, -3, 3},
+		{"NaN buckets", math.NaN(), -3, 3},
+		{"empty range", 4, 3, 3},
+		{"reversed range", 4, 3, -3},
+		{"infinite bound", 4, math.Inf(-1), 3},
+		{"NaN bound", 4, -3, math.NaN()},
+	} {
+		if _, err = histogram(tc.B, []float64{-3, tc.lo}, []float64{3, tc.hi}); !errors.Is(err, ErrInvalidQuery) {
+			t.Errorf("%s: %v, want %v", tc.name, err, ErrInvalidQuery)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Package pkgtest holds the fixtures and the precision checks shared by the tests of the normalization commands
+package pkgtest
+
+import (
+	"encryption/pkg"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Params returns the CKKS parameters of ring degree 2^logN, those under 2^15 are under 128 bit security
+func Params(tb testing.TB, logN int) ckks.Parameters {
+	tb.Helper()
+	params, err := ckks.NewParametersFromLiteral(pkg.ParametersLiteralLogN(logN))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return params
+}
+
+// SkipLong skips a case of the whole protocol under -short, unless it is the small case of 2 parties in ring degree 2^12
+// that each command keeps under -short
+func SkipLong(tb testing.TB, small bool) {
+	tb.Helper()
+	if testing.Short() && !small {
+		tb.Skip("runs the whole protocol, only the small case runs under -short")
+	}
+}
+
+// NewSession returns the session of the parties over a local cohort, once the collective keys and the Galois keys of
+// galEls are generated
+func NewSession(tb testing.TB, params ckks.Parameters, parties []*pkg.Party, galEls ...uint64) *pkg.Session {
+	tb.Helper()
+	session, err := pkg.NewSession(params, pkg.NewLocalCohort(params, parties), pkg.DefaultCRSSeed)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	if err = session.Setup(galEls...); err != nil {
+		tb.Fatal(err)
+	}
+	return session
+}
+
+// CheckAbsolute reports an error if a value is not finite or further than the tolerance from its reference, the
+// precision of the statistic is logged
+func CheckAbsolute(tb testing.TB, name string, values, expected []float64, tolerance float64) {
+	tb.Helper()
+	p := pkg.ComparePrecision(name, values, expected)
+	checkPrecision(tb, p, p.MaxAbsError, tolerance)
+}
+
+// CheckRelative reports an error if a value is not finite or its relative error to its reference is above the
+// tolerance, the precision of the statistic is logged
+func CheckRelative(tb testing.TB, name string, values, expected []float64, tolerance float64) {
+	tb.Helper()
+	p := pkg.ComparePrecision(name, values, expected)
+	checkPrecision(tb, p, p.MaxRelError, tolerance)
+}
+
+func checkPrecision(tb testing.TB, p pkg.StatPrecision, maxError, tolerance float64) {
+	tb.Helper()
+	if p.NonFinite > 0 || maxError > tolerance {
+		tb.Errorf("%s, tolerance %.1e", p, tolerance)
+	}
+	tb.Log(p)
+}
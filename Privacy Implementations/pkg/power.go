@@ -0,0 +1,245 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+)
+
+// Power transforms of the power_transform command, as in sklearn's PowerTransformer
+const (
+	PowerYeoJohnson = 0
+	PowerBoxCox     = 1
+)
+
+// PowerMethod returns the power transform named by method, "yeo-johnson" or "box-cox"
+func PowerMethod(method string) (int, error) {
+	switch method {
+	case "yeo-johnson":
+		return PowerYeoJohnson, nil
+	case "box-cox":
+		return PowerBoxCox, nil
+	default:
+		return 0, fmt.Errorf("unknown power transform %q", method)
+	}
+}
+
+// PowerTransform returns the Yeo-Johnson or Box-Cox transform of x with parameter lambda.
+// (y^lambda - 1) / lambda is computed as expm1(lambda * log(y)) / lambda, which stays precise for lambda close to 0.
+func PowerTransform(method int, lambda, x float64) float64 {
+	if method == PowerBoxCox {
+		if lambda == 0 {
+			return math.Log(x)
+		}
+		return math.Expm1(lambda*math.Log(x)) / lambda
+	}
+
+	if x >= 0 {
+		if lambda == 0 {
+			return math.Log1p(x)
+		}
+		return math.Expm1(lambda*math.Log1p(x)) / lambda
+	}
+	if lambda == 2 {
+		return -math.Log1p(-x)
+	}
+	return -math.Expm1((2-lambda)*math.Log1p(-x)) / (2 - lambda)
+}
+
+// Derivative of the transform in x
+func powerTransformDerivative(method int, lambda, x float64) float64 {
+	switch {
+	case method == PowerBoxCox:
+		return math.Pow(x, lambda-1)
+	case x >= 0:
+		return math.Pow(x+1, lambda-1)
+	default:
+		return math.Pow(1-x, 1-lambda)
+	}
+}
+
+// PowerLogTerm returns the term of x of the log-likelihood that does not depend on the variance: the log-likelihood of lambda over n samples is
+// -n/2 * log(var(transformed x)) + (lambda - 1) * sum(PowerLogTerm(x))
+func PowerLogTerm(method int, x float64) float64 {
+	if method == PowerBoxCox {
+		return math.Log(x)
+	}
+	if x >= 0 {
+		return math.Log1p(x)
+	}
+	return -math.Log1p(-x)
+}
+
+// PowerGrid is the public grid of lambdas of one round of the power transform protocol, the aggregator sends it as
+// the arguments of the power queries. Lambdas[j][g] fills slot j*G+g, G being the number of lambdas per feature.
+//
+// The transformed values are sent normalized, as (psi(x) - psi(Center[j])) / (psi'(Center[j]) * Scale[j]) with psi the
+// transform with lambda, so that they stay close to the standardized values (x - Center[j]) / Scale[j] whatever lambda:
+// the variance of the raw transformed values can be too small or too large for the precision of the scheme.
+type PowerGrid struct {
+	Method  int
+	Lambdas [][]float64
+	Center  []float64
+	Scale   []float64
+}
+
+// NewPowerGrid creates a grid of G lambdas evenly spaced between lo[j] and hi[j] for each feature j
+func NewPowerGrid(method int, lo, hi []float64, G int, center, scale []float64) *PowerGrid {
+	lambdas := make([][]float64, len(lo))
+	for j := range lambdas {
+		lambdas[j] = make([]float64, G)
+		for g := range lambdas[j] {
+			lambdas[j][g] = lo[j] + float64(g)*(hi[j]-lo[j])/float64(G-1)
+		}
+	}
+	return &PowerGrid{Method: method, Lambdas: lambdas, Center: center, Scale: scale}
+}
+
+// Size returns the number of lambdas per feature
+func (pg *PowerGrid) Size() int {
+	if len(pg.Lambdas) == 0 {
+		return 0
+	}
+	return len(pg.Lambdas[0])
+}
+
+// Args encodes the grid as query arguments: method, number of features F, G, the F*G lambdas, the F centers and the F scales
+func (pg *PowerGrid) Args() []float64 {
+	F, G := len(pg.Lambdas), pg.Size()
+
+	args := []float64{float64(pg.Method), float64(F), float64(G)}
+	for j := range pg.Lambdas {
+		args = append(args, pg.Lambdas[j]...)
+	}
+	args = append(args, pg.Center...)
+	return append(args, pg.Scale...)
+}
+
+func parsePowerGrid(args []float64) (*PowerGrid, error) {
+	if len(args) < 3 {
+		return nil, fmt.Errorf("%w: power grid of %d arguments", ErrInvalidQuery, len(args))
+	}
+
+	method, F, G := int(args[0]), int(args[1]), int(args[2])
+	if (method != PowerYeoJohnson && method != PowerBoxCox) || F < 0 || G < 1 || len(args) != 3+F*G+2*F {
+		return nil, fmt.Errorf("%w: malformed power grid", ErrInvalidQuery)
+	}
+
+	pg := &PowerGrid{Method: method, Lambdas: make([][]float64, F)}
+	args = args[3:]
+	for j := range pg.Lambdas {
+		pg.Lambdas[j], args = args[:G], args[G:]
+	}
+	pg.Center, pg.Scale = args[:F], args[F:]
+	return pg, nil
+}
+
+// Normalization returns the shift and the factor of the transformed values of feature j with the g-th lambda
+func (pg *PowerGrid) Normalization(j, g int) (shift, factor float64) {
+	lambda, c := pg.Lambdas[j][g], pg.Center[j]
+	return PowerTransform(pg.Method, lambda, c), 1 / (powerTransformDerivative(pg.Method, lambda, c) * pg.Scale[j])
+}
+
+// LogLikelihood returns the log-likelihood per sample of the g-th lambda of feature j, from the variance of the normalized
+// transformed values and the mean of the log terms of the feature
+func (pg *PowerGrid) LogLikelihood(j, g int, variance, meanLogTerm float64) float64 {
+	if !(variance > 0) {
+		return math.Inf(-1)
+	}
+	_, factor := pg.Normalization(j, g)
+	return -0.5*math.Log(variance/(factor*factor)) + (pg.Lambdas[j][g]-1)*meanLogTerm
+}
+
+// Checks that the party's data fits the grid and the domain of the transform
+func (p *Party) checkPowerData(method, F, size int) error {
+	if p.Data == nil {
+		return fmt.Errorf("%w: the party holds no samples", ErrInvalidQuery)
+	}
+	if F != len(p.Data.Samples) {
+		return fmt.Errorf("%w: power query for %d features, the party holds %d", ErrInvalidQuery, F, len(p.Data.Samples))
+	}
+	if size > len(p.Input) {
+		return fmt.Errorf("%w: %d values for %d slots", ErrEncodingOverflow, size, len(p.Input))
+	}
+	if method == PowerBoxCox {
+		for j, samples := range p.Data.Samples {
+			for _, x := range samples {
+				if x <= 0 {
+					return fmt.Errorf("%w: the Box-Cox transform needs positive values, feature %q holds %v", ErrInvalidQuery, p.Data.Features[j], x)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Sum of the normalized transformed values (or of their squares) for each feature and lambda of the grid
+func (p *Party) powerSums(args []float64, squares bool) ([]float64, error) {
+	pg, err := parsePowerGrid(args)
+	if err != nil {
+		return nil, err
+	}
+	G := pg.Size()
+	if err = p.checkPowerData(pg.Method, len(pg.Lambdas), len(pg.Lambdas)*G); err != nil {
+		return nil, err
+	}
+
+	sums := make([]float64, len(p.Input))
+	for j, samples := range p.Data.Samples {
+		for g, lambda := range pg.Lambdas[j] {
+			shift, factor := pg.Normalization(j, g)
+			for _, x := range samples {
+				u := (PowerTransform(pg.Method, lambda, x) - shift) * factor
+				if squares {
+					u *= u
+				}
+				sums[j*G+g] += u
+			}
+		}
+	}
+	return sums, nil
+}
+
+// Number of samples of each feature in the slots of its lambdas, the other slots count one sample
+func (p *Party) powerCounts(args []float64) ([]float64, error) {
+	pg, err := parsePowerGrid(args)
+	if err != nil {
+		return nil, err
+	}
+	G := pg.Size()
+	if err = p.checkPowerData(pg.Method, len(pg.Lambdas), len(pg.Lambdas)*G); err != nil {
+		return nil, err
+	}
+
+	counts := make([]float64, len(p.Input))
+	for i := range counts {
+		counts[i] = 1
+	}
+	for j, samples := range p.Data.Samples {
+		for g := 0; g < G; g++ {
+			counts[j*G+g] = float64(len(samples))
+		}
+	}
+	return counts, nil
+}
+
+// Sum of the log terms of the log-likelihood for each feature, args holds the method
+func (p *Party) powerLogSums(args []float64) ([]float64, error) {
+	if len(args) != 1 || (int(args[0]) != PowerYeoJohnson && int(args[0]) != PowerBoxCox) {
+		return nil, fmt.Errorf("%w: malformed power method", ErrInvalidQuery)
+	}
+	method := int(args[0])
+	if p.Data == nil {
+		return nil, fmt.Errorf("%w: the party holds no samples", ErrInvalidQuery)
+	}
+	if err := p.checkPowerData(method, len(p.Data.Samples), len(p.Data.Samples)); err != nil {
+		return nil, err
+	}
+
+	sums := make([]float64, len(p.Input))
+	for j, samples := range p.Data.Samples {
+		for _, x := range samples {
+			sums[j] += PowerLogTerm(method, x)
+		}
+	}
+	return sums, nil
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+)
+
+// Log2 precision of exact values, the 53 bits of a float64, and of values that are not finite
+const (
+	maxLog2Precision = 53.0
+	minLog2Precision = -53.0
+)
+
+// StatPrecision compares the decrypted values of a statistic with its plaintext reference slot by slot. The relative
+// error of a slot whose reference is 0 is its absolute error. Slots whose value is NaN or infinite are only counted
+// in NonFinite, and give the statistic the lowest precision.
+type StatPrecision struct {
+	Name  string `json:"name"`
+	Slots int    `json:"slots"`
+
+	MaxAbsError float64 `json:"max_abs_error"`
+	MaxRelError float64 `json:"max_rel_error"`
+	// Slot of the largest absolute error
+	WorstSlot int `json:"worst_slot"`
+	NonFinite int `json:"non_finite"`
+
+	// -log2(MaxAbsError), capped to [-53, 53]
+	Log2Precision float64 `json:"log2_precision"`
+}
+
+// ComparePrecision compares the first min(len(values), len(expected)) slots of the values with their reference
+func ComparePrecision(name string, values, expected []float64) StatPrecision {
+	p := StatPrecision{Name: name, Slots: min(len(values), len(expected))}
+
+	for i := 0; i < p.Slots; i++ {
+		if math.IsNaN(values[i]) || math.IsInf(values[i], 0) {
+			p.NonFinite++
+			continue
+		}
+
+		abs := math.Abs(values[i] - expected[i])
+		rel := abs
+		if expected[i] != 0 {
+			rel = abs / math.Abs(expected[i])
+		}
+
+		if abs > p.MaxAbsError {
+			p.MaxAbsError, p.WorstSlot = abs, i
+		}
+		p.MaxRelError = math.Max(p.MaxRelError, rel)
+	}
+
+	if p.NonFinite > 0 {
+		p.Log2Precision = minLog2Precision
+	} else {
+		p.Log2Precision = math.Max(minLog2Precision, math.Min(maxLog2Precision, -math.Log2(p.MaxAbsError)))
+	}
+	return p
+}
+
+func (p StatPrecision) String() string {
+	s := fmt.Sprintf("%-8s max abs error %.3e (slot %d), max rel error %.3e, %.2f bits", p.Name, p.MaxAbsError, p.WorstSlot, p.MaxRelError, p.Log2Precision)
+	if p.NonFinite > 0 {
+		s += fmt.Sprintf(", %d non finite slots", p.NonFinite)
+	}
+	return s
+}
+
+// PrintPrecision prints the precision report of the statistics
+func PrintPrecision(report []StatPrecision) {
+	for _, p := range report {
+		fmt.Printf("%s\n", p)
+	}
+}
@@ -0,0 +1,197 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+	"github.com/tuneinsight/lattigo/v6/utils/sampling"
+)
+
+// Small ring degrees keep the tests short, the parameters are under 128 bit security
+var testCases = []struct {
+	logN    int
+	parties int
+}{
+	{12, 2},
+	{12, 5},
+	{13, 3},
+}
+
+// Error tolerated on the decrypted values of one encryption and key switch
+const testTolerance = 1e-4
+
+// Runs f on the parameters and the generated parties of every test case
+func runTestCases(t *testing.T, f func(t *testing.T, params ckks.Parameters, parties []*Party)) {
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("LogN=%d/parties=%d", tc.logN, tc.parties), func(t *testing.T) {
+			params := newTestParams(t, tc.logN)
+			f(t, params, GenZscoreParties(params, tc.parties))
+		})
+	}
+}
+
+// Parameters of ring degree 2^logN
+func newTestParams(t *testing.T, logN int) ckks.Parameters {
+	t.Helper()
+	params, err := ckks.NewParametersFromLiteral(ParametersLiteralLogN(logN))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return params
+}
+
+// Session of the parties over a local cohort, once the collective keys and the Galois keys of galEls are generated
+func newTestSession(t *testing.T, params ckks.Parameters, parties []*Party, galEls ...uint64) *Session {
+	t.Helper()
+	s, err := NewSession(params, NewLocalCohort(params, parties), DefaultCRSSeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Setup(galEls...); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// Random values in [-1, 1] in every slot
+func randomValues(params ckks.Parameters) []float64 {
+	values := make([]float64, params.MaxSlots())
+	for i := range values {
+		values[i] = 2*rand.Float64() - 1
+	}
+	return values
+}
+
+func checkValues(t *testing.T, values, expected []float64, tolerance float64) {
+	t.Helper()
+	if p := ComparePrecision("values", values, expected); p.NonFinite > 0 || p.MaxAbsError > tolerance {
+		t.Fatalf("%s, tolerance %.1e", p, tolerance)
+	}
+}
+
+func TestCollectiveKeyGen(t *testing.T) {
+	runTestCases(t, func(t *testing.T, params ckks.Parameters, parties []*Party) {
+		crs, err := sampling.NewKeyedPRNG(DefaultCRSSeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk, err := CollectiveKeyGen(params, crs, NewLocalCohort(params, parties))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// The collective public key is a key of the sum of the parties' secret keys
+		values := randomValues(params)
+		ct, err := EncryptOneValue(params, pk, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := IdealSecretKeyDecryption(params, ct, parties)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValues(t, decrypted, values, testTolerance)
+	})
+}
+
+func TestPcksPhase(t *testing.T) {
+	runTestCases(t, func(t *testing.T, params ckks.Parameters, parties []*Party) {
+		cohort := NewLocalCohort(params, parties)
+		crs, err := sampling.NewKeyedPRNG(DefaultCRSSeed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pk, err := CollectiveKeyGen(params, crs, cohort)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		values := randomValues(params)
+		ct, err := EncryptOneValue(params, pk, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Switched to the key of the receiver, who decrypts alone
+		tsk, tpk := rlwe.NewKeyGenerator(params).GenKeyPairNew()
+		decrypted, err := CollectiveDecryption(params, tsk, ct, tpk, cohort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValues(t, decrypted, values, testTolerance)
+
+		// The key of another receiver does not decrypt the result
+		other := rlwe.NewKeyGenerator(params).GenSecretKeyNew()
+		encOut, err := PcksPhase(params, tpk, ct, cohort)
+		if err != nil {
+			t.Fatal(err)
+		}
+		wrong, err := decodeValues(params, other, encOut)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p := ComparePrecision("values", wrong, values); p.NonFinite == 0 && p.MaxAbsError <= testTolerance {
+			t.Fatalf("decrypted with another secret key: %s", p)
+		}
+	})
+}
+
+func TestRefresher(t *testing.T) {
+	runTestCases(t, func(t *testing.T, params ckks.Parameters, parties []*Party) {
+		s := newTestSession(t, params, parties)
+
+		values := randomValues(params)
+		ct, err := EncryptOneValue(params, s.Pk, values)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Down to the lowest level a refresh accepts, as after the evaluation of a circuit
+		minLevel := s.Refresher.MinimumInputLevel()
+		if minLevel < 0 {
+			t.Fatalf("no level is enough to refresh with %d parties", len(parties))
+		}
+		ckks.NewEvaluator(params, nil).DropLevel(ct, ct.Level()-minLevel)
+
+		refreshed, err := s.Refresher.Refresh(ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if refreshed.Level() != params.MaxLevel() {
+			t.Fatalf("refreshed ciphertext at level %d, want MaxLevel %d", refreshed.Level(), params.MaxLevel())
+		}
+		if s.Metrics.Refreshes != 1 || s.Metrics.LevelsConsumed != params.MaxLevel()-minLevel {
+			t.Fatalf("metrics of the refresh: %d refreshes, %d levels consumed", s.Metrics.Refreshes, s.Metrics.LevelsConsumed)
+		}
+
+		decrypted, err := IdealSecretKeyDecryption(params, refreshed, parties)
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkValues(t, decrypted, values, testTolerance)
+	})
+}
+
+// The refresh of a ciphertext below the minimum level is refused
+func TestRefresherInsufficientLevel(t *testing.T) {
+	params := newTestParams(t, 12)
+	s := newTestSession(t, params, GenZscoreParties(params, 2))
+
+	minLevel := s.Refresher.MinimumInputLevel()
+	if minLevel <= 0 {
+		t.Skipf("the minimum level of a refresh is %d", minLevel)
+	}
+	ct, err := EncryptOneValue(params, s.Pk, randomValues(params))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ckks.NewEvaluator(params, nil).DropLevel(ct, ct.Level()-minLevel+1)
+
+	if _, err = s.Refresher.Refresh(ct); !errors.Is(err, ErrInsufficientLevel) {
+		t.Fatalf("refresh below the minimum level: %v, want %v", err, ErrInsufficientLevel)
+	}
+}
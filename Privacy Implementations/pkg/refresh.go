@@ -2,42 +2,44 @@ package pkg
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/multiparty"
 	"github.com/tuneinsight/lattigo/v6/multiparty/mpckks"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
 	"github.com/tuneinsight/lattigo/v6/utils/sampling"
 )
 
 type Refresher struct {
-	Parties []*Party
+	Cohort Cohort
 	N int
+	// Parties taking part in the refresh in the threshold mode, every party if nil
+	Active []int
+	// Excludes dropped parties and returns the new active parties, set by Session.Setup
+	drop func(dropped []int) ([]int, error)
+	// Costs of the refreshes, set by Session.Setup
+	Metrics *Metrics
 	crs sampling.PRNG
 	params ckks.Parameters
 }
 
-func NewRefresher(params ckks.Parameters, parties []*Party, crs sampling.PRNG, N int) *Refresher {
-	return &Refresher{Parties: parties, N: N, crs: crs, params: params}
+func NewRefresher(params ckks.Parameters, cohort Cohort, crs sampling.PRNG, N int) *Refresher {
+	return &Refresher{Cohort: cohort, N: N, crs: crs, params: params}
 }
 
 // Bootstrap implements the single-ciphertext bootstrapping
 func (refresher *Refresher) Bootstrap(ct *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
-	if ct.Level() < refresher.GetMinRefreshLevel() {
-		return nil, fmt.Errorf("ciphertext level too low")
-	}
-	return refresher.RefreshProtocol(refresher.params, refresher.crs, ct, refresher.Parties, refresher.N)
+	return refresher.RefreshProtocol(refresher.params, refresher.crs, ct, refresher.Cohort, refresher.N)
 }
 
 // BootstrapMany implements bootstrapping for a slice of ciphertexts
 func (refresher Refresher) BootstrapMany(cts []rlwe.Ciphertext) ([]rlwe.Ciphertext, error) {
 	results := make([]rlwe.Ciphertext, len(cts))
 	for i, ct := range cts {
-		if ct.Level() < refresher.GetMinRefreshLevel() {
-			return nil, fmt.Errorf("ciphertext at index %d has level too low", i)
-		}
-		encOut, err := refresher.RefreshProtocol(refresher.params, refresher.crs, &ct, refresher.Parties, refresher.N)
+		encOut, err := refresher.RefreshProtocol(refresher.params, refresher.crs, &ct, refresher.Cohort, refresher.N)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("ciphertext at index %d: %w", i, err)
 		}
 		results[i] = *encOut
 			
@@ -62,56 +64,121 @@ func (refresher Refresher) OutputLevel() int {
 
 // Refreshing function for testing purposes
 func (refresher Refresher) Refresh(encOut *rlwe.Ciphertext)	(*rlwe.Ciphertext, error) {
-	return refresher.RefreshProtocol(refresher.params, refresher.crs, encOut, refresher.Parties, refresher.N)
+	return refresher.RefreshProtocol(refresher.params, refresher.crs, encOut, refresher.Cohort, refresher.N)
 }
 
 // GetMinRefreshLevel returns the minimum level required for bootstrapping
 func (refresher Refresher) GetMinRefreshLevel() (int) {
-	minLevel, _, ok := mpckks.GetMinimumLevelForRefresh(128, refresher.params.DefaultScale(), refresher.N, refresher.params.Q())
+	minLevel, _, ok := mpckks.GetMinimumLevelForRefresh(128, refresher.params.DefaultScale(), refresher.participants(), refresher.params.Q())
 	if ok {
 		return minLevel
 	} else {
-		fmt.Printf("refresh error: not enough level to ensure correctness and 128 bit security")
+		// Not enough levels to ensure correctness and 128 bit security, RefreshProtocol returns ErrInsufficientLevel
 		return -1
 	}
 }
 
 
-func (refresher Refresher) RefreshProtocol(params ckks.Parameters, crs sampling.PRNG, ciphertext *rlwe.Ciphertext, P []*Party, N int) (encOut *rlwe.Ciphertext, err error) {
+// Number of parties taking part in a refresh
+func (refresher Refresher) participants() int {
+	if refresher.Active != nil {
+		return len(refresher.Active)
+	}
+	return refresher.N
+}
+
+// RefreshProtocol refreshes the ciphertext with the parties of the cohort. In the threshold mode, the active parties
+// that drop during the refresh are replaced and the refresh is run again.
+func (refresher Refresher) RefreshProtocol(params ckks.Parameters, crs sampling.PRNG, ciphertext *rlwe.Ciphertext, cohort Cohort, N int) (*rlwe.Ciphertext, error) {
+	for {
+		encOut, party, cloud, err := refresher.refresh(params, crs, ciphertext, cohort, N)
+		if err == nil {
+			refresher.Metrics.refresh(params.MaxLevel(), ciphertext.Level(), party, cloud)
+		}
+		dropped := droppedParties(err)
+		if len(dropped) == 0 || refresher.drop == nil {
+			return encOut, err
+		}
+
+		active, dropErr := refresher.drop(dropped)
+		if dropErr != nil {
+			return nil, phaseError(PhaseRefresh, dropErr)
+		}
+		// Every party is needed without the threshold mode
+		if active == nil {
+			return nil, err
+		}
+		refresher.Active = active
+	}
+}
+
+// Runs one refresh and returns the time spent by the parties and by the cloud
+func (refresher Refresher) refresh(params ckks.Parameters, crs sampling.PRNG, ciphertext *rlwe.Ciphertext, cohort Cohort, N int) (encOut *rlwe.Ciphertext, party, cloud time.Duration, err error) {
+
+	// In the threshold mode only the active parties take part
+	active := refresher.Active
+	if active != nil {
+		N = len(active)
+	}
 
 	minLevel, logBound, ok := mpckks.GetMinimumLevelForRefresh(128, params.DefaultScale(), N, params.Q())
-	if ok {
-		for i, pi := range P {
+	if !ok {
+		return nil, 0, 0, phaseError(PhaseRefresh, fmt.Errorf("%w: not enough level to ensure correctness and 128 bit security", ErrInsufficientLevel))
+	}
 
-			var err error
-			if i == 0 {
-				if pi.RefreshProtocol, err = mpckks.NewRefreshProtocol(params, logBound, params.Xe()); err != nil {
-					panic(err)
-				}
-			} else {
-				pi.RefreshProtocol = P[0].RefreshProtocol.ShallowCopy()
-			}
+	if err = checkCiphertext(params, ciphertext); err != nil {
+		return nil, 0, 0, phaseError(PhaseRefresh, err)
+	}
+	if ciphertext.Level() < minLevel {
+		return nil, 0, 0, phaseError(PhaseRefresh, fmt.Errorf("%w: ciphertext at level %d, refresh needs %d", ErrInsufficientLevel, ciphertext.Level(), minLevel))
+	}
 
-			pi.refreshShare = pi.AllocateShare(minLevel, params.MaxLevel())
-		}
+	rfp, err := mpckks.NewRefreshProtocol(params, logBound, params.Xe())
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseRefresh, err)
+	}
 
-		P0 := P[0]
-		crp := P0.SampleCRP(params.MaxLevel(), crs)
+	seed, err := newSeed(crs)
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseRefresh, err)
+	}
+	prng, err := newKeyedPRNG(seed)
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseRefresh, err)
+	}
+	crp := rfp.SampleCRP(params.MaxLevel(), prng)
+
+	var shares []multiparty.RefreshShare
+	party = RunTimedParty(func() {
+		shares, err = cohort.RefreshRound(active, seed, ciphertext)
+	}, N)
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseRefresh, err)
+	}
 
-		for i, p := range P {
-			p.GenShare(p.Sk, logBound, ciphertext, crp, &p.refreshShare)
+	combined := rfp.AllocateShare(minLevel, params.MaxLevel())
+	if err = checkShares(N, shares, combined); err != nil {
+		return nil, 0, 0, phaseError(PhaseRefresh, activePartyError(active, err))
+	}
 
-			if i > 0 {
-				P0.AggregateShares(&p.refreshShare, &P0.refreshShare, &P0.refreshShare)
+	encOut = ckks.NewCiphertext(params, 1, params.MaxLevel())
+	cloud = RunTimed(func() {
+		for i := range shares {
+			if i == 0 {
+				combined.MetaData = shares[i].MetaData
+				combined.EncToShareShare.Value.CopyLvl(minLevel, shares[i].EncToShareShare.Value)
+				combined.ShareToEncShare.Value.CopyLvl(params.MaxLevel(), shares[i].ShareToEncShare.Value)
+			} else if err = rfp.AggregateShares(&shares[i], &combined, &combined); err != nil {
+				err = activePartyError(active, &PartyError{Party: i, Err: err})
+				return
 			}
 		}
 
-		encOut := ckks.NewCiphertext(params, 1, params.MaxLevel())
-		P0.Finalize(ciphertext, crp, P0.refreshShare, encOut)
-
-		return encOut, nil
-	} else {
-		return nil, fmt.Errorf("refresh error: not enough level to ensure correctness and 128 bit security")
+		err = rfp.Finalize(ciphertext, crp, combined, encOut)
+	})
+	if err != nil {
+		return nil, 0, 0, phaseError(PhaseRefresh, err)
 	}
 
+	return encOut, party, cloud, nil
 }
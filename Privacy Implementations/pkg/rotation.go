@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// RotationGaloisElements returns the Galois elements of the slot rotations by each of rotations, to pass to Session.Setup
+func RotationGaloisElements(params ckks.Parameters, rotations ...int) []uint64 {
+	galEls := make([]uint64, len(rotations))
+	for i, k := range rotations {
+		galEls[i] = params.GaloisElement(k)
+	}
+	return galEls
+}
+
+// InnerSumGaloisElements returns the Galois elements of the power-of-two rotations of Session.InnerSum with batch and n
+func InnerSumGaloisElements(params ckks.Parameters, batch, n int) []uint64 {
+	return params.GaloisElementsForInnerSum(batch, n)
+}
+
+// InnerSum sums the n consecutive groups of batch slots of ct: the first group of every n groups holds the sum of the
+// n groups, the other slots are garbage. The collective Galois keys of InnerSumGaloisElements(params, batch, n) must
+// have been generated by Setup.
+func (s *Session) InnerSum(ct *rlwe.Ciphertext, batch, n int) (*rlwe.Ciphertext, error) {
+	if batch < 1 || n < 1 || batch*n > s.Params.MaxSlots() {
+		return nil, fmt.Errorf("%w: inner sum of %d groups of %d slots for %d slots", ErrEncodingOverflow, n, batch, s.Params.MaxSlots())
+	}
+
+	eval := ckks.NewEvaluator(s.Params, s.Evk)
+
+	sum := ckks.NewCiphertext(s.Params, ct.Degree(), ct.Level())
+	if err := eval.InnerSum(ct, batch, n, sum); err != nil {
+		return nil, err
+	}
+	return sum, nil
+}
+
+// Raw samples of the party for an InnerSum on the aggregator side, args holds the batch B, the number of samples S per
+// ciphertext, the chunk, the number of chunks and an optional public shift per feature. Sample chunk*S + i of feature j,
+// minus its shift, is in slot i*B + j, so that InnerSum(ct, B, S) sums every feature in the slots 0 to B-1.
+func (p *Party) packedSamples(args []float64) ([]float64, error) {
+	if len(args) < 4 || args[0] < 1 || args[1] < 1 || args[2] < 0 || args[2] >= args[3] {
+		return nil, fmt.Errorf("%w: malformed samples query of %d arguments", ErrInvalidQuery, len(args))
+	}
+	B, S, chunk, chunks := int(args[0]), int(args[1]), int(args[2]), int(args[3])
+	shift := args[4:]
+
+	samples := p.samples()
+	if samples == nil {
+		return nil, fmt.Errorf("%w: the party holds no samples", ErrInvalidQuery)
+	}
+	if len(samples) > B {
+		return nil, fmt.Errorf("%w: batch of %d slots for %d features", ErrEncodingOverflow, B, len(samples))
+	}
+	if len(shift) > 0 && len(shift) < len(samples) {
+		return nil, fmt.Errorf("%w: %d shifts for %d features", ErrInvalidQuery, len(shift), len(samples))
+	}
+
+	values := make([]float64, B*S)
+	for j, xs := range samples {
+		if len(xs) > S*chunks {
+			return nil, fmt.Errorf("%w: %d samples of feature %d in %d chunks of %d slots", ErrEncodingOverflow, len(xs), j, chunks, S)
+		}
+		for i := 0; i < S && chunk*S+i < len(xs); i++ {
+			values[i*B+j] = xs[chunk*S+i]
+			if len(shift) > 0 {
+				values[i*B+j] -= shift[j]
+			}
+		}
+	}
+	return values, nil
+}
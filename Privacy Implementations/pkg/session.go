@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+	"github.com/tuneinsight/lattigo/v6/utils/sampling"
+)
+
+// Parameters of the z score, minmax and robust commands
+var DefaultParametersLiteral = ckks.ParametersLiteral{
+	LogN:            15,                                                                // log2(ring degree)
+	LogQ:            []int{55, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45}, // log2(primes Q) (ciphertext modulus)
+	LogP:            []int{61},                                                         // log2(primes P) (auxiliary modulus)
+	LogDefaultScale: 45,
+}
+
+// ParametersLiteralLogN is DefaultParametersLiteral with a ring of degree 2^logN. The moduli are kept, so that the
+// circuits have the same depth: below LogN 15 the parameters are under 128 bit security and only meant for benchmarks.
+func ParametersLiteralLogN(logN int) ckks.ParametersLiteral {
+	literal := DefaultParametersLiteral
+	literal.LogN = logN
+	return literal
+}
+
+// Seed of the common reference string of the commands, the aggregator and the parties must agree on it
+var DefaultCRSSeed = []byte{'l', 'a', 't', 't', 'i', 'g', 'o'}
+
+// Session is one run of the protocol on a cohort of parties. It owns the parameters, the common reference string,
+// the collective keys and the refresher, so that a pipeline only has to call Setup, gather the parties' inputs,
+// evaluate its circuit with Evk and Refresher, and Reveal the result.
+type Session struct {
+	Params ckks.Parameters
+	// Cohort given to NewSession, wrapped so that the bytes of its rounds are accounted in Metrics
+	Cohort Cohort
+
+	crs sampling.PRNG
+
+	// Collective keys, set by Setup
+	Pk         *rlwe.PublicKey
+	Rlk        *rlwe.RelinearizationKey
+	GaloisKeys []*rlwe.GaloisKey
+	Evk        *rlwe.MemEvaluationKeySet
+
+	// Collective bootstrapping, set by Setup
+	Refresher *Refresher
+
+	// Threshold of the secret sharing and parties taking part in the key switches and refreshes,
+	// set by Thresholdize and SetActive. Every party takes part with its secret key if Active is nil.
+	Threshold int
+	Active    []int
+
+	// Parties behind each revealed ciphertext, in the order of the reveals
+	Releases []Release
+
+	// Costs of the phases run so far
+	Metrics *Metrics
+
+	// Dropped parties and parties whose inputs the current run of Consistent gathered
+	dropped     map[int]bool
+	contributed map[int]bool
+	run         int
+}
+
+// NewSession creates a session on the cohort, the common reference string is derived from crsSeed
+func NewSession(params ckks.Parameters, cohort Cohort, crsSeed []byte) (*Session, error) {
+	crs, err := sampling.NewKeyedPRNG(crsSeed)
+	if err != nil {
+		return nil, err
+	}
+	metrics := NewMetrics()
+	return &Session{Params: params, Cohort: &meteredCohort{Cohort: cohort, metrics: metrics}, crs: crs, Metrics: metrics, dropped: make(map[int]bool), contributed: make(map[int]bool)}, nil
+}
+
+// Setup runs the collective key generation phases: the public key, which is published to the parties,
+// the relinearization key and one Galois key per element of galEls
+func (s *Session) Setup(galEls ...uint64) (err error) {
+
+	var party, cloud time.Duration
+	if s.Pk, party, cloud, err = collectiveKeyGen(s.Params, s.crs, s.Cohort); err != nil {
+		return err
+	}
+	s.Metrics.add(PhaseCKG, 1, party, cloud)
+
+	if s.Rlk, party, cloud, err = relinearizationKeyGeneration(s.Params, s.crs, s.Cohort); err != nil {
+		return err
+	}
+	s.Metrics.add(PhaseRKG, 1, party, cloud)
+
+	if s.GaloisKeys, party, cloud, err = galoisKeysGeneration(s.Params, s.crs, s.Cohort, galEls); err != nil {
+		return err
+	}
+	if len(galEls) > 0 {
+		s.Metrics.add(PhaseGKG, len(galEls), party, cloud)
+	}
+
+	s.Evk = rlwe.NewMemEvaluationKeySet(s.Rlk, s.GaloisKeys...)
+	s.Refresher = NewRefresher(s.Params, s.Cohort, s.crs, s.Cohort.Len())
+	s.Refresher.Active = s.Active
+	s.Refresher.drop = s.drop
+	s.Refresher.Metrics = s.Metrics
+
+	return nil
+}
+
+// Input runs an input round, every party that did not drop answers the query and uploads its encrypted result.
+// The round is run again without the parties that drop during it, the ciphertexts are in the order of Contributors.
+func (s *Session) Input(q Query) ([]*rlwe.Ciphertext, error) {
+	if s.Pk == nil {
+		return nil, phaseError(PhaseInput, fmt.Errorf("%w: input round before setup", ErrMismatchedParameters))
+	}
+
+	for {
+		parties := s.Contributors()
+		if len(parties) == 0 {
+			return nil, phaseError(PhaseInput, fmt.Errorf("%w: every party dropped", ErrMissingShare))
+		}
+
+		var cts []*rlwe.Ciphertext
+		var err error
+		party := RunTimedParty(func() {
+			cts, err = s.Cohort.InputRound(parties, q)
+		}, len(parties))
+		if dropped := droppedParties(err); len(dropped) > 0 {
+			if _, err = s.drop(dropped); err != nil {
+				return nil, phaseError(PhaseInput, err)
+			}
+			continue
+		}
+		if err != nil {
+			return nil, phaseError(PhaseInput, err)
+		}
+
+		if len(cts) != len(parties) {
+			return nil, phaseError(PhaseInput, fmt.Errorf("%w: %d ciphertexts for %d parties", ErrMissingShare, len(cts), len(parties)))
+		}
+		for k, ct := range cts {
+			if err = checkCiphertext(s.Params, ct); err != nil {
+				return nil, phaseError(PhaseInput, &PartyError{Party: parties[k], Err: err})
+			}
+		}
+
+		for _, i := range parties {
+			s.contributed[i] = true
+		}
+		s.Metrics.add(PhaseEncrypt, 1, party, 0)
+		return cts, nil
+	}
+}
+
+// Encrypt encrypts the values of a party running in this process under the collective public key
+func (s *Session) Encrypt(party *Party, values []float64) (*rlwe.Ciphertext, error) {
+	return party.Encrypt(s.Params, values)
+}
+
+// Aggregate sums the ciphertexts of the parties
+func (s *Session) Aggregate(cts ...*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+	if len(cts) == 0 {
+		return nil, fmt.Errorf("%w: nothing to aggregate", ErrMissingShare)
+	}
+	for _, ct := range cts {
+		if err := checkCiphertext(s.Params, ct); err != nil {
+			return nil, err
+		}
+	}
+
+	eval := ckks.NewEvaluator(s.Params, nil)
+
+	sum := cts[0].CopyNew()
+	var err error
+	cloud := RunTimed(func() {
+		for _, ct := range cts[1:] {
+			if err = eval.Add(sum, ct, sum); err != nil {
+				return
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	s.Metrics.add(PhaseEncrypt, 0, 0, cloud)
+	return sum, nil
+}
+
+// Reveal switches ct from the collective secret key to the public key of the recipient,
+// only the holder of the matching secret key can decrypt the result. In the threshold mode, the active parties
+// that drop during the key switch are replaced and the key switch is run again.
+func (s *Session) Reveal(ct *rlwe.Ciphertext, recipient *rlwe.PublicKey) (*rlwe.Ciphertext, error) {
+	for {
+		encOut, party, cloud, err := thresholdPcksPhase(s.Params, recipient, ct, s.Cohort, s.Active)
+		if dropped := droppedParties(err); len(dropped) > 0 {
+			active, dropErr := s.drop(dropped)
+			if dropErr != nil {
+				return nil, phaseError(PhasePCKS, dropErr)
+			}
+			// Every party is needed without the threshold mode
+			if active == nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		s.Metrics.keySwitch(s.Params.MaxLevel(), ct.Level(), party, cloud)
+
+		s.release()
+		return encOut, nil
+	}
+}
+
+// RevealValues reveals ct to a fresh key pair of the caller and decodes it
+func (s *Session) RevealValues(ct *rlwe.Ciphertext) ([]float64, error) {
+	tsk, tpk := rlwe.NewKeyGenerator(s.Params).GenKeyPairNew()
+	encOut, err := s.Reveal(ct, tpk)
+	if err != nil {
+		return nil, err
+	}
+	return decodeValues(s.Params, tsk, encOut)
+}
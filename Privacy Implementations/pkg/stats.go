@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/inverse"
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/minimax"
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// Domain of the total number of samples for the inverse evaluator
+const (
+	countLogMin = -30.0
+	countLogMax = 30.0
+)
+
+// Domain of the variance for InverseStd, 2^VarianceLogMin is added to the variance so that features of zero variance stay
+// in the domain. 2^VarianceLogMax is the default bound of the variance, that of features within 2^10 of a constant.
+const (
+	VarianceLogMin = -16.0
+	VarianceLogMax = 20.0
+)
+
+// Features whose variance is below 2^ConstantVarianceLog are constant and left unscaled by StdFromInverse. The floor
+// 2^VarianceLogMin is removed from the variance first, so features of small but real variance keep their scale. The
+// floor is well above the error of the variance recovered from 1/std, about 2^-30 for the default scale.
+const ConstantVarianceLog = VarianceLogMin - 8
+
+// Relative error tolerated on the revealed 1/std at the bounds of the domain of InverseStd
+const inverseStdTolerance = 1e-2
+
+// CountInverse sums the parties' numbers of samples and returns the encrypted inverse of the total in every slot.
+// Every slot must hold a positive count, the slots past the features count one sample.
+func (s *Session) CountInverse(counts []*rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+
+	eval := ckks.NewEvaluator(s.Params, s.Evk)
+	invEval := inverse.NewEvaluator(s.Params, minimax.NewEvaluator(s.Params, eval, s.Refresher))
+
+	total, err := s.Aggregate(counts...)
+	if err != nil {
+		return nil, err
+	}
+
+	inv, err := invEval.EvaluatePositiveDomainNew(total, countLogMin, countLogMax)
+	if err != nil {
+		return nil, err
+	}
+
+	// The inverse is multiplied with every sum of the pipeline, it starts at the maximum level
+	return s.Refresher.Bootstrap(inv)
+}
+
+// Average sums the parties' ciphertexts and multiplies the total by countInverse, e.g. the mean is Average(sums, CountInverse(counts))
+func (s *Session) Average(cts []*rlwe.Ciphertext, countInverse *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+
+	eval := ckks.NewEvaluator(s.Params, s.Evk)
+
+	total, err := s.Aggregate(cts...)
+	if err != nil {
+		return nil, err
+	}
+
+	avg, err := eval.MulRelinNew(total, countInverse)
+	if err != nil {
+		return nil, err
+	}
+	if err = eval.Rescale(avg, avg); err != nil {
+		return nil, err
+	}
+	return avg, nil
+}
+
+// VarianceFromSquares returns E[X^2] - E[X]^2 from the parties' sums of squares and the encrypted mean.
+// The subtraction loses the precision of E[X]^2 relative to the variance, the values should be shifted close to 0.
+func (s *Session) VarianceFromSquares(sumSquares []*rlwe.Ciphertext, mean, countInverse *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
+
+	eval := ckks.NewEvaluator(s.Params, s.Evk)
+
+	// E[X^2]
+	meanOfSquares, err := s.Average(sumSquares, countInverse)
+	if err != nil {
+		return nil, err
+	}
+
+	// E[X]^2
+	squaredMean, err := eval.MulRelinNew(mean, mean)
+	if err != nil {
+		return nil, err
+	}
+	if err = eval.Rescale(squaredMean, squaredMean); err != nil {
+		return nil, err
+	}
+
+	// Both terms must have the same scale to be subtracted, the rescaling of E[X]^2 divided it by another prime
+	if err = eval.SetScale(meanOfSquares, squaredMean.Scale); err != nil {
+		return nil, err
+	}
+
+	return eval.SubNew(meanOfSquares, squaredMean)
+}
+
+// VarianceLogBound returns the log2max of the domain of InverseStd for features whose values lie within bounds[i] of a
+// constant, their variance is at most bounds[i]^2. The domain stays above 2^VarianceLogMin, the bounds must be positive.
+func VarianceLogBound(bounds []float64) (float64, error) {
+	log2max := VarianceLogMin + 1
+	for i, b := range bounds {
+		if !(b > 0) || math.IsInf(b, 0) {
+			return 0, fmt.Errorf("bound %v of feature %d is not positive and finite", b, i)
+		}
+		log2max = math.Max(log2max, 2*math.Log2(b))
+	}
+	return log2max, nil
+}
+
+// InverseStd returns 1/sqrt(variance + 2^log2min) for a variance in [0, 2^log2max], see InverseSqrtNew.
+// The result is meaningless for a variance outside the domain, StdFromInverse detects it once revealed.
+func (s *Session) InverseStd(variance *rlwe.Ciphertext, log2min, log2max float64) (*rlwe.Ciphertext, error) {
+	eval := ckks.NewEvaluator(s.Params, s.Evk)
+	return InverseSqrtNew(s.Params, eval, s.Refresher, variance, log2min, log2max)
+}
+
+// StdFromInverse returns the standard deviation and its inverse of every slot from the revealed result of InverseStd
+// over the domain [2^log2min, 2^log2max]. The floor 2^log2min is removed from the variance, and the features of
+// variance below 2^ConstantVarianceLog get a standard deviation of 1, so that (x - mean) / std leaves them unscaled.
+// A value that InverseStd cannot return for a variance of the domain, as when the Newton iteration diverges, is an
+// ErrOutOfDomain error.
+func StdFromInverse(invStd []float64, log2min, log2max float64) (std []float64, inv []float64, err error) {
+
+	lo := (1 - inverseStdTolerance) / math.Sqrt(math.Exp2(log2max)+math.Exp2(log2min))
+	hi := (1 + inverseStdTolerance) / math.Sqrt(math.Exp2(log2min))
+
+	std = make([]float64, len(invStd))
+	inv = make([]float64, len(invStd))
+	for i, y := range invStd {
+		if math.IsNaN(y) || y < lo || y > hi {
+			return nil, nil, fmt.Errorf("%w: 1/std %v in slot %d, the variance is outside [0, 2^%v]", ErrOutOfDomain, y, i, log2max)
+		}
+
+		variance := 1/(y*y) - math.Exp2(log2min)
+		if variance < math.Exp2(ConstantVarianceLog) {
+			std[i], inv[i] = 1, 1
+			continue
+		}
+		std[i] = math.Sqrt(variance)
+		inv[i] = 1 / std[i]
+	}
+
+	return std, inv, nil
+}
@@ -0,0 +1,39 @@
+package pkg
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestStdFromInverse(t *testing.T) {
+	floor := math.Exp2(VarianceLogMin)
+	for _, tc := range []struct {
+		name     string
+		variance float64
+		std      float64
+	}{
+		{"unit", 1, 1},
+		{"large", math.Exp2(VarianceLogMax), math.Exp2(VarianceLogMax / 2)},
+		// Far below the floor 2^VarianceLogMin added by InverseStd, and still scaled
+		{"small", 1e-6, 1e-3},
+		{"constant", 0, 1},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			std, inv, err := StdFromInverse([]float64{1 / math.Sqrt(tc.variance+floor)}, VarianceLogMin, VarianceLogMax)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if math.Abs(std[0]-tc.std) > 1e-6*tc.std || math.Abs(inv[0]*std[0]-1) > 1e-12 {
+				t.Fatalf("std %v and 1/std %v, want std %v", std[0], inv[0], tc.std)
+			}
+		})
+	}
+
+	// Results of a diverging iteration
+	for _, y := range []float64{math.NaN(), math.Inf(1), -1, 0, 2 / math.Sqrt(floor)} {
+		if _, _, err := StdFromInverse([]float64{1, y}, VarianceLogMin, VarianceLogMax); !errors.Is(err, ErrOutOfDomain) {
+			t.Errorf("1/std %v: %v, want %v", y, err, ErrOutOfDomain)
+		}
+	}
+}
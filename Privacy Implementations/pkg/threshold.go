@@ -0,0 +1,280 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/multiparty"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+// ExchangeKey is the X25519 public key a party publishes to receive the Shamir shares of the other parties. It is not
+// signed, see Thresholdize for the trust it assumes.
+type ExchangeKey []byte
+
+func (k ExchangeKey) MarshalBinary() ([]byte, error) {
+	return append([]byte{}, k...), nil
+}
+
+func (k *ExchangeKey) UnmarshalBinary(p []byte) error {
+	*k = append(ExchangeKey{}, p...)
+	return nil
+}
+
+func (k ExchangeKey) BinarySize() int {
+	return len(k)
+}
+
+// SealedShare is a Shamir share of a secret key encrypted for its recipient, the aggregator relays it without learning it
+type SealedShare []byte
+
+func (s SealedShare) MarshalBinary() ([]byte, error) {
+	return append([]byte{}, s...), nil
+}
+
+func (s *SealedShare) UnmarshalBinary(p []byte) error {
+	*s = append(SealedShare{}, p...)
+	return nil
+}
+
+func (s SealedShare) BinarySize() int {
+	return len(s)
+}
+
+// Thresholdize turns the N-out-of-N collective secret key into a t-out-of-N one: every party splits its secret key
+// into Shamir shares of threshold t, one per party, and aggregates the shares it receives. The shares travel through
+// the aggregator encrypted for their recipient. Afterwards, any t parties can key switch and refresh: the first t
+// parties are active until SetActive selects others. Every party must be online for the threshold setup, as for the
+// key generation.
+//
+// The exchange keys are relayed by the aggregator without authentication: the parties hold no identity keys to sign
+// them with. The sealing keeps the shares from an aggregator that follows the protocol and from eavesdroppers, but an
+// aggregator that replaces the exchange keys of the other parties with its own receives their shares of the secret
+// keys, and t of them recover every secret key. The threshold mode thus assumes an honest relay of the exchange keys;
+// a party only checks that its own key was relayed unchanged.
+func (s *Session) Thresholdize(t int) (err error) {
+	if t < 1 || t > s.Cohort.Len() {
+		return phaseError(PhaseThreshold, fmt.Errorf("%w: threshold %d for %d parties", ErrMismatchedParameters, t, s.Cohort.Len()))
+	}
+
+	var keys []ExchangeKey
+	var sealed [][]SealedShare
+	party := RunTimedParty(func() {
+		if keys, err = s.Cohort.ThresholdKeyExchangeRound(); err != nil {
+			return
+		}
+		if sealed, err = s.Cohort.ShamirShareRound(t, keys); err != nil {
+			return
+		}
+		err = s.Cohort.ShamirAggregateRound(sealed)
+	}, s.Cohort.Len())
+	if err != nil {
+		return phaseError(PhaseThreshold, err)
+	}
+	s.Metrics.add(PhaseThreshold, 3, party, 0)
+
+	s.Threshold = t
+
+	active := make([]int, t)
+	for i := range active {
+		active[i] = i
+	}
+	return s.SetActive(active)
+}
+
+// SetActive selects the t parties that take part in the key switches and refreshes of the threshold mode,
+// or every party with their secret keys if active is nil
+func (s *Session) SetActive(active []int) error {
+	if active != nil {
+		if s.Threshold == 0 {
+			return phaseError(PhaseThreshold, fmt.Errorf("%w: active parties before the threshold setup", ErrMismatchedParameters))
+		}
+		if len(active) != s.Threshold {
+			return phaseError(PhaseThreshold, fmt.Errorf("%w: %d active parties for a threshold of %d", ErrMissingShare, len(active), s.Threshold))
+		}
+		seen := make(map[int]bool)
+		for _, i := range active {
+			if i < 0 || i >= s.Cohort.Len() || seen[i] {
+				return phaseError(PhaseThreshold, fmt.Errorf("%w: invalid active party %d", ErrMismatchedParameters, i))
+			}
+			seen[i] = true
+		}
+	}
+
+	s.Active = active
+	if s.Refresher != nil {
+		s.Refresher.Active = active
+	}
+	return nil
+}
+
+// Shamir point of the party of the ID, the point 0 is the secret itself
+func shamirPoint(id int) multiparty.ShamirPublicPoint {
+	return multiparty.ShamirPublicPoint(id + 1)
+}
+
+// Generates the key pair the party receives its Shamir shares with
+func (p *Party) genExchangeKey() (ExchangeKey, error) {
+	sk, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	p.exchangeKey = sk
+	return sk.PublicKey().Bytes(), nil
+}
+
+// Shamir shares of threshold t of the party's secret key, share j is sealed for party j with keys[j], which is trusted
+// as relayed (see Thresholdize)
+func (p *Party) genShamirShares(params ckks.Parameters, t int, keys []ExchangeKey) ([]SealedShare, error) {
+	if p.exchangeKey == nil {
+		return nil, fmt.Errorf("%w: Shamir shares before the key exchange", ErrMismatchedParameters)
+	}
+	if t < 1 || t > len(keys) || p.ID >= len(keys) {
+		return nil, fmt.Errorf("%w: threshold %d for %d parties", ErrMismatchedParameters, t, len(keys))
+	}
+	if !bytes.Equal(keys[p.ID], p.exchangeKey.PublicKey().Bytes()) {
+		return nil, fmt.Errorf("%w: the exchange key of party %d was replaced", ErrMismatchedParameters, p.ID)
+	}
+
+	thr := multiparty.NewThresholdizer(params)
+	poly, err := thr.GenShamirPolynomial(t, p.Sk)
+	if err != nil {
+		return nil, err
+	}
+
+	sealed := make([]SealedShare, len(keys))
+	share := thr.AllocateThresholdSecretShare()
+	for j := range keys {
+		thr.GenShamirSecretShare(shamirPoint(j), poly, &share)
+		data, err := share.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		if sealed[j], err = sealShare(p.exchangeKey, keys[j], p.ID, j, data); err != nil {
+			return nil, err
+		}
+	}
+
+	p.exchangeKeys = keys
+	p.threshold = t
+	return sealed, nil
+}
+
+// Opens the shares sealed for the party, sealed[i] comes from party i, and sums them into the party's threshold share
+func (p *Party) aggregateShamirShares(params ckks.Parameters, sealed []SealedShare) error {
+	if p.exchangeKeys == nil || len(sealed) != len(p.exchangeKeys) {
+		return fmt.Errorf("%w: %d Shamir shares for %d parties", ErrMissingShare, len(sealed), len(p.exchangeKeys))
+	}
+
+	thr := multiparty.NewThresholdizer(params)
+	tsk := thr.AllocateThresholdSecretShare()
+	share := thr.AllocateThresholdSecretShare()
+	for i := range sealed {
+		data, err := openShare(p.exchangeKey, p.exchangeKeys[i], i, p.ID, sealed[i])
+		if err != nil {
+			return fmt.Errorf("Shamir share of party %d: %w", i, err)
+		}
+		if err = share.UnmarshalBinary(data); err != nil {
+			return fmt.Errorf("Shamir share of party %d: %w", i, err)
+		}
+		if err = thr.AggregateShares(tsk, share, &tsk); err != nil {
+			return fmt.Errorf("%w: Shamir share of party %d: %v", ErrMismatchedParameters, i, err)
+		}
+	}
+
+	p.thresholdShare = &tsk
+	return nil
+}
+
+// Secret key the party uses in a key switch or a refresh: its own secret key if active is nil, else the additive share
+// of the collective secret key the party combines from its threshold share for the active parties
+func (p *Party) activeSecretKey(params ckks.Parameters, active []int) (*rlwe.SecretKey, error) {
+	if active == nil {
+		return p.Sk, nil
+	}
+	if p.thresholdShare == nil {
+		return nil, fmt.Errorf("%w: threshold round before the threshold setup", ErrMismatchedParameters)
+	}
+	if len(active) != p.threshold {
+		return nil, fmt.Errorf("%w: %d active parties for a threshold of %d", ErrMissingShare, len(active), p.threshold)
+	}
+
+	own := shamirPoint(p.ID)
+	points := make([]multiparty.ShamirPublicPoint, len(p.exchangeKeys))
+	for i := range points {
+		points[i] = shamirPoint(i)
+	}
+
+	activePoints := make([]multiparty.ShamirPublicPoint, len(active))
+	isActive := false
+	for k, i := range active {
+		if i < 0 || i >= len(points) {
+			return nil, fmt.Errorf("%w: invalid active party %d", ErrMismatchedParameters, i)
+		}
+		activePoints[k] = points[i]
+		isActive = isActive || i == p.ID
+	}
+	if !isActive {
+		return nil, fmt.Errorf("%w: party %d is not an active party", ErrMismatchedParameters, p.ID)
+	}
+
+	sk := rlwe.NewSecretKey(params)
+	cmb := multiparty.NewCombiner(*params.GetRLWEParameters(), own, points, p.threshold)
+	if err := cmb.GenAdditiveShare(activePoints, own, *p.thresholdShare, sk); err != nil {
+		return nil, err
+	}
+	return sk, nil
+}
+
+// Encrypts a Shamir share from party from to party to, with AES-GCM under the X25519 secret shared by the two parties
+func sealShare(sk *ecdh.PrivateKey, peer ExchangeKey, from, to int, share []byte) (SealedShare, error) {
+	aead, err := shareCipher(sk, peer)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, share, shareHeader(from, to)), nil
+}
+
+// Decrypts a share sealed by sealShare, the IDs of the sender and the recipient are authenticated
+func openShare(sk *ecdh.PrivateKey, peer ExchangeKey, from, to int, sealed SealedShare) ([]byte, error) {
+	aead, err := shareCipher(sk, peer)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("%w: sealed share of %d bytes", ErrMissingShare, len(sealed))
+	}
+	return aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], shareHeader(from, to))
+}
+
+func shareCipher(sk *ecdh.PrivateKey, peer ExchangeKey) (cipher.AEAD, error) {
+	pk, err := ecdh.X25519().NewPublicKey(peer)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := sk.ECDH(pk)
+	if err != nil {
+		return nil, err
+	}
+	key := sha256.Sum256(secret)
+	block, err := aes.NewCipher(key[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func shareHeader(from, to int) []byte {
+	return binary.BigEndian.AppendUint32(binary.BigEndian.AppendUint32(nil, uint32(from)), uint32(to))
+}
@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/multiparty"
+)
+
+// RoundTraffic is the communication of one round: the bytes each party of Parties sent to the aggregator (Upload) and
+// received from it (Download). Only the serialized shares, ciphertexts, keys, seeds and query arguments are counted,
+// not the headers of the wire format.
+type RoundTraffic struct {
+	Phase    string `json:"phase"`
+	Round    string `json:"round"`
+	Parties  []int  `json:"parties"`
+	Upload   []int  `json:"upload"`
+	Download []int  `json:"download"`
+}
+
+// meteredCohort accounts the bytes of every round of the cohort in the metrics, the same way for the local and the
+// networked cohorts. Rounds that fail are not accounted, their rerun without the dropped parties is.
+type meteredCohort struct {
+	Cohort
+	metrics *Metrics
+}
+
+func (c *meteredCohort) PublicKeyGenRound(seed []byte) ([]multiparty.PublicKeyGenShare, error) {
+	shares, err := c.Cohort.PublicKeyGenRound(seed)
+	if err == nil {
+		c.record(PhaseCKG, "share", nil, sizes(shares), len(seed))
+	}
+	return shares, err
+}
+
+func (c *meteredCohort) PublishPublicKey(pk *rlwe.PublicKey) error {
+	err := c.Cohort.PublishPublicKey(pk)
+	if err == nil {
+		c.record(PhaseCKG, "publish", nil, nil, pk.BinarySize())
+	}
+	return err
+}
+
+func (c *meteredCohort) RelinearizationKeyGenRoundOne(seed []byte) ([]multiparty.RelinearizationKeyGenShare, error) {
+	shares, err := c.Cohort.RelinearizationKeyGenRoundOne(seed)
+	if err == nil {
+		c.record(PhaseRKG, "round 1", nil, sizes(shares), len(seed))
+	}
+	return shares, err
+}
+
+func (c *meteredCohort) RelinearizationKeyGenRoundTwo(round1 multiparty.RelinearizationKeyGenShare) ([]multiparty.RelinearizationKeyGenShare, error) {
+	shares, err := c.Cohort.RelinearizationKeyGenRoundTwo(round1)
+	if err == nil {
+		c.record(PhaseRKG, "round 2", nil, sizes(shares), round1.BinarySize())
+	}
+	return shares, err
+}
+
+func (c *meteredCohort) GaloisKeyGenRound(seed []byte, galEl uint64) ([]multiparty.GaloisKeyGenShare, error) {
+	shares, err := c.Cohort.GaloisKeyGenRound(seed, galEl)
+	if err == nil {
+		c.record(PhaseGKG, "share", nil, sizes(shares), len(seed)+8)
+	}
+	return shares, err
+}
+
+func (c *meteredCohort) PublicKeySwitchRound(active []int, tpk *rlwe.PublicKey, ct *rlwe.Ciphertext) ([]multiparty.PublicKeySwitchShare, error) {
+	shares, err := c.Cohort.PublicKeySwitchRound(active, tpk, ct)
+	if err == nil {
+		c.record(PhasePCKS, "share", active, sizes(shares), tpk.BinarySize()+ct.BinarySize())
+	}
+	return shares, err
+}
+
+func (c *meteredCohort) RefreshRound(active []int, seed []byte, ct *rlwe.Ciphertext) ([]multiparty.RefreshShare, error) {
+	shares, err := c.Cohort.RefreshRound(active, seed, ct)
+	if err == nil {
+		c.record(PhaseRefresh, "share", active, sizes(shares), len(seed)+ct.BinarySize())
+	}
+	return shares, err
+}
+
+func (c *meteredCohort) ThresholdKeyExchangeRound() ([]ExchangeKey, error) {
+	keys, err := c.Cohort.ThresholdKeyExchangeRound()
+	if err == nil {
+		c.record(PhaseThreshold, "exchange keys", nil, sizes(keys), 0)
+	}
+	return keys, err
+}
+
+// Every party receives the exchange keys of every party and sends one sealed share to each party
+func (c *meteredCohort) ShamirShareRound(threshold int, keys []ExchangeKey) ([][]SealedShare, error) {
+	sealed, err := c.Cohort.ShamirShareRound(threshold, keys)
+	if err == nil {
+		upload := make([]int, len(sealed))
+		for i := range sealed {
+			upload[i] = total(sizes(sealed[i]))
+		}
+		c.record(PhaseThreshold, "shamir shares", nil, upload, total(sizes(keys)))
+	}
+	return sealed, err
+}
+
+// Party j receives the share sealed[i][j] of every party i
+func (c *meteredCohort) ShamirAggregateRound(sealed [][]SealedShare) error {
+	err := c.Cohort.ShamirAggregateRound(sealed)
+	if err == nil {
+		download := make([]int, c.Len())
+		for i := range sealed {
+			for j, share := range sealed[i] {
+				download[j] += share.BinarySize()
+			}
+		}
+		c.recordEach(PhaseThreshold, "shamir aggregate", nil, nil, download)
+	}
+	return err
+}
+
+func (c *meteredCohort) InputRound(parties []int, q Query) ([]*rlwe.Ciphertext, error) {
+	cts, err := c.Cohort.InputRound(parties, q)
+	if err == nil {
+		c.record(PhaseEncrypt, q.Name, parties, sizes(cts), len(q.Name)+8*len(q.Args))
+	}
+	return cts, err
+}
+
+// Records a round in which each party of ids (every party if nil) receives download bytes
+func (c *meteredCohort) record(phase, round string, ids []int, upload []int, download int) {
+	if ids == nil {
+		ids = c.all()
+	}
+	each := make([]int, len(ids))
+	for k := range each {
+		each[k] = download
+	}
+	c.recordEach(phase, round, ids, upload, each)
+}
+
+// Records a round with the bytes of each party of ids (every party if nil), in the order of ids
+func (c *meteredCohort) recordEach(phase, round string, ids []int, upload, download []int) {
+	if ids == nil {
+		ids = c.all()
+	}
+	if upload == nil {
+		upload = make([]int, len(ids))
+	}
+	c.metrics.traffic(RoundTraffic{Phase: phase, Round: round, Parties: ids, Upload: upload, Download: download})
+}
+
+func (c *meteredCohort) all() []int {
+	ids := make([]int, c.Len())
+	for i := range ids {
+		ids[i] = i
+	}
+	return ids
+}
+
+// Serialized sizes of the shares, ciphertexts or keys
+func sizes[T interface{ BinarySize() int }](objects []T) []int {
+	s := make([]int, len(objects))
+	for i, o := range objects {
+		s[i] = o.BinarySize()
+	}
+	return s
+}
+
+func total(sizes []int) (sum int) {
+	for _, s := range sizes {
+		sum += s
+	}
+	return
+}
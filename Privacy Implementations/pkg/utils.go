@@ -6,12 +6,6 @@ import (
 	"time"
 )
 
-func Check(err error) {
-	if err != nil {
-		panic(err)
-	}
-}
-
 func RunTimed(f func()) time.Duration {
 	start := time.Now()
 	f()
@@ -25,12 +19,41 @@ func RunTimedParty(f func(), N int) time.Duration {
 }
 
 func PrintValues(values []float64) {
-	for i := 0; i < 4; i++ {
+	for i := 0; i < 4 && i < len(values); i++ {
 		fmt.Printf("%20.15f ", values[i])
 	}
 	fmt.Printf("...\n")
 }
 
+// Prints the parties behind each revealed statistic, the statistics of the runs discarded after a dropout are marked
+func PrintReleases(releases []Release) {
+	last := 0
+	for _, r := range releases {
+		last = max(last, r.Run)
+	}
+	for i, r := range releases {
+		fmt.Printf("Release %d: contributors %v, decryptors %v", i, r.Contributors, r.Decryptors)
+		if r.Run < last {
+			fmt.Printf(" (discarded)")
+		}
+		fmt.Printf("\n")
+	}
+}
+
+// Prints the party and cloud time and the bytes of each phase, in the order of the protocol, the refresh and key
+// switch counts and the bytes of each party
+func PrintMetrics(m *Metrics) {
+	for _, phase := range []string{PhaseCKG, PhaseRKG, PhaseGKG, PhaseThreshold, PhaseEncrypt, PhasePCKS, PhaseRefresh} {
+		if p, ok := m.Phases[phase]; ok {
+			fmt.Printf("%-9s rounds %3d, party %v, cloud %v, upload %d B, download %d B\n", phase, p.Rounds, p.Party, p.Cloud, p.Upload, p.Download)
+		}
+	}
+	fmt.Printf("Refreshes: %d, key switches: %d, levels consumed: %d, min level: %d\n", m.Refreshes, m.KeySwitches, m.LevelsConsumed, m.MinLevel)
+	for i := range m.PartyUpload {
+		fmt.Printf("Party %d: upload %d B, download %d B\n", i, m.PartyUpload[i], m.PartyDownload[i])
+	}
+}
+
 func PrintZscorePartyInputs(parties []*Party) {
 	for i, pi := range parties {
 
@@ -91,9 +114,13 @@ func PrintRobustPartyInputs(parties []*Party) {
 		fmt.Printf("Data: \n")
 
 		for i := 0; i < len(pi.RobustScalingInput); i++ {
-			for j := 0; j < len(pi.RobustScalingInput[i]); j++ {
+			// Datasets read from CSV files can hold hundreds of samples per feature
+			for j := 0; j < len(pi.RobustScalingInput[i]) && j < 8; j++ {
 				fmt.Printf("%2.8f ", float64(pi.RobustScalingInput[i][j]))
 			}
+			if len(pi.RobustScalingInput[i]) > 8 {
+				fmt.Printf("...")
+			}
 			fmt.Printf("\n")
 		}
 
@@ -0,0 +1,388 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/multiparty"
+)
+
+// Wire format of a Message, all integers are big endian.
+//
+// Header (34 bytes):
+//
+//	version  uint8     WireVersion
+//	type     uint8     MessageType
+//	session  [16]byte  SessionID
+//	round    uint32
+//	party    int32     sender, AggregatorID for the aggregator
+//	length   uint64    length of the body in bytes
+//
+// Body:
+//
+//	nparties  uint32
+//	threshold uint32
+//	active    uint32 count, count x uint32 (IDs of the active parties)
+//	galEl     uint64
+//	seed      uint32 length, bytes
+//	query     uint32 length, bytes (name), uint32 count, count x float64 (args)
+//	error     uint32 length, bytes
+//	objects   uint32 count, then for each: uint8 ObjectType, uint64 length, bytes (MarshalBinary of the object)
+const WireVersion uint8 = 2
+
+const headerSize = 34
+
+// Upper bound on the body of a message, a relinearization key share at LogN 16 stays well below it. The body is read
+// as it arrives, so a header announcing a large body does not allocate it before the bytes are received.
+const maxBodySize = 1 << 31
+
+// Upper bound on the body of a hello, which the aggregator reads from connections that are not yet authenticated
+const maxHelloSize = 1 << 10
+
+// AggregatorID is the sender ID of the messages of the aggregator
+const AggregatorID = -1
+
+// SessionID identifies one run of the protocol, every message of the run carries it
+type SessionID [16]byte
+
+func NewSessionID() (id SessionID, err error) {
+	_, err = rand.Read(id[:])
+	return
+}
+
+func (id SessionID) String() string {
+	return fmt.Sprintf("%x", id[:])
+}
+
+// ObjectType tags each marshalled object of a message, so that a message read back from the wire
+// or from disk is only ever decoded into the type it was produced from
+type ObjectType uint8
+
+const (
+	ObjPublicKeyGenShare ObjectType = iota + 1
+	ObjRelinearizationKeyGenShare
+	ObjGaloisKeyGenShare
+	ObjPublicKeySwitchShare
+	ObjRefreshShare
+	ObjCiphertext
+	ObjPublicKey
+	ObjRelinearizationKey
+	ObjGaloisKey
+	ObjExchangeKey
+	ObjSealedShare
+)
+
+func (t ObjectType) String() string {
+	switch t {
+	case ObjPublicKeyGenShare:
+		return "PublicKeyGenShare"
+	case ObjRelinearizationKeyGenShare:
+		return "RelinearizationKeyGenShare"
+	case ObjGaloisKeyGenShare:
+		return "GaloisKeyGenShare"
+	case ObjPublicKeySwitchShare:
+		return "PublicKeySwitchShare"
+	case ObjRefreshShare:
+		return "RefreshShare"
+	case ObjCiphertext:
+		return "Ciphertext"
+	case ObjPublicKey:
+		return "PublicKey"
+	case ObjRelinearizationKey:
+		return "RelinearizationKey"
+	case ObjGaloisKey:
+		return "GaloisKey"
+	case ObjExchangeKey:
+		return "ExchangeKey"
+	case ObjSealedShare:
+		return "SealedShare"
+	default:
+		return fmt.Sprintf("ObjectType(%d)", uint8(t))
+	}
+}
+
+// Object is one marshalled share, key or ciphertext of a message
+type Object struct {
+	Type ObjectType
+	Data []byte
+}
+
+func objectTypeOf(obj interface{}) (ObjectType, error) {
+	switch obj.(type) {
+	case multiparty.PublicKeyGenShare, *multiparty.PublicKeyGenShare:
+		return ObjPublicKeyGenShare, nil
+	case multiparty.RelinearizationKeyGenShare, *multiparty.RelinearizationKeyGenShare:
+		return ObjRelinearizationKeyGenShare, nil
+	case multiparty.GaloisKeyGenShare, *multiparty.GaloisKeyGenShare:
+		return ObjGaloisKeyGenShare, nil
+	case multiparty.PublicKeySwitchShare, *multiparty.PublicKeySwitchShare:
+		return ObjPublicKeySwitchShare, nil
+	case multiparty.RefreshShare, *multiparty.RefreshShare:
+		return ObjRefreshShare, nil
+	case rlwe.Ciphertext, *rlwe.Ciphertext:
+		return ObjCiphertext, nil
+	case rlwe.PublicKey, *rlwe.PublicKey:
+		return ObjPublicKey, nil
+	case rlwe.RelinearizationKey, *rlwe.RelinearizationKey:
+		return ObjRelinearizationKey, nil
+	case rlwe.GaloisKey, *rlwe.GaloisKey:
+		return ObjGaloisKey, nil
+	case ExchangeKey, *ExchangeKey:
+		return ObjExchangeKey, nil
+	case SealedShare, *SealedShare:
+		return ObjSealedShare, nil
+	default:
+		return 0, fmt.Errorf("cannot marshal object of type %T", obj)
+	}
+}
+
+// NewObject marshals a share, key or ciphertext produced by the protocol phases
+func NewObject(obj encoding.BinaryMarshaler) (Object, error) {
+	t, err := objectTypeOf(obj)
+	if err != nil {
+		return Object{}, err
+	}
+	data, err := obj.MarshalBinary()
+	return Object{Type: t, Data: data}, err
+}
+
+// Decode unmarshals the object into obj, which must be of the type the object was produced from
+func (o Object) Decode(obj encoding.BinaryUnmarshaler) error {
+	t, err := objectTypeOf(obj)
+	if err != nil {
+		return err
+	}
+	if t != o.Type {
+		return fmt.Errorf("cannot decode %s into %T", o.Type, obj)
+	}
+	return obj.UnmarshalBinary(o.Data)
+}
+
+// WriteMessage writes the message in the wire format
+func WriteMessage(w io.Writer, m *Message) error {
+	fields := m.marshalFields()
+
+	size := uint64(len(fields)) + 4
+	for _, obj := range m.Objects {
+		size += 1 + 8 + uint64(len(obj.Data))
+	}
+	if size > maxBodySize {
+		return fmt.Errorf("message body of %d bytes exceeds the maximum size", size)
+	}
+
+	header := make([]byte, headerSize)
+	header[0] = WireVersion
+	header[1] = uint8(m.Type)
+	copy(header[2:18], m.Session[:])
+	binary.BigEndian.PutUint32(header[18:22], m.Round)
+	binary.BigEndian.PutUint32(header[22:26], uint32(int32(m.PartyID)))
+	binary.BigEndian.PutUint64(header[26:34], size)
+
+	// The objects are written one after the other instead of being copied into a single body
+	bw := bufio.NewWriter(w)
+	bw.Write(header)
+	bw.Write(fields)
+	bw.Write(binary.BigEndian.AppendUint32(nil, uint32(len(m.Objects))))
+	for _, obj := range m.Objects {
+		bw.WriteByte(uint8(obj.Type))
+		bw.Write(binary.BigEndian.AppendUint64(nil, uint64(len(obj.Data))))
+		bw.Write(obj.Data)
+	}
+	return bw.Flush()
+}
+
+// ReadMessage reads one message in the wire format
+func ReadMessage(r io.Reader) (*Message, error) {
+	return readMessage(r, maxBodySize)
+}
+
+// Reads one message whose body is at most maxSize bytes
+func readMessage(r io.Reader, maxSize uint64) (*Message, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	if header[0] != WireVersion {
+		return nil, fmt.Errorf("unsupported wire version %d, expected %d", header[0], WireVersion)
+	}
+
+	m := &Message{Type: MessageType(header[1])}
+	copy(m.Session[:], header[2:18])
+	m.Round = binary.BigEndian.Uint32(header[18:22])
+	m.PartyID = int(int32(binary.BigEndian.Uint32(header[22:26])))
+
+	length := binary.BigEndian.Uint64(header[26:34])
+	if length > maxSize {
+		return nil, fmt.Errorf("message body of %d bytes exceeds the maximum size of %d bytes", length, maxSize)
+	}
+
+	// The buffer grows with the bytes received, not with the length of the header
+	var body bytes.Buffer
+	if _, err := io.CopyN(&body, r, int64(length)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+
+	if err := m.unmarshalBody(body.Bytes()); err != nil {
+		return nil, fmt.Errorf("message type %d from party %d: %w", m.Type, m.PartyID, err)
+	}
+	return m, nil
+}
+
+// MarshalBinary encodes the message in the wire format
+func (m *Message) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	err := WriteMessage(&buf, m)
+	return buf.Bytes(), err
+}
+
+// UnmarshalBinary decodes a message in the wire format
+func (m *Message) UnmarshalBinary(data []byte) error {
+	msg, err := ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	*m = *msg
+	return nil
+}
+
+// BinarySize returns the size in bytes of the message in the wire format
+func (m *Message) BinarySize() int {
+	size := headerSize + len(m.marshalFields()) + 4
+	for _, obj := range m.Objects {
+		size += 1 + 8 + len(obj.Data)
+	}
+	return size
+}
+
+// Marshals the fields of the body that precede the objects
+func (m *Message) marshalFields() []byte {
+	body := make([]byte, 0, 4+4+4+4*len(m.Active)+8+4+len(m.Seed)+4+len(m.Query.Name)+4+8*len(m.Query.Args)+4+len(m.Error))
+	body = binary.BigEndian.AppendUint32(body, uint32(m.NParties))
+	body = binary.BigEndian.AppendUint32(body, uint32(m.Threshold))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(m.Active)))
+	for _, id := range m.Active {
+		body = binary.BigEndian.AppendUint32(body, uint32(id))
+	}
+	body = binary.BigEndian.AppendUint64(body, m.GaloisElement)
+	body = appendBytes(body, m.Seed)
+	body = appendBytes(body, []byte(m.Query.Name))
+	body = binary.BigEndian.AppendUint32(body, uint32(len(m.Query.Args)))
+	for _, arg := range m.Query.Args {
+		body = binary.BigEndian.AppendUint64(body, math.Float64bits(arg))
+	}
+	body = appendBytes(body, []byte(m.Error))
+	return body
+}
+
+func (m *Message) unmarshalBody(body []byte) (err error) {
+	r := &bodyReader{data: body}
+
+	m.NParties = int(r.uint32())
+	m.Threshold = int(r.uint32())
+
+	nActive := r.uint32()
+	if uint64(nActive)*4 > uint64(r.remaining()) {
+		return io.ErrUnexpectedEOF
+	}
+	if nActive > 0 {
+		m.Active = make([]int, nActive)
+		for i := range m.Active {
+			m.Active[i] = int(r.uint32())
+		}
+	}
+
+	m.GaloisElement = r.uint64()
+	m.Seed = r.bytes(uint64(r.uint32()))
+	m.Query.Name = string(r.bytes(uint64(r.uint32())))
+
+	nArgs := r.uint32()
+	if uint64(nArgs)*8 > uint64(r.remaining()) {
+		return io.ErrUnexpectedEOF
+	}
+	if nArgs > 0 {
+		m.Query.Args = make([]float64, nArgs)
+		for i := range m.Query.Args {
+			m.Query.Args[i] = math.Float64frombits(r.uint64())
+		}
+	}
+
+	m.Error = string(r.bytes(uint64(r.uint32())))
+
+	nObjects := r.uint32()
+	if uint64(nObjects)*9 > uint64(r.remaining()) {
+		return io.ErrUnexpectedEOF
+	}
+	if nObjects > 0 {
+		m.Objects = make([]Object, nObjects)
+		for i := range m.Objects {
+			m.Objects[i].Type = ObjectType(r.uint8())
+			m.Objects[i].Data = r.bytes(r.uint64())
+		}
+	}
+
+	if r.err != nil {
+		return r.err
+	}
+	if r.remaining() != 0 {
+		return fmt.Errorf("%d trailing bytes in message body", r.remaining())
+	}
+	return nil
+}
+
+func appendBytes(b, p []byte) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(p)))
+	return append(b, p...)
+}
+
+// Reads the fields of a message body, the first out of bounds read sets err and every later read returns zero values
+type bodyReader struct {
+	data []byte
+	off  int
+	err  error
+}
+
+func (r *bodyReader) remaining() int {
+	return len(r.data) - r.off
+}
+
+func (r *bodyReader) bytes(n uint64) []byte {
+	if r.err != nil || n > uint64(r.remaining()) {
+		r.err = io.ErrUnexpectedEOF
+		return nil
+	}
+	p := r.data[r.off : r.off+int(n)]
+	r.off += int(n)
+	return p
+}
+
+func (r *bodyReader) uint8() uint8 {
+	if p := r.bytes(1); p != nil {
+		return p[0]
+	}
+	return 0
+}
+
+func (r *bodyReader) uint32() uint32 {
+	if p := r.bytes(4); p != nil {
+		return binary.BigEndian.Uint32(p)
+	}
+	return 0
+}
+
+func (r *bodyReader) uint64() uint64 {
+	if p := r.bytes(8); p != nil {
+		return binary.BigEndian.Uint64(p)
+	}
+	return 0
+}
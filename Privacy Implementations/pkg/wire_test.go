@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// Header of a message announcing a body of the length, without the body
+func headerOf(length uint64) []byte {
+	header := make([]byte, headerSize)
+	header[0], header[1] = WireVersion, byte(MsgHello)
+	binary.BigEndian.PutUint64(header[26:34], length)
+	return header
+}
+
+func TestReadMessageBodySize(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		data   []byte
+		limit  uint64
+		expect error
+	}{
+		// Above the limit, rejected from the header alone
+		{"oversized", headerOf(maxBodySize + 1), maxBodySize, nil},
+		{"oversized hello", headerOf(maxHelloSize + 1), maxHelloSize, nil},
+		// A header announcing more bytes than are sent does not allocate them
+		{"truncated", append(headerOf(maxBodySize), 0, 0, 0), maxBodySize, io.ErrUnexpectedEOF},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := readMessage(bytes.NewReader(tc.data), tc.limit)
+			if err == nil || (tc.expect != nil && !errors.Is(err, tc.expect)) {
+				t.Fatalf("read %s message: %v, want %v", tc.name, err, tc.expect)
+			}
+		})
+	}
+
+	// A well formed hello is read back
+	var buf bytes.Buffer
+	if err := WriteMessage(&buf, &Message{Type: MsgHello, PartyID: 3}); err != nil {
+		t.Fatal(err)
+	}
+	m, err := readMessage(&buf, maxHelloSize)
+	if err != nil || m.Type != MsgHello || m.PartyID != 3 {
+		t.Fatalf("read hello: %+v, %v", m, err)
+	}
+}
+
+// A connection that never sends its hello does not hold up the hello of a party that connects after it
+func TestAcceptSilentConnection(t *testing.T) {
+	a, err := Listen(newTestParams(t, 12), "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+
+	silent, err := net.Dial("tcp", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	party, err := net.Dial("tcp", a.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer party.Close()
+	if err = WriteMessage(party, &Message{Type: MsgHello, PartyID: 0}); err != nil {
+		t.Fatal(err)
+	}
+
+	accepted := make(chan error, 1)
+	go func() { accepted <- a.Accept(1) }()
+	select {
+	case err = <-accepted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(helloTimeout / 2):
+		t.Fatal("the silent connection held up the hello of the party")
+	}
+}
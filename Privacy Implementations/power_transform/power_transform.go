@@ -0,0 +1,437 @@
+package main
+
+import (
+	. "encryption/pkg"
+	"flag"
+	"fmt"
+	"math"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
+)
+
+func main() {
+
+	cf := NewCommandFlags(flag.CommandLine)
+	methodName := flag.String("method", "yeo-johnson", "power transform, yeo-johnson or box-cox (positive values only)")
+	lambdaMin := flag.Float64("lambda-min", -3, "lower bound of the lambdas of the first round")
+	lambdaMax := flag.Float64("lambda-max", 3, "upper bound of the lambdas of the first round")
+	gridSize := flag.Int("grid", 13, "number of lambdas evaluated per feature and round")
+	rounds := flag.Int("rounds", 4, "number of rounds, each round refines the grid around the best lambda of the previous one")
+	flag.Parse()
+
+	method, err := PowerMethod(*methodName)
+	if err != nil {
+		panic(err)
+	}
+	if *gridSize < 2 || *rounds < 1 || *lambdaMin >= *lambdaMax {
+		panic("the grid needs at least 2 lambdas, 1 round and lambda-min < lambda-max")
+	}
+
+	// Set encryption parameters for CKKS
+	params, err := cf.Params()
+	if err != nil {
+		panic(err)
+	}
+
+	// The aggregator only knows the features from their names or from -nfeatures
+	NFeatures := cf.NumFeatures(nil)
+
+	// A party process only holds its own data and secret key and answers the aggregator's rounds
+	if cf.Role == "party" {
+		if err = cf.ServeParty(params, GenPowerParty); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	var parties []*Party
+	if cf.Role == "sim" {
+		// Create each party and their secret keys
+		if parties, err = cf.SimParties(params, GenPowerParties); err != nil {
+			panic(err)
+		}
+		NFeatures = cf.NumFeatures(parties)
+
+		// See the parties' inputs
+		PrintZscorePartyInputs(parties)
+	}
+
+	cohort, closeCohort, err := cf.Cohort(params, parties)
+	if err != nil {
+		panic(err)
+	}
+	defer closeCohort()
+
+	session, err := NewSession(params, cohort, DefaultCRSSeed)
+	if err != nil {
+		panic(err)
+	}
+
+	// 1) Collective key generations
+
+	// The sums, sums of squares and numbers of samples of the raw features are packed in one ciphertext per party
+	layout, err := locationLayout(params, NFeatures)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Slot layout: \n%s", layout)
+
+	// Collective Public Key (published to the parties for encrypting their inputs), Relinearization Key, GaloisKeys of the
+	// rotations of the packed statistics and Refresh Protocol
+	if err = session.Setup(layout.GaloisElements(params)...); err != nil {
+		panic(err)
+	}
+
+	// Threshold mode: the secret keys are shared so that the t active parties alone can decrypt and refresh
+	if err = cf.Thresholdize(session); err != nil {
+		panic(err)
+	}
+
+	// 2) to 4) Lambda of each feature, and mean and standard deviation of the transformed features, computed again
+	// without the parties that drop on the way so that they aggregate the same parties
+	var lambdas, mean, std []float64
+	contributors, err := session.Consistent(func() error {
+		var err error
+		lambdas, mean, std, err = fitPowerTransform(session, method, layout, *lambdaMin, *lambdaMax, *gridSize, *rounds)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Results:\n")
+	fmt.Printf("Lambda: ")
+	PrintValues(lambdas)
+	fmt.Printf("Mean: ")
+	PrintValues(mean)
+	fmt.Printf("Std: ")
+	PrintValues(std)
+
+	fmt.Printf("\n")
+	fmt.Printf("Contributors: %v\n", contributors)
+	PrintReleases(session.Releases)
+
+	// The aggregator does not hold the parties' data in the networked mode
+	if parties != nil {
+		// Validation against the lambda, mean and standard deviation of the pooled data of the contributors in plaintext
+		expectedLambda, expectedMean, expectedStd := pooledPowerTransform(PartiesOf(parties, contributors), method, *lambdaMin, *lambdaMax)
+
+		fmt.Printf("\n")
+		fmt.Printf("Validation:\n")
+		fmt.Printf("Lambda: ")
+		PrintValues(expectedLambda)
+		fmt.Printf("Mean: ")
+		PrintValues(expectedMean)
+		fmt.Printf("Std: ")
+		PrintValues(expectedStd)
+
+		session.Metrics.RecordPrecision("lambda", lambdas, expectedLambda)
+		session.Metrics.RecordPrecision("mean", mean, expectedMean)
+		session.Metrics.RecordPrecision("std", std, expectedStd)
+
+		fmt.Printf("\n")
+		fmt.Printf("Precision:\n")
+		PrintPrecision(session.Metrics.Precision)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Metrics:\n")
+	PrintMetrics(session.Metrics)
+	if err = cf.WriteMetrics(session.Metrics); err != nil {
+		panic(err)
+	}
+}
+
+// Grid search of the lambda of each feature in rounds of gridSize lambdas between lambdaMin and lambdaMax, then mean and
+// standard deviation of the features transformed with their lambda. The layout is the one of locationLayout.
+//
+// The decryptions reveal, for every feature: the mean and the variance of the raw values and the mean of their log terms
+// (see PowerLogTerm), the variances of the normalized transformed values at each of the gridSize lambdas of every round,
+// which give the log-likelihood over the whole grids and not only their best lambda, and the mean of the transformed
+// values at the last best lambda only. The grids sent to the parties are computed from these.
+func fitPowerTransform(session *Session, method int, layout *SlotLayout, lambdaMin, lambdaMax float64, gridSize, rounds int) (lambdas []float64, mean []float64, std []float64, err error) {
+
+	NFeatures := layout.NFeatures
+
+	// 2) Location and scale of the raw features, and mean of the log terms of the log-likelihood
+	center, scale, meanLogTerms, err := locationScale(session, method, layout)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// 3) Grid search of the lambda maximizing the log-likelihood of each feature, the grid is refined around the best lambda at each round
+	lo := make([]float64, NFeatures)
+	hi := make([]float64, NFeatures)
+	for j := range lo {
+		lo[j], hi[j] = lambdaMin, lambdaMax
+	}
+
+	var grid *PowerGrid
+	var best []int
+	var transformedMean *rlwe.Ciphertext
+	var transformedVariance []float64
+	var countInverse *rlwe.Ciphertext
+
+	for round := 0; round < rounds; round++ {
+
+		grid = NewPowerGrid(method, lo, hi, gridSize, center, scale)
+
+		fmt.Printf("\n")
+		fmt.Printf("Grid Search Round %d... \n", round+1)
+
+		// The counts of the grid are the same at every round
+		if countInverse == nil {
+			countCiphertexts, err := session.Input(Query{Name: QueryPowerCount, Args: grid.Args()})
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			if countInverse, err = session.CountInverse(countCiphertexts); err != nil {
+				return nil, nil, nil, err
+			}
+		}
+
+		if transformedMean, transformedVariance, err = gridRound(session, grid, countInverse); err != nil {
+			return nil, nil, nil, err
+		}
+
+		best = bestLambdas(grid, transformedVariance, meanLogTerms)
+
+		// The next grid spans the neighbours of the best lambda, within the lambdas of the first round
+		for j := range lo {
+			step := (hi[j] - lo[j]) / float64(gridSize-1)
+			lambda := grid.Lambdas[j][best[j]]
+			lo[j], hi[j] = math.Max(lambda-step, lambdaMin), math.Min(lambda+step, lambdaMax)
+		}
+	}
+
+	// 4) Decryption of the mean of the transformed features at their best lambda only
+	meanValues, err := revealBest(session, grid, best, transformedMean)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	G := grid.Size()
+	lambdas = make([]float64, NFeatures)
+	mean = make([]float64, NFeatures)
+	std = make([]float64, NFeatures)
+	for j := 0; j < NFeatures; j++ {
+		k := j*G + best[j]
+		shift, factor := grid.Normalization(j, best[j])
+
+		lambdas[j] = grid.Lambdas[j][best[j]]
+		mean[j] = shift + meanValues[k]/factor
+		std[j] = math.Sqrt(math.Max(transformedVariance[k], 0)) / math.Abs(factor)
+	}
+
+	return lambdas, mean, std, nil
+}
+
+// Mean and standard deviation of the raw features and mean of the log terms of the log-likelihood.
+// They are revealed: the transformed values are normalized with the mean and the standard deviation,
+// the log terms are part of the log-likelihood of every lambda.
+func locationScale(session *Session, method int, layout *SlotLayout) (center []float64, scale []float64, meanLogTerms []float64, err error) {
+
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Location and Scale of the Features... \n")
+
+	NFeatures := layout.NFeatures
+
+	packedCiphertexts, err := session.InputLayout(layout)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	logSumCiphertexts, err := session.Input(Query{Name: QueryPowerLogSum, Args: []float64{float64(method)}})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The statistics are moved to the slots of the features, the slots past the features count one sample of value 0
+	total, err := session.Aggregate(packedCiphertexts[0]...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sums, err := session.Extract(layout, total, StatSum, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	sumSquares, err := session.Extract(layout, total, StatSumSquares, 0)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	counts, err := session.Extract(layout, total, StatCount, 1)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	countInverse, err := session.CountInverse([]*rlwe.Ciphertext{counts})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	mean, err := session.Average([]*rlwe.Ciphertext{sums}, countInverse)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	variance, err := session.VarianceFromSquares([]*rlwe.Ciphertext{sumSquares}, mean, countInverse)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	meanLog, err := session.Average(logSumCiphertexts, countInverse)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	meanValues, err := session.RevealValues(mean)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	varianceValues, err := session.RevealValues(variance)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	meanLogValues, err := session.RevealValues(meanLog)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	center = meanValues[:NFeatures]
+	scale = make([]float64, NFeatures)
+	for j := range scale {
+		// A constant feature is normalized by 1, its log-likelihood is flat in lambda
+		scale[j] = math.Sqrt(math.Max(varianceValues[j], 0))
+		if scale[j] < 1e-6 {
+			scale[j] = 1
+		}
+	}
+
+	return center, scale, meanLogValues[:NFeatures], nil
+}
+
+// One round of the grid search: each party submits the sums and sums of squares of its normalized transformed values
+// for every lambda of the grid, the aggregator computes their mean and variance and reveals the variances
+func gridRound(session *Session, grid *PowerGrid, countInverse *rlwe.Ciphertext) (mean *rlwe.Ciphertext, varianceValues []float64, err error) {
+
+	sumCiphertexts, err := session.Input(Query{Name: QueryPowerSum, Args: grid.Args()})
+	if err != nil {
+		return nil, nil, err
+	}
+	sumSquaresCiphertexts, err := session.Input(Query{Name: QueryPowerSumSquares, Args: grid.Args()})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if mean, err = session.Average(sumCiphertexts, countInverse); err != nil {
+		return nil, nil, err
+	}
+	variance, err := session.VarianceFromSquares(sumSquaresCiphertexts, mean, countInverse)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if varianceValues, err = session.RevealValues(variance); err != nil {
+		return nil, nil, err
+	}
+
+	return mean, varianceValues, nil
+}
+
+// Index of the lambda of largest log-likelihood in the grid of each feature
+func bestLambdas(grid *PowerGrid, varianceValues []float64, meanLogTerms []float64) []int {
+	G := grid.Size()
+
+	best := make([]int, len(grid.Lambdas))
+	for j := range best {
+		bestLL := math.Inf(-1)
+		for g := 0; g < G; g++ {
+			if ll := grid.LogLikelihood(j, g, varianceValues[j*G+g], meanLogTerms[j]); ll > bestLL {
+				best[j], bestLL = g, ll
+			}
+		}
+	}
+	return best
+}
+
+// Reveals the slots of the best lambdas of ct, the other slots are multiplied by 0 before the decryption
+func revealBest(session *Session, grid *PowerGrid, best []int, ct *rlwe.Ciphertext) ([]float64, error) {
+
+	eval := ckks.NewEvaluator(session.Params, session.Evk)
+
+	mask := make([]float64, session.Params.MaxSlots())
+	for j, g := range best {
+		mask[j*grid.Size()+g] = 1
+	}
+
+	masked, err := eval.MulNew(ct, mask)
+	if err != nil {
+		return nil, err
+	}
+	if err = eval.Rescale(masked, masked); err != nil {
+		return nil, err
+	}
+
+	return session.RevealValues(masked)
+}
+
+// Lambda maximizing the log-likelihood of the pooled data of every feature, by a search of step 0.001 in [lambdaMin, lambdaMax],
+// and mean and standard deviation of the transformed feature
+func pooledPowerTransform(parties []*Party, method int, lambdaMin, lambdaMax float64) (lambdas []float64, mean []float64, std []float64) {
+
+	pooled := PooledSamples(parties)
+
+	lambdas = make([]float64, len(pooled))
+	mean = make([]float64, len(pooled))
+	std = make([]float64, len(pooled))
+
+	for j, samples := range pooled {
+		logTerm := 0.0
+		for _, x := range samples {
+			logTerm += PowerLogTerm(method, x)
+		}
+		logTerm /= float64(len(samples))
+
+		bestLL := math.Inf(-1)
+		for i := 0; lambdaMin+float64(i)*0.001 <= lambdaMax; i++ {
+			lambda := lambdaMin + float64(i)*0.001
+			_, variance := meanVariance(samples, method, lambda)
+			if ll := -0.5*math.Log(variance) + (lambda-1)*logTerm; ll > bestLL {
+				lambdas[j], bestLL = lambda, ll
+			}
+		}
+
+		var variance float64
+		mean[j], variance = meanVariance(samples, method, lambdas[j])
+		std[j] = math.Sqrt(variance)
+	}
+
+	return lambdas, mean, std
+}
+
+// Mean and variance of the transformed samples
+func meanVariance(samples []float64, method int, lambda float64) (mean float64, variance float64) {
+	for _, x := range samples {
+		mean += PowerTransform(method, lambda, x)
+	}
+	mean /= float64(len(samples))
+
+	for _, x := range samples {
+		d := PowerTransform(method, lambda, x) - mean
+		variance += d * d
+	}
+	variance /= float64(len(samples))
+
+	return mean, variance
+}
+
+// Slot layout of the location and scale of the raw features: their sums, sums of squares and numbers of samples
+func locationLayout(params ckks.Parameters, NFeatures int) (*SlotLayout, error) {
+	layout := NewSlotLayout(params.MaxSlots(), NFeatures)
+	for _, stat := range []string{StatSum, StatSumSquares, StatCount} {
+		if err := layout.Add(stat); err != nil {
+			return nil, err
+		}
+	}
+	return layout, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	. "encryption/pkg"
+	"encryption/pkg/pkgtest"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Errors tolerated on the lambdas, which the grid search only approaches, and relative errors tolerated on the mean and
+// standard deviation of the features transformed with the lambdas found
+const (
+	lambdaTolerance = 5e-2
+	momentTolerance = 1e-4
+)
+
+// Both power transforms against the plaintext search of the lambdas, for 2 to 4 generated parties. The lambdas of the
+// skewed generated features lie below 1, the range of 1 to 2 keeps the refined grids at its bound.
+func TestPowerTransform(t *testing.T) {
+	for _, tc := range []struct {
+		methodName           string
+		lambdaMin, lambdaMax float64
+		N                    int
+		short                bool
+	}{
+		{"yeo-johnson", -3, 3, 2, true},
+		{"box-cox", -3, 3, 3, false},
+		{"yeo-johnson", 1, 2, 4, false},
+	} {
+		t.Run(fmt.Sprintf("%s/lambdas=[%v,%v]/parties=%d", tc.methodName, tc.lambdaMin, tc.lambdaMax, tc.N), func(t *testing.T) {
+			pkgtest.SkipLong(t, tc.short)
+			method, err := PowerMethod(tc.methodName)
+			if err != nil {
+				t.Fatal(err)
+			}
+			params := pkgtest.Params(t, 12)
+			parties := GenPowerParties(params, tc.N)
+			layout, err := locationLayout(params, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			session := pkgtest.NewSession(t, params, parties, layout.GaloisElements(params)...)
+
+			// Default grid of the command
+			lambdas, mean, std, err := fitPowerTransform(session, method, layout, tc.lambdaMin, tc.lambdaMax, 13, 4)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for j, lambda := range lambdas {
+				if lambda < tc.lambdaMin || lambda > tc.lambdaMax {
+					t.Errorf("lambda %v of feature %d, want within [%v, %v]", lambda, j, tc.lambdaMin, tc.lambdaMax)
+				}
+			}
+
+			expectedLambda, _, _ := pooledPowerTransform(parties, method, tc.lambdaMin, tc.lambdaMax)
+			pkgtest.CheckAbsolute(t, "lambda", lambdas, expectedLambda, lambdaTolerance)
+
+			// The mean and standard deviation are checked at the lambdas of the protocol, so that they do not carry the
+			// error of the grid search
+			pooled := PooledSamples(parties)
+			expectedMean := make([]float64, len(pooled))
+			expectedStd := make([]float64, len(pooled))
+			for j, samples := range pooled {
+				var variance float64
+				expectedMean[j], variance = meanVariance(samples, method, lambdas[j])
+				expectedStd[j] = math.Sqrt(variance)
+			}
+			pkgtest.CheckRelative(t, "mean", mean, expectedMean, momentTolerance)
+			pkgtest.CheckRelative(t, "std", std, expectedStd, momentTolerance)
+		})
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	. "encryption/pkg"
+	"fmt"
+	"math"
+)
+
+// Finds approximate percentiles of every feature from the histograms of the parties: each party encrypts the counts of
+// its values in B buckets of [min, max) for every feature, the aggregator sums them and decrypts the histogram once.
+// The percentiles are read from the cumulative counts, the values being assumed evenly spread within a bucket, and
+// interpolate linearly between the two ranks around them as numpy.percentile. With refine, a second round counts the
+// values in B buckets of the interval between the buckets of these two ranks, for every percentile.
+func findPercentilesHistogram(session *Session, Percentiles []float64, NFeatures int, totalNoSamples []int64, min []float64, max []float64, B int, refine bool) ([]float64, error) {
+
+	NSlots := len(Percentiles) * NFeatures
+
+	// 1) One histogram per feature, the upper bound is excluded so it is moved just above the max
+	lo := make([]float64, NFeatures)
+	hi := make([]float64, NFeatures)
+	for i := 0; i < NFeatures; i++ {
+		lo[i], hi[i] = min[i], math.Nextafter(max[i], math.Inf(1))
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Histograms... \n")
+	counts, err := histogramRound(session, lo, hi, B)
+	if err != nil {
+		return nil, err
+	}
+
+	// Rank interval of each percentile, k is the 1-based rank below it and fraction its distance to k
+	k := make([]int64, NSlots)
+	fraction := make([]float64, NSlots)
+	for t, percentile := range Percentiles {
+		for i := 0; i < NFeatures; i++ {
+			s := t*NFeatures + i
+			pos := percentile / 100.0 * float64(totalNoSamples[s]-1)
+			k[s] = int64(math.Floor(pos)) + 1
+			fraction[s] = pos - math.Floor(pos)
+		}
+	}
+
+	results := make([]float64, NSlots)
+	refinedLo := make([]float64, NSlots)
+	refinedHi := make([]float64, NSlots)
+	before := make([]int64, NSlots)
+	for t := range Percentiles {
+		for i := 0; i < NFeatures; i++ {
+			s := t*NFeatures + i
+			hist := counts[i*B : (i+1)*B]
+
+			results[s] = histogramPercentile(hist, lo[i], hi[i], 0, k[s], fraction[s])
+
+			// The refinement interval spans the buckets of the ranks k and k+1
+			bk, cum := rankBucket(hist, 0, k[s])
+			bk1, _ := rankBucket(hist, 0, k[s]+1)
+			width := (hi[i] - lo[i]) / float64(B)
+			refinedLo[s], refinedHi[s] = lo[i]+width*float64(bk), lo[i]+width*float64(bk1+1)
+			before[s] = cum
+		}
+	}
+
+	if !refine {
+		return results, nil
+	}
+
+	// 2) Histograms of the refinement intervals, one per percentile and feature
+	fmt.Printf("\n")
+	fmt.Printf("Refining the Histograms... \n")
+	refinedCounts, err := histogramRound(session, refinedLo, refinedHi, B)
+	if err != nil {
+		return nil, err
+	}
+
+	for s := 0; s < NSlots; s++ {
+		results[s] = histogramPercentile(refinedCounts[s*B:(s+1)*B], refinedLo[s], refinedHi[s], before[s], k[s], fraction[s])
+	}
+
+	return results, nil
+}
+
+// Histograms of B buckets over the intervals [lo, hi) of the features in all parties, the total counts are decrypted.
+// Interval r is an interval of feature r % NFeatures, bucket b of interval r is in slot r*B + b.
+func histogramRound(session *Session, lo []float64, hi []float64, B int) ([]int64, error) {
+
+	args := append([]float64{float64(B)}, lo...)
+	args = append(args, hi...)
+
+	histogramCiphertexts, err := session.Input(Query{Name: QueryRobustHistogram, Args: args})
+	if err != nil {
+		return nil, err
+	}
+
+	totalHistogramCiphertext, err := session.Aggregate(histogramCiphertexts...)
+	if err != nil {
+		return nil, err
+	}
+
+	totalHistogramValues, err := session.RevealValues(totalHistogramCiphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make([]int64, len(lo)*B)
+	for i := range counts {
+		counts[i] = int64(math.Round(totalHistogramValues[i]))
+	}
+
+	return counts, nil
+}
+
+// Percentile between the ranks k and k+1 of the values counted by hist over [lo, hi), before values being below lo
+func histogramPercentile(hist []int64, lo float64, hi float64, before int64, k int64, fraction float64) float64 {
+	value := histogramRank(hist, lo, hi, before, k)
+	if fraction > 0 {
+		value += fraction * (histogramRank(hist, lo, hi, before, k+1) - value)
+	}
+	return value
+}
+
+// Estimate of the k-th element, the c values of its bucket being evenly spread at the centers of c equal sub-intervals
+func histogramRank(hist []int64, lo float64, hi float64, before int64, k int64) float64 {
+	b, cum := rankBucket(hist, before, k)
+	width := (hi - lo) / float64(len(hist))
+	if hist[b] == 0 {
+		return lo + width*(float64(b)+0.5)
+	}
+	position := (float64(k-cum) - 0.5) / float64(hist[b])
+	return lo + width*(float64(b)+math.Min(math.Max(position, 0), 1))
+}
+
+// Bucket of the k-th element and number of values below the bucket, the last bucket if the histogram holds less than k values
+func rankBucket(hist []int64, before int64, k int64) (int, int64) {
+	cum := before
+	for b, count := range hist {
+		if cum+count >= k {
+			return b, cum
+		}
+		if b < len(hist)-1 {
+			cum += count
+		}
+	}
+	return len(hist) - 1, cum
+}
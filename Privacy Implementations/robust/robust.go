@@ -2,174 +2,426 @@ package main
 
 import (
 	. "encryption/pkg"
+	"flag"
 	"fmt"
 	"math"
-	"sort"
 	"time"
 
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/comparison"
+	"github.com/tuneinsight/lattigo/v6/circuits/ckks/minimax"
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
-	"github.com/tuneinsight/lattigo/v6/utils/sampling"
 )
 
+// Default public bound of the absolute values of the features for the comparisons of the min and max
+const defaultBound = 10000.0
 
 func main() {
-	
-	// Set encryption parameters for CKKS
-	var err error
-	var params ckks.Parameters
-
-	if params, err = ckks.NewParametersFromLiteral(
-		ckks.ParametersLiteral{
-			LogN: 15,                                     			  				 // log2(ring degree)
-			LogQ: []int{55, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45}, // log2(primes Q) (ciphertext modulus)
-			LogP:            []int{61},                             				 // log2(primes P) (auxiliary modulus)
-			LogDefaultScale: 45,
-		}); err != nil {
+
+	cf := NewCommandFlags(flag.CommandLine)
+	quantileRange := flag.String("quantile-range", "25,75", "comma separated lower and upper percentiles of the scale, as the quantile_range of RobustScaler")
+	mode := flag.String("mode", "bisection", "bisection (one round per halving of the search intervals) or histogram (approximate percentiles from one round of histograms)")
+	buckets := flag.Int("buckets", 64, "number of buckets of the histograms, for -mode histogram")
+	refine := flag.Bool("refine", false, "refine the histogram percentiles with a second round of histograms of the buckets they fall in, for -mode histogram")
+	hiddenCounts := flag.Bool("hidden-counts", false, "compare the aggregated counts of each round with the searched rank under encryption and only decrypt one comparison bit per percentile, instead of the counts, and with -exact the average of the final interval instead of its sum and count")
+	boundList := flag.String("bounds", "", "comma separated public bound of the absolute values of each feature for the secure min and max, 10000 for every feature if empty")
+	exact := flag.Bool("exact", false, "bisect until the interval holds the ranked element alone and reveal its value, percentiles between two ranks interpolate linearly as numpy.percentile, instead of returning a midpoint")
+	maxRounds := flag.Int("max-rounds", 40, "maximum number of bisection rounds, unlimited if 0")
+	margin := flag.Float64("margin", 0.01, "fraction of the range max - min by which the search interval is widened on each side")
+	flag.Parse()
+
+	bounds, err := ParseValues(*boundList)
+	if err != nil {
 		panic(err)
 	}
 
+	if *margin < 0 {
+		panic(fmt.Sprintf("negative margin %v", *margin))
+	}
+	if *mode != "bisection" && *mode != "histogram" {
+		panic(fmt.Sprintf("unknown mode %q", *mode))
+	}
+	if *mode == "histogram" && (*exact || *hiddenCounts || *buckets < 1) {
+		panic("-mode histogram needs a positive number of buckets and excludes -exact and -hidden-counts")
+	}
 
-	crs, err := sampling.NewKeyedPRNG([]byte{'l', 'a', 't', 't', 'i', 'g', 'o'})
+	qRange, err := ParseValues(*quantileRange)
 	if err != nil {
 		panic(err)
 	}
+	if len(qRange) != 2 || !(0 <= qRange[0] && qRange[0] < qRange[1] && qRange[1] <= 100) {
+		panic(fmt.Sprintf("invalid quantile range %q", *quantileRange))
+	}
 
-	// Number of parties
-	N := 4
-	NFeatures := 4
-	Percentile := 50.0
+	// Set encryption parameters for CKKS
+	params, err := cf.Params()
+	if err != nil {
+		panic(err)
+	}
 
-	// Create each party and their secret keys
-	parties := GenRobustParties(params, N, NFeatures)
+	// The aggregator only knows the features from their names or from -nfeatures
+	NFeatures := cf.NumFeatures(nil)
 
-	// See the parties' inputs
-	PrintRobustPartyInputs(parties)
+	// The median gives the center and the quantile range the scale, the three ranks are searched in the same rounds
+	Percentiles := []float64{50.0, qRange[0], qRange[1]}
 
-	// 1) Collective key generations
+	// A party process only holds its own data and secret key and answers the aggregator's rounds
+	if cf.Role == "party" {
+		gen := func(params ckks.Parameters, i int) *Party { return GenRobustParty(params, i, NFeatures) }
+		if err = cf.ServeParty(params, gen); err != nil {
+			panic(err)
+		}
+		return
+	}
 
-	// Collective Public Key
-	pk := CollectiveKeyGen(params, crs, parties)
+	var parties []*Party
+	if cf.Role == "sim" {
+		// Create each party and their secret keys
+		gen := func(params ckks.Parameters, N int) []*Party { return GenRobustParties(params, N, NFeatures) }
+		if parties, err = cf.SimParties(params, gen); err != nil {
+			panic(err)
+		}
+		NFeatures = cf.NumFeatures(parties)
 
-	// Collective Relinearization Key
-	rlk := RelinearizationKeyGeneration(params, crs, parties)
+		// See the parties' inputs
+		PrintRobustPartyInputs(parties)
+	}
 
-	// Evaluation Key
-	evk := rlwe.NewMemEvaluationKeySet(rlk)
+	cohort, closeCohort, err := cf.Cohort(params, parties)
+	if err != nil {
+		panic(err)
+	}
+	defer closeCohort()
 
-	// 2) Encrypting the input number of samples
-	numberOfSamplesCiphertexts := EncryptRobustSampleValues(params, pk, parties)
+	session, err := NewSession(params, cohort, DefaultCRSSeed)
+	if err != nil {
+		panic(err)
+	}
 
+	// 1) Collective key generations
 
-	fmt.Printf("\n")
-	fmt.Printf("Finding Total No Of Samples... \n")
-	noOfSamples := encryptedSum(params, evk, numberOfSamplesCiphertexts)
+	// Collective Public Key (published to the parties for encrypting their inputs), Relinearization Key, GaloisKey for the
+	// complex conjugation of the comparisons and Refresh Protocol
+	if err = session.Setup(params.GaloisElementForComplexConjugation()); err != nil {
+		panic(err)
+	}
 
-	tsk, tpk := rlwe.NewKeyGenerator(params).GenKeyPairNew()
-	noOfSamplesValues := CollectiveDecryption(params, tsk, noOfSamples, tpk, parties)
+	// Threshold mode: the secret keys are shared so that the t active parties alone can decrypt and refresh
+	if err = cf.Thresholdize(session); err != nil {
+		panic(err)
+	}
 
-	fmt.Printf("\n")
-	fmt.Printf("Total No Of Samples: \n")
-	intNoOfSamplesValues := make([]int64, NFeatures)
-	for i := 0; i < NFeatures; i++ {
-		intNoOfSamplesValues[i] = int64(math.Round(noOfSamplesValues[i]))
-		fmt.Printf("%d ", intNoOfSamplesValues[i])
+
+	// 2) Number of samples, global min and global max of each feature, packed in one ciphertext per party
+	// The min and max are computed with the secure minmax protocol, they bound the search of the percentiles
+	if len(bounds) == 0 {
+		bounds = make([]float64, NFeatures)
+		for i := range bounds {
+			bounds[i] = defaultBound
+		}
+	}
+	if len(bounds) < NFeatures {
+		panic(fmt.Sprintf("%d bounds for %d features", len(bounds), NFeatures))
+	}
+
+	layout := NewSlotLayout(params.MaxSlots(), NFeatures)
+	for _, stat := range []string{StatCount, StatMin, StatMax} {
+		if err = layout.Add(stat); err != nil {
+			panic(err)
+		}
 	}
+
 	fmt.Printf("\n")
+	fmt.Printf("Slot layout: \n%s", layout)
+
+	// Each percentile of each feature is searched in its own slot, slot t*NFeatures + i holds the t-th percentile of feature i
+	// With -exact, the two ranks around each percentile are searched
+	NSlots := len(Percentiles) * NFeatures
+	usedSlots := NSlots
+	switch {
+	case *exact:
+		usedSlots = 2 * NSlots
+	case *mode == "histogram" && *refine:
+		usedSlots = NSlots * *buckets
+	case *mode == "histogram":
+		usedSlots = NFeatures * *buckets
+	}
+	if usedSlots > params.MaxSlots() {
+		panic(fmt.Errorf("%w: %d percentiles of %d features for %d slots", ErrEncodingOverflow, len(Percentiles), NFeatures, params.MaxSlots()))
+	}
 
+	// The statistics are computed again without the parties that drop on the way, so that they aggregate the same parties
+	var results []float64
+	var timeCalculated time.Duration
+	contributors, err := session.Consistent(func() error {
+		noOfSamplesValues, minValues, maxValues, err := countMinMax(session, layout, bounds)
+		if err != nil {
+			return err
+		}
 
-	// Small epsilon to define floating-point precision, one can decide the compute limit based on the application. It can be different for each feature's requirements
-	epsilon := make([]float64, NFeatures)
+		fmt.Printf("\n")
+		fmt.Printf("Global Min: ")
+		PrintValues(minValues)
+		fmt.Printf("Global Max: ")
+		PrintValues(maxValues)
 
-	// We can assume that we calculated the global min and max values from the minmax.go
-	globalMin := make([]float64, NFeatures)
-	globalMax := make([]float64, NFeatures)
+		fmt.Printf("\n")
+		fmt.Printf("Total No Of Samples: \n")
+		intNoOfSamplesValues := make([]int64, NFeatures)
+		for i := 0; i < NFeatures; i++ {
+			intNoOfSamplesValues[i] = int64(math.Round(noOfSamplesValues[i]))
+			fmt.Printf("%d ", intNoOfSamplesValues[i])
 
-	for i := 0; i < NFeatures; i++ {
-		globalMin[i] = -2.0
-		globalMax[i] = 2.0
-		epsilon[i] = 0.000001
-	}
+			// The slots past the parties' features are empty, the aggregator expects more features than they hold
+			if intNoOfSamplesValues[i] < 1 {
+				return fmt.Errorf("feature %d has no values, the parties hold fewer than %d features", i, NFeatures)
+			}
+		}
+		fmt.Printf("\n")
 
 
-	// Finding the median's index for each feature, this can be changed to %75 or %25 for other robust scaling values
-	k := make([]int64, NFeatures)
-	isValidIndex := make([]bool, NFeatures)
-	for i := 0; i < NFeatures; i++ {
-		// Dividing the number of samples by 2 to find median index
-		tempK := 1 + float64(Percentile / 100.0) * float64(intNoOfSamplesValues[i] - 1)
-		
-		if tempK == math.Floor(tempK){
-			k[i] = int64(tempK)
-			isValidIndex[i] = true
-		} else {
-			k[i] = int64(math.Floor(tempK))
-			isValidIndex[i] = false
+		// Small epsilon to define floating-point precision, one can decide the compute limit based on the application. It can be different for each feature's requirements
+		epsilon := make([]float64, NSlots)
+
+		// The search interval of each feature is its global min and max widened by the margin, which covers the approximation
+		// error of the comparisons
+		globalMin := make([]float64, NSlots)
+		globalMax := make([]float64, NSlots)
+
+		for i := 0; i < NSlots; i++ {
+			widening := *margin * (maxValues[i%NFeatures] - minValues[i%NFeatures])
+			globalMin[i] = minValues[i%NFeatures] - widening
+			globalMax[i] = maxValues[i%NFeatures] + widening
+			epsilon[i] = 0.000001
+		}
+
+
+		// Finding the index of each percentile for each feature
+		k := make([]int64, NSlots)
+		isValidIndex := make([]bool, NSlots)
+		totalNoSamples := make([]int64, NSlots)
+		for t, percentile := range Percentiles {
+			for i := 0; i < NFeatures; i++ {
+				s := t*NFeatures + i
+				totalNoSamples[s] = intNoOfSamplesValues[i]
+
+				// 1-based rank of the percentile, e.g. 1 + (n-1)/2 for the median
+				tempK := 1 + float64(percentile / 100.0) * float64(intNoOfSamplesValues[i] - 1)
+
+				if tempK == math.Floor(tempK){
+					k[s] = int64(tempK)
+					isValidIndex[s] = true
+				} else {
+					k[s] = int64(math.Floor(tempK))
+					isValidIndex[s] = false
+				}
+			}
 		}
 
+		// Finding the percentiles
+		start := time.Now()
+		fmt.Printf("\nFinding the k-th elements... \n")
+		if *mode == "histogram" {
+			results, err = findPercentilesHistogram(session, Percentiles, NFeatures, totalNoSamples, globalMin, globalMax, *buckets, *refine)
+		} else if *exact {
+			results, err = findPercentilesExact(session, Percentiles, NFeatures, totalNoSamples, globalMin, globalMax, epsilon, *maxRounds, *hiddenCounts)
+		} else {
+			results, err = findKthElement(session, k, NFeatures, globalMin, globalMax, epsilon, totalNoSamples, isValidIndex, *maxRounds, *hiddenCounts)
+		}
+		if err != nil {
+			return err
+		}
+		timeCalculated = time.Since(start)
+		return nil
+	})
+	if err != nil {
+		panic(err)
 	}
 
-	// Finding the medians 
-	start := time.Now()
-	fmt.Printf("\nFinding the k-th element... \n")
-	results := findKthElement(params, pk, evk, k, NFeatures, globalMin, globalMax, epsilon, intNoOfSamplesValues, parties, isValidIndex)
+	// center = median, scale = Q3 - Q1
+	center := results[:NFeatures]
+	scale := make([]float64, NFeatures)
+	for i := range scale {
+		scale[i] = results[2*NFeatures+i] - results[NFeatures+i]
+	}
 
 	fmt.Printf("\n")
 	fmt.Printf("Results: \n")
+	for t, percentile := range Percentiles {
+		fmt.Printf("Percentile %v: ", percentile)
+		for i := 0; i < NFeatures; i++ {
+			fmt.Printf("%2.8f ", results[t*NFeatures+i])
+		}
+		fmt.Printf("\n")
+	}
+	fmt.Printf("Center: ")
 	for i := 0; i < NFeatures; i++ {
-		fmt.Printf("%2.8f ", results[i])
+		fmt.Printf("%2.8f ", center[i])
+	}
+	fmt.Printf("\n")
+	fmt.Printf("Scale: ")
+	for i := 0; i < NFeatures; i++ {
+		fmt.Printf("%2.8f ", scale[i])
 	}
-	timeCalculated := time.Since(start)
 	fmt.Printf("\n")
 	fmt.Printf("%s\n", timeCalculated)
 
-
-	fmt.Printf("\n")
 	fmt.Printf("\n")
-	fmt.Printf("Validation:")
-	validationResults := validationArrays(parties, NFeatures)
-	for i := 0; i < NFeatures; i++ {
+	fmt.Printf("Contributors: %v\n", contributors)
+	PrintReleases(session.Releases)
+
+	// The aggregator does not hold the parties' data in the networked mode
+	if parties != nil {
+		// Validation against the percentiles of the pooled data of the contributors in plaintext. The percentiles of numpy
+		// interpolate linearly between the two closest ranks, without -exact the search only brackets the percentiles
+		// that fall between two ranks
+		contributing := PartiesOf(parties, contributors)
+		expected := make([]float64, 0, len(Percentiles)*NFeatures)
+		for _, percentile := range Percentiles {
+			expected = append(expected, PooledPercentile(contributing, percentile)...)
+		}
+		expectedCenter := expected[:NFeatures]
+		expectedScale := make([]float64, NFeatures)
+		for i := range expectedScale {
+			expectedScale[i] = expected[2*NFeatures+i] - expected[NFeatures+i]
+		}
+
 		fmt.Printf("\n")
-		fmt.Printf("Feature %d: \n", i)
-		for _, val := range validationResults[i] {
-			fmt.Printf("%2.8f ", val)
+		fmt.Printf("Validation:\n")
+		for t, percentile := range Percentiles {
+			fmt.Printf("Percentile %v: ", percentile)
+			for i := 0; i < NFeatures; i++ {
+				fmt.Printf("%2.8f ", expected[t*NFeatures+i])
+			}
+			fmt.Printf("\n")
 		}
-	}
+		fmt.Printf("Center: ")
+		for i := 0; i < NFeatures; i++ {
+			fmt.Printf("%2.8f ", expectedCenter[i])
+		}
+		fmt.Printf("\n")
+		fmt.Printf("Scale: ")
+		for i := 0; i < NFeatures; i++ {
+			fmt.Printf("%2.8f ", expectedScale[i])
+		}
+		fmt.Printf("\n")
+
+		session.Metrics.RecordPrecision("percentiles", results[:len(expected)], expected)
+		session.Metrics.RecordPrecision("center", center, expectedCenter)
+		session.Metrics.RecordPrecision("scale", scale, expectedScale)
 
+		fmt.Printf("\n")
+		fmt.Printf("Precision:\n")
+		PrintPrecision(session.Metrics.Precision)
+	}
 
+	fmt.Printf("\n")
+	fmt.Printf("Metrics:\n")
+	PrintMetrics(session.Metrics)
+	if err = cf.WriteMetrics(session.Metrics); err != nil {
+		panic(err)
+	}
 }
 
+// Total number of samples, global min and global max of every feature. Each party sends one ciphertext with the three
+// statistics of the layout, the counts are summed and the min and max are compared under encryption as in the minmax
+// command, then the three results are revealed together.
+func countMinMax(session *Session, layout *SlotLayout, bounds []float64) (counts []float64, minValues []float64, maxValues []float64, err error) {
+
+	fmt.Printf("\n")
+	fmt.Printf("Finding Total No Of Samples, Min and Max... \n")
+
+	packedCiphertexts, err := session.InputLayout(layout)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	noOfSamples, err := session.Aggregate(packedCiphertexts[0]...)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	// The comparisons ignore the slots of the counts
+	comparisonBounds := layout.Spread(0, math.Inf(1), map[string][]float64{StatMin: bounds, StatMax: bounds})
+
+	minResult, err := session.Min(packedCiphertexts[0], comparisonBounds)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	maxResult, err := session.Max(packedCiphertexts[0], comparisonBounds)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-func encryptedSum(params ckks.Parameters, evk rlwe.EvaluationKeySet, inputCiphertext []*rlwe.Ciphertext) (result *rlwe.Ciphertext){
+	// One collective key switch for the three statistics
+	result, err := session.Combine([]*rlwe.Ciphertext{noOfSamples, minResult, maxResult}, [][]float64{layout.Mask(0, StatCount), layout.Mask(0, StatMin), layout.Mask(0, StatMax)})
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	eval := ckks.NewEvaluator(params, evk)
+	resultValues, err := session.RevealValues(result)
+	if err != nil {
+		return nil, nil, nil, err
+	}
 
-	sum := inputCiphertext[0].CopyNew()
-	for i := 1; i < len(inputCiphertext); i++ {
-		eval.Add(sum, inputCiphertext[i], sum)
+	vectors := [][]float64{resultValues}
+	if counts, err = layout.FeatureValues(vectors, StatCount); err != nil {
+		return nil, nil, nil, err
+	}
+	if minValues, err = layout.FeatureValues(vectors, StatMin); err != nil {
+		return nil, nil, nil, err
+	}
+	if maxValues, err = layout.FeatureValues(vectors, StatMax); err != nil {
+		return nil, nil, nil, err
 	}
 
-	return sum
+	return counts, minValues, maxValues, nil
 }
 
-func findKthElement(params ckks.Parameters, pk *rlwe.PublicKey, evk rlwe.EvaluationKeySet, k []int64, NFeatures int, min []float64, max []float64, epsilon []float64, totalNoSamples []int64, parties []*Party, isValidIndex []bool) (result []float64){
+// Searches the k[s]-th element of feature s % NFeatures in every slot s, the searches of all the slots share the communication rounds
+func findKthElement(session *Session, k []int64, NFeatures int, min []float64, max []float64, epsilon []float64, totalNoSamples []int64, isValidIndex []bool, maxRounds int, hiddenCounts bool) (result []float64, err error){
 
-	// This array is used to check if we have found the k-th element for each feature
-	checkEveryFeature := make([]bool, NFeatures)
+	NSlots := len(k)
+
+	// This array is used to check if we have found the k-th element for each slot
+	checkEveryFeature := make([]bool, NSlots)
 
 	a := make([]float64, len(min))
 	b := make([]float64, len(max))
 
 	copy(a, min)
 	copy(b, max)
-	
 
-	m := make([]float64, NFeatures)
-	results := make([]float64, NFeatures)
+	// m is the k-th element when at most kLeft elements are smaller and at most kRight elements are greater than m
+	// If k is a valid index we look at k-1 instead of k, because we want to find the exact k-th element (like finding median (k=5) for 9)
+	// If k is not a valid index we want to find the element that is between two elements (like finding median (k=5) for 10)
+	kLeft := make([]int64, NSlots)
+	kRight := make([]int64, NSlots)
+	for i := 0; i < NSlots; i++ {
+		kLeft[i], kRight[i] = k[i], totalNoSamples[i]-k[i]
+		if isValidIndex[i] {
+			kLeft[i]--
+		}
+	}
+
+	m := make([]float64, NSlots)
+	results := make([]float64, NSlots)
 
-	for !allTrue(checkEveryFeature) {
+	for round := 0; !allTrue(checkEveryFeature); round++ {
 
-		for i := 0; i < NFeatures; i++ {
+		// The round budget is exhausted, the midpoints of the remaining intervals are returned
+		if maxRounds > 0 && round >= maxRounds {
+			for i := 0; i < NSlots; i++ {
+				if !checkEveryFeature[i] {
+					results[i] = (a[i]+b[i])/2.0
+					fmt.Printf("*The %d-th ranked element for feature %d is: %2.8f\n", k[i], i%NFeatures, results[i])
+					checkEveryFeature[i] = true
+				}
+			}
+			break
+		}
+
+		for i := 0; i < NSlots; i++ {
 			if checkEveryFeature[i] {
 				continue
 			}
@@ -178,124 +430,494 @@ func findKthElement(params ckks.Parameters, pk *rlwe.PublicKey, evk rlwe.Evaluat
 			m[i] = (a[i] + b[i]) / 2.0
 		}
 
-		// Count elements smaller and greater than midpoint in all parties for every feature in one communication round
-		lCount, gCount := communicationRound(params, pk, parties, m, NFeatures, evk)
+		// Direction of the k-th element from the midpoint in all parties for every feature in one communication round
+		var directions []int64
+		if hiddenCounts {
+			directions, err = hiddenCommunicationRound(session, m, kLeft, totalNoSamples)
+		} else {
+			directions, err = communicationRound(session, m, kLeft, kRight)
+		}
+		if err != nil {
+			return nil, err
+		}
 
-		for i := 0; i < NFeatures; i++ {
+		for i := 0; i < NSlots; i++ {
 			if checkEveryFeature[i] {
 				continue
 			}
 
-			// // Check if interested index (k) is a valid index or average of two elements, this feature can be disabled.
-			if !isValidIndex[i] {
-				// Check if m is the kth element for feature i
-				// In this part, we want to find the element that is between two elements since k is not a valid index (like finding median (k=5) for 10)
-				if lCount[i] <= k[i] && gCount[i] <= totalNoSamples[i]-k[i] {
-					fmt.Printf("The %d-th ranked element for feature %d is: %2.8f\n", k[i], i, m[i])
-					results[i] = m[i]
-					checkEveryFeature[i] = true
+			// Check if m is the kth element for feature i
+			if directions[i] == 0 {
+				fmt.Printf("The %d-th ranked element for feature %d is: %2.8f\n", k[i], i%NFeatures, m[i])
+				results[i] = m[i]
+				checkEveryFeature[i] = true
+				continue
+			}
+
+			// Adjust range
+			if directions[i] > 0 {
+				b[i] = m[i]
+			} else {
+				a[i] = m[i]
+			}
+
+			// This is a computation limit, epsilon is defined based on the application's needs
+			if b[i]-a[i] <= epsilon[i] {
+				results[i] = (a[i]+b[i])/2.0
+				fmt.Printf("*The %d-th ranked element for feature %d is: %2.8f\n", k[i], i%NFeatures, results[i])
+				checkEveryFeature[i] = true
+			}
+		}
+
+	}
+
+	return results, nil
+}
+
+
+
+// Count elements smaller and greater than midpoint in all parties for every feature, the total counts are decrypted
+// Returns 1 if the k-th element is smaller than the midpoint (more than kLeft smaller elements), -1 if it is greater
+// (more than kRight greater elements) and 0 if the midpoint can be taken as the k-th element
+func communicationRound(session *Session, m []float64, kLeft []int64, kRight []int64) ([]int64, error) {
+
+	NSlots := len(m)
+
+	// Individual calculation and encryption of the parties' counts
+	lCountCiphertexts, err := session.Input(Query{Name: QueryRobustLeft, Args: m})
+	if err != nil {
+		return nil, err
+	}
+	rCountCiphertexts, err := session.Input(Query{Name: QueryRobustRight, Args: m})
+	if err != nil {
+		return nil, err
+	}
+
+	// Summing the encrypted counts
+	totalLCountCiphertext, err := session.Aggregate(lCountCiphertexts...)
+	if err != nil {
+		return nil, err
+	}
+	totalRCountCiphertext, err := session.Aggregate(rCountCiphertexts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decryption of the total counts
+	totalLCountValues, err := session.RevealValues(totalLCountCiphertext)
+	if err != nil {
+		return nil, err
+	}
+	totalRCountValues, err := session.RevealValues(totalRCountCiphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	directions := make([]int64, NSlots)
+	for i := 0; i < NSlots; i++ {
+		lCount := int64(math.Round(totalLCountValues[i]))
+		gCount := int64(math.Round(totalRCountValues[i]))
+
+		// Both cannot hold at once, lCount + gCount is at most the number of samples
+		if lCount > kLeft[i] {
+			directions[i] = 1
+		} else if gCount > kRight[i] {
+			directions[i] = -1
+		}
+	}
+
+	return directions, nil
+}
+
+// Same as communicationRound, but the count of smaller elements is compared with kLeft under encryption and a single bit
+// is decrypted for each slot, step(lCount - kLeft - 1/2): 1 if the k-th element is smaller than the midpoint, 0 otherwise.
+// The midpoint is never found between two elements, the search goes on to the (kLeft+1)-th element and stops at epsilon.
+func hiddenCommunicationRound(session *Session, m []float64, kLeft []int64, totalNoSamples []int64) ([]int64, error) {
+
+	NSlots := len(m)
+
+	// Individual calculation and encryption of the parties' counts
+	lCountCiphertexts, err := session.Input(Query{Name: QueryRobustLeft, Args: m})
+	if err != nil {
+		return nil, err
+	}
+
+	// Summing the encrypted counts
+	totalLCountCiphertext, err := session.Aggregate(lCountCiphertexts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The comparisons work on [-1, 1], the counts are at most the largest number of samples
+	bound := 1.0
+	for _, n := range totalNoSamples {
+		bound = math.Max(bound, float64(n)+1)
+	}
+
+	thresholds := make([]float64, NSlots)
+	for i := 0; i < NSlots; i++ {
+		thresholds[i] = float64(kLeft[i]) + 0.5
+	}
+
+	steps, err := hiddenSteps(session, totalLCountCiphertext, thresholds, bound)
+	if err != nil {
+		return nil, err
+	}
+
+	directions := make([]int64, NSlots)
+	for i := 0; i < NSlots; i++ {
+		directions[i] = -1
+		if steps[i] {
+			directions[i] = 1
+		}
+	}
+
+	return directions, nil
+}
+
+// Compares the aggregated counts with their thresholds under encryption and decrypts the comparison bit of each slot only,
+// step((count - threshold) / bound), the counts themselves are never decrypted
+func hiddenSteps(session *Session, count *rlwe.Ciphertext, thresholds []float64, bound float64) ([]bool, error) {
+
+	params := session.Params
+	eval := ckks.NewEvaluator(params, session.Evk)
+	cmpEval := comparison.NewEvaluator(params, minimax.NewEvaluator(params, eval, session.Refresher), minimax.NewPolynomial(comparison.DefaultCompositePolynomialForSign))
+
+	// (count - threshold) / bound
+	diff, err := eval.SubNew(count, thresholds)
+	if err != nil {
+		return nil, err
+	}
+	if err = eval.Mul(diff, 1/bound, diff); err != nil {
+		return nil, err
+	}
+	if err = eval.Rescale(diff, diff); err != nil {
+		return nil, err
+	}
+
+	// 1 where the count exceeds its threshold, 0 elsewhere
+	step, err := cmpEval.Step(diff)
+	if err != nil {
+		return nil, err
+	}
+
+	// Decryption of the comparison bits only
+	stepValues, err := session.RevealValues(step)
+	if err != nil {
+		return nil, err
+	}
+
+	steps := make([]bool, len(thresholds))
+	for i := range steps {
+		steps[i] = stepValues[i] > 0.5
+	}
+
+	return steps, nil
+}
+
+// Searches the percentiles of every feature with an exact result. For the two ranks k and k+1 around each percentile,
+// the interval (a, b] is bisected under the invariant count(x <= a) < k <= count(x <= b) until it holds the ranked element
+// alone, then the parties reveal the sum of their values in the interval, which is the element itself. The percentile
+// interpolates linearly between the two ranks, as numpy.percentile. If the round budget is exhausted or the interval is
+// narrower than epsilon (ties), the average of the values in the interval is returned.
+func findPercentilesExact(session *Session, Percentiles []float64, NFeatures int, totalNoSamples []int64, min []float64, max []float64, epsilon []float64, maxRounds int, hiddenCounts bool) ([]float64, error) {
+
+	NSlots := len(Percentiles) * NFeatures
+
+	// Rank slot (2t+h)*NFeatures + i searches the rank k+h of the t-th percentile of feature i
+	NRanks := 2 * NSlots
+	k := make([]int64, NRanks)
+	n := make([]int64, NRanks)
+	a := make([]float64, NRanks)
+	b := make([]float64, NRanks)
+	cntA := make([]int64, NRanks)
+	cntB := make([]int64, NRanks)
+	eps := make([]float64, NRanks)
+	checkEveryRank := make([]bool, NRanks)
+	unused := make([]bool, NRanks)
+	fraction := make([]float64, NSlots)
+
+	for t, percentile := range Percentiles {
+		for i := 0; i < NFeatures; i++ {
+			s := t*NFeatures + i
+
+			// 0-based position of the percentile in the sorted values
+			pos := percentile / 100.0 * float64(totalNoSamples[s] - 1)
+			fraction[s] = pos - math.Floor(pos)
+
+			for h := 0; h < 2; h++ {
+				r := (2*t+h)*NFeatures + i
+				k[r] = int64(math.Floor(pos)) + 1 + int64(h)
+				n[r] = totalNoSamples[s]
+				a[r], b[r] = min[s], max[s]
+				cntA[r], cntB[r] = 0, totalNoSamples[s]
+				eps[r] = epsilon[s]
+			}
+
+			// The upper rank is only needed between two ranks
+			unused[(2*t+1)*NFeatures+i] = fraction[s] == 0
+			checkEveryRank[(2*t+1)*NFeatures+i] = fraction[s] == 0
+		}
+	}
+
+	// The hidden counts compare with the number of values greater than the midpoint, which are all below the initial b
+	upper := make([]float64, NRanks)
+	copy(upper, b)
+
+	m := make([]float64, NRanks)
+
+	for round := 0; !allTrue(checkEveryRank) && (maxRounds <= 0 || round < maxRounds); round++ {
+
+		for r := 0; r < NRanks; r++ {
+			m[r] = (a[r] + b[r]) / 2.0
+		}
+
+		// Whether the k-th element is at most m, and whether the new interval holds more than one element (always assumed
+		// with hidden counts)
+		left := make([]bool, NRanks)
+		many := make([]bool, NRanks)
+		if hiddenCounts {
+			var err error
+			if left, err = hiddenExactCommunicationRound(session, m, upper, k, n); err != nil {
+				return nil, err
+			}
+			for r := range many {
+				many[r] = true
+			}
+		} else {
+			cntM, err := exactCommunicationRound(session, m, b, cntB)
+			if err != nil {
+				return nil, err
+			}
+			for r := 0; r < NRanks; r++ {
+				if checkEveryRank[r] {
 					continue
 				}
-
-				// Adjust range
-				if lCount[i] >= k[i] {
-					b[i] = m[i]
+				if cntM[r] >= k[r] {
+					left[r] = true
+					cntB[r] = cntM[r]
 				} else {
-					a[i] = m[i]
+					cntA[r] = cntM[r]
 				}
+				many[r] = cntB[r]-cntA[r] > 1
+			}
+		}
 
-				// This is a computation limit, epsilon is defined based on the application's needs
-				if b[i]-a[i] <= epsilon[i] {
-					results[i] = (a[i]+b[i])/2.0
-					fmt.Printf("*The %d-th ranked element for feature %d is: %2.8f\n", k[i], i, m[i])
-					checkEveryFeature[i] = true
-				}
+		for r := 0; r < NRanks; r++ {
+			if checkEveryRank[r] {
+				continue
+			}
 
+			// Adjust range, the k-th element stays in (a, b]
+			if left[r] {
+				b[r] = m[r]
 			} else {
-				// Check if m is the kth element for feature i
-				// Only difference in this is we look at k-1 instead of k, because k is a valid index, we want to find the exact k-th element (like finding median (k=5) for 9)
-				if lCount[i] <= k[i]-1 && gCount[i] <= totalNoSamples[i]-k[i] {
-					fmt.Printf("The %d-th ranked element for feature %d is: %2.8f\n", k[i], i, m[i])
-					results[i] = m[i]
-					checkEveryFeature[i] = true
-					continue
-				}
+				a[r] = m[r]
+			}
 
-				// Adjust range
-				if lCount[i] >= k[i] {
-					b[i] = m[i]
-				} else {
-					a[i] = m[i]
-				}
+			if !many[r] || b[r]-a[r] <= eps[r] {
+				checkEveryRank[r] = true
+			}
+		}
+	}
 
-				// This is a computation limit, epsilon is defined based on the application's needs
-				if b[i]-a[i] <= epsilon[i] {
-					results[i] = (a[i]+b[i])/2.0
-					fmt.Printf("*The %d-th ranked element for feature %d is: %2.8f\n", k[i], i, m[i])
-					checkEveryFeature[i] = true
-				}				
+	values := make([]float64, NRanks)
+	if hiddenCounts {
+		// The counts stay encrypted, only the average of the values in each final interval is revealed
+		averages, err := hiddenIntervalRound(session, a, b)
+		if err != nil {
+			return nil, err
+		}
+		for r := 0; r < NRanks; r++ {
+			if unused[r] {
+				continue
+			}
+			values[r] = averages[r]
+			fmt.Printf("The %d-th ranked element for feature %d is: %2.8f\n", k[r], r%NFeatures, values[r])
+		}
+	} else {
+		// The parties reveal the sum and the number of their values in each final interval
+		sums, counts, err := intervalRound(session, a, b)
+		if err != nil {
+			return nil, err
+		}
+
+		for r := 0; r < NRanks; r++ {
+			if unused[r] {
+				continue
+			}
+
+			count := math.Round(counts[r])
+			if count < 1 {
+				// Out of the invariant, the search bounds did not hold every value
+				values[r] = (a[r] + b[r]) / 2.0
+				fmt.Printf("*The %d-th ranked element for feature %d is: %2.8f (empty interval)\n", k[r], r%NFeatures, values[r])
+				continue
 			}
 
+			values[r] = sums[r] / count
+			if count == 1 {
+				fmt.Printf("The %d-th ranked element for feature %d is: %2.8f\n", k[r], r%NFeatures, values[r])
+			} else {
+				fmt.Printf("*The %d-th ranked element for feature %d is: %2.8f (average of %d values)\n", k[r], r%NFeatures, values[r], int64(count))
+			}
 		}
+	}
 
+	results := make([]float64, NSlots)
+	for t := range Percentiles {
+		for i := 0; i < NFeatures; i++ {
+			s := t*NFeatures + i
+			lower, higher := values[2*t*NFeatures+i], values[(2*t+1)*NFeatures+i]
+			results[s] = lower
+			if fraction[s] > 0 {
+				results[s] += fraction[s] * (higher - lower)
+			}
+		}
 	}
 
-	return results
+	return results, nil
 }
 
+// Count elements in (m, b] in all parties for every rank, the total counts are decrypted.
+// Returns count(x <= m) = count(x <= b) - count(m < x <= b), cntB being count(x <= b)
+func exactCommunicationRound(session *Session, m []float64, b []float64, cntB []int64) ([]int64, error) {
+
+	NRanks := len(m)
+
+	countCiphertexts, err := session.Input(Query{Name: QueryRobustInterval, Args: append(append([]float64{}, m...), b...)})
+	if err != nil {
+		return nil, err
+	}
+
+	totalCountCiphertext, err := session.Aggregate(countCiphertexts...)
+	if err != nil {
+		return nil, err
+	}
 
-// Count elements smaller and greater than midpoint in all parties for every feature
-func communicationRound(params ckks.Parameters, pk *rlwe.PublicKey, parties []*Party, m []float64, NFeatures int, evk rlwe.EvaluationKeySet) ([]int64 , []int64) {
+	totalCountValues, err := session.RevealValues(totalCountCiphertext)
+	if err != nil {
+		return nil, err
+	}
 
-	// Individual calculation for parties
-	calculatePartysCounts(parties, m, NFeatures)
+	cntM := make([]int64, NRanks)
+	for r := 0; r < NRanks; r++ {
+		cntM[r] = cntB[r] - int64(math.Round(totalCountValues[r]))
+	}
 
-	// Encryption of the parties' counts
-	lCountCiphertexts, rCountCiphertexts := EncryptRobustLRValues(params, pk, parties)
+	return cntM, nil
+}
 
-	// Summing the encrypted counts
-	totalLCountCiphertext := encryptedSum(params, evk, lCountCiphertexts)
-	totalRCountCiphertext := encryptedSum(params, evk, rCountCiphertexts)
+// Same as exactCommunicationRound, but the counts are compared under encryption and count(x <= m) is never decrypted. Each
+// party submits its counts of the elements in (m, upper] and the single bit left = 1 - step(count(m, upper] - (n - k) - 1/2)
+// is decrypted for every rank. Whether the interval holds the ranked element alone is not revealed, the search goes on
+// until epsilon.
+func hiddenExactCommunicationRound(session *Session, m []float64, upper []float64, k []int64, n []int64) ([]bool, error) {
 
-	// Decryption of the total counts
-	tsk, tpk := rlwe.NewKeyGenerator(params).GenKeyPairNew()
-	totalLCountValues := CollectiveDecryption(params, tsk, totalLCountCiphertext, tpk, parties)
-	totalRCountValues := CollectiveDecryption(params, tsk, totalRCountCiphertext, tpk, parties)
+	NRanks := len(m)
 
-	intTotalLCountValues := make([]int64, NFeatures)
-	intTotalRCountValues := make([]int64, NFeatures)
-	for i := 0; i < NFeatures; i++ {
-		intTotalRCountValues[i] = int64(math.Round(totalRCountValues[i]))
-		intTotalLCountValues[i] = int64(math.Round(totalLCountValues[i]))
+	countCiphertexts, err := session.Input(Query{Name: QueryRobustInterval, Args: append(append([]float64{}, m...), upper...)})
+	if err != nil {
+		return nil, err
+	}
+
+	totalCountCiphertext, err := session.Aggregate(countCiphertexts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// The comparisons work on [-1, 1], the counts are at most the largest number of samples
+	bound := 1.0
+	thresholds := make([]float64, NRanks)
+	for r := 0; r < NRanks; r++ {
+		bound = math.Max(bound, float64(n[r])+1)
+		thresholds[r] = float64(n[r]-k[r]) + 0.5
+	}
+
+	steps, err := hiddenSteps(session, totalCountCiphertext, thresholds, bound)
+	if err != nil {
+		return nil, err
+	}
+
+	left := make([]bool, NRanks)
+	for r := 0; r < NRanks; r++ {
+		left[r] = !steps[r]
+	}
+
+	return left, nil
+}
+
+// Sum and number of the elements in (a, b] in all parties for every rank, both totals are decrypted
+func intervalRound(session *Session, a []float64, b []float64) ([]float64, []float64, error) {
+
+	args := append(append([]float64{}, a...), b...)
+
+	sumCiphertexts, err := session.Input(Query{Name: QueryRobustIntervalSum, Args: args})
+	if err != nil {
+		return nil, nil, err
+	}
+	countCiphertexts, err := session.Input(Query{Name: QueryRobustInterval, Args: args})
+	if err != nil {
+		return nil, nil, err
 	}
 
+	totalSumCiphertext, err := session.Aggregate(sumCiphertexts...)
+	if err != nil {
+		return nil, nil, err
+	}
+	totalCountCiphertext, err := session.Aggregate(countCiphertexts...)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	sums, err := session.RevealValues(totalSumCiphertext)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts, err := session.RevealValues(totalCountCiphertext)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	return intTotalLCountValues, intTotalRCountValues
+	return sums, counts, nil
 }
 
-// This is a client side individual computation, this function simulates it
-func calculatePartysCounts(parties []*Party, m []float64, NFeatures int) {
+// Same as intervalRound, but the counts are never decrypted: the sums are divided by the counts under encryption and only
+// the average of the elements in (a, b] is decrypted for every rank. The average is garbage for an empty interval, which
+// is out of the invariant of the search.
+func hiddenIntervalRound(session *Session, a []float64, b []float64) ([]float64, error) {
+
+	args := append(append([]float64{}, a...), b...)
+
+	sumCiphertexts, err := session.Input(Query{Name: QueryRobustIntervalSum, Args: args})
+	if err != nil {
+		return nil, err
+	}
+	countCiphertexts, err := session.Input(Query{Name: QueryRobustInterval, Args: args})
+	if err != nil {
+		return nil, err
+	}
 
-	// Reseting the RobustScalingLCount and RobustScalingRCount for the new round
-	for _, pi := range parties {
-		pi.RobustScalingLCount = make([]float64, NFeatures)
-		pi.RobustScalingRCount = make([]float64, NFeatures)
+	// The slots past the ranks count one element, so that the inverse of the counts stays in its domain
+	padding := make([]float64, session.Params.MaxSlots())
+	for i := len(a); i < len(padding); i++ {
+		padding[i] = 1
+	}
+	padded, err := ckks.NewEvaluator(session.Params, nil).AddNew(countCiphertexts[0], padding)
+	if err != nil {
+		return nil, err
+	}
+	countInverse, err := session.CountInverse(append([]*rlwe.Ciphertext{padded}, countCiphertexts[1:]...))
+	if err != nil {
+		return nil, err
 	}
 
-	// Count elements smaller and greater than midpoint in all parties (this is individual calculation for parties, not summed yet)
-	for _, pi := range parties {
-		for i, featureData := range pi.RobustScalingInput {
-			for _, val := range featureData {
-				if val < m[i] {
-					pi.RobustScalingLCount[i]++
-				} else if val > m[i] {
-					pi.RobustScalingRCount[i]++
-				}
-			}
-		}
+	average, err := session.Average(sumCiphertexts, countInverse)
+	if err != nil {
+		return nil, err
 	}
 
+	return session.RevealValues(average)
 }
 
 func allTrue(arr []bool) bool {
@@ -307,27 +929,3 @@ func allTrue(arr []bool) bool {
 	return true
 }
 
-// Calculating the sorted and merged arrays for validating the results
-func validationArrays(parties []*Party, NFeatures int) [][]float64 {
-
-	if len(parties) == 0 {
-		return nil
-	}
-	
-	// Create a merged array for each feature
-	merged := make([][]float64, NFeatures)
-	
-	// Collect values for each feature
-	for _, party := range parties {
-		for featureIdx, values := range party.RobustScalingInput {
-			merged[featureIdx] = append(merged[featureIdx], values...)
-		}
-	}
-
-	// Sort each merged feature array
-	for i := range merged {
-		sort.Float64s(merged[i])
-	}
-
-	return merged
-}
\ No newline at end of file
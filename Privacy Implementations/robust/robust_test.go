@@ -0,0 +1,170 @@
+package main
+
+import (
+	. "encryption/pkg"
+	"encryption/pkg/pkgtest"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Absolute error tolerated on the percentiles found by the searches, of values within [-2, 2]
+const percentileTolerance = 1e-3
+
+// Median and quartiles of the tests
+var testPercentiles = []float64{50.0, 25.0, 75.0}
+
+// Search of the percentiles of the generated features of the parties, in the slots of the percentiles as in the command
+type percentileSearch struct {
+	session *Session
+	parties []*Party
+
+	NFeatures      int
+	globalMin      []float64
+	globalMax      []float64
+	epsilon        []float64
+	totalNoSamples []int64
+}
+
+// Counts the samples and finds the global min and max of the features of N generated parties, the search intervals
+// are widened by 1% of the range as with the default margin of the command
+func newPercentileSearch(t *testing.T, N int) *percentileSearch {
+	t.Helper()
+	params := pkgtest.Params(t, 12)
+	s := &percentileSearch{NFeatures: 4}
+	s.parties = GenRobustParties(params, N, s.NFeatures)
+	s.session = pkgtest.NewSession(t, params, s.parties, params.GaloisElementForComplexConjugation())
+
+	layout := NewSlotLayout(params.MaxSlots(), s.NFeatures)
+	for _, stat := range []string{StatCount, StatMin, StatMax} {
+		if err := layout.Add(stat); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bounds := make([]float64, s.NFeatures)
+	for i := range bounds {
+		bounds[i] = defaultBound
+	}
+	counts, minValues, maxValues, err := countMinMax(s.session, layout, bounds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	NSlots := len(testPercentiles) * s.NFeatures
+	s.globalMin = make([]float64, NSlots)
+	s.globalMax = make([]float64, NSlots)
+	s.epsilon = make([]float64, NSlots)
+	s.totalNoSamples = make([]int64, NSlots)
+	for j := 0; j < NSlots; j++ {
+		i := j % s.NFeatures
+		widening := 0.01 * (maxValues[i] - minValues[i])
+		s.globalMin[j], s.globalMax[j] = minValues[i]-widening, maxValues[i]+widening
+		s.epsilon[j] = 0.000001
+		s.totalNoSamples[j] = int64(math.Round(counts[i]))
+	}
+	return s
+}
+
+// Pooled percentiles of the parties, in the slots of the search
+func (s *percentileSearch) expected() []float64 {
+	expected := make([]float64, 0, len(testPercentiles)*s.NFeatures)
+	for _, percentile := range testPercentiles {
+		expected = append(expected, PooledPercentile(s.parties, percentile)...)
+	}
+	return expected
+}
+
+// Exact percentiles against the pooled percentiles, with the counts of each round revealed or compared under encryption
+func TestPercentilesExact(t *testing.T) {
+	for _, tc := range []struct {
+		hiddenCounts bool
+		N            int
+	}{
+		{false, 4},
+		{true, 3},
+	} {
+		t.Run(fmt.Sprintf("hidden-counts=%t/parties=%d", tc.hiddenCounts, tc.N), func(t *testing.T) {
+			pkgtest.SkipLong(t, false)
+			s := newPercentileSearch(t, tc.N)
+			results, err := findPercentilesExact(s.session, testPercentiles, s.NFeatures, s.totalNoSamples, s.globalMin, s.globalMax, s.epsilon, 40, tc.hiddenCounts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pkgtest.CheckAbsolute(t, "percentiles", results, s.expected(), percentileTolerance)
+		})
+	}
+}
+
+// Bisection of the percentiles, with the counts of each round revealed or compared under encryption. The rank of a
+// percentile that falls between two ranks is only bracketed, the result is checked to lie between these two ranks.
+func TestPercentilesBisection(t *testing.T) {
+	for _, tc := range []struct {
+		hiddenCounts bool
+		N            int
+		short        bool
+	}{
+		{false, 2, true},
+		{true, 3, false},
+	} {
+		t.Run(fmt.Sprintf("hidden-counts=%t/parties=%d", tc.hiddenCounts, tc.N), func(t *testing.T) {
+			pkgtest.SkipLong(t, tc.short)
+			s := newPercentileSearch(t, tc.N)
+
+			// 1-based rank of each percentile, as in the command
+			NSlots := len(testPercentiles) * s.NFeatures
+			k := make([]int64, NSlots)
+			isValidIndex := make([]bool, NSlots)
+			for j := 0; j < NSlots; j++ {
+				tempK := 1 + testPercentiles[j/s.NFeatures]/100.0*float64(s.totalNoSamples[j]-1)
+				k[j] = int64(math.Floor(tempK))
+				isValidIndex[j] = tempK == math.Floor(tempK)
+			}
+
+			results, err := findKthElement(s.session, k, s.NFeatures, s.globalMin, s.globalMax, s.epsilon, s.totalNoSamples, isValidIndex, 40, tc.hiddenCounts)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pooled := PooledSamples(s.parties)
+			for j, r := range results[:NSlots] {
+				samples := pooled[j%s.NFeatures]
+				lo, hi := samples[k[j]-1], samples[k[j]-1]
+				if !isValidIndex[j] {
+					hi = samples[k[j]]
+				}
+				if math.IsNaN(r) || r < lo-percentileTolerance || r > hi+percentileTolerance {
+					t.Errorf("percentile %v of feature %d: %f, want within [%f, %f]", testPercentiles[j/s.NFeatures], j%s.NFeatures, r, lo, hi)
+				}
+			}
+			t.Log(ComparePrecision("percentiles", results, s.expected()))
+		})
+	}
+}
+
+// Percentiles of the histograms against the pooled percentiles, the error of the ranks read from a histogram is at most
+// the width of a bucket, refined or not
+func TestPercentilesHistogram(t *testing.T) {
+	const buckets = 64
+	for _, tc := range []struct {
+		refine bool
+		N      int
+	}{
+		{false, 4},
+		{true, 3},
+	} {
+		t.Run(fmt.Sprintf("refine=%t/parties=%d", tc.refine, tc.N), func(t *testing.T) {
+			pkgtest.SkipLong(t, false)
+			s := newPercentileSearch(t, tc.N)
+			results, err := findPercentilesHistogram(s.session, testPercentiles, s.NFeatures, s.totalNoSamples, s.globalMin, s.globalMax, buckets, tc.refine)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var width float64
+			for i := 0; i < s.NFeatures; i++ {
+				width = max(width, (s.globalMax[i]-s.globalMin[i])/buckets)
+			}
+			pkgtest.CheckAbsolute(t, "percentiles", results, s.expected(), width)
+		})
+	}
+}
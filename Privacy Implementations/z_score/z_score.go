@@ -2,66 +2,228 @@ package main
 
 import (
 	. "encryption/pkg"
+	"flag"
 	"fmt"
-
-	"github.com/tuneinsight/lattigo/v6/circuits/ckks/bootstrapping"
-	"github.com/tuneinsight/lattigo/v6/circuits/ckks/inverse"
-	"github.com/tuneinsight/lattigo/v6/circuits/ckks/minimax"
+	"math"
 
 	"github.com/tuneinsight/lattigo/v6/core/rlwe"
 	"github.com/tuneinsight/lattigo/v6/schemes/ckks"
-	"github.com/tuneinsight/lattigo/v6/utils/sampling"
 )
 
+// Default public bound of the distance of the values of the features to their shift, that of the default domain of the variance
+var defaultBound = math.Exp2(VarianceLogMax / 2)
 
 func main() {
 
+	cf := NewCommandFlags(flag.CommandLine)
+	mode := flag.String("mode", "two-round", "two-round (the mean is revealed to the parties, which return their sums of (Xi - mean)^2), one-round (the parties submit their sums, sums of squares and counts at once) or samples (the parties submit their raw samples, summed in the slots by the aggregator)")
+	shiftList := flag.String("shift", "", "comma separated public constant per feature subtracted from the values in the one-round and samples modes, close to the expected mean of large magnitude features")
+	chunks := flag.Int("chunks", 1, "number of ciphertexts of raw samples per party in the samples mode")
+	boundList := flag.String("bounds", "", "comma separated public bound of the distance of the values of each feature to its shift (to 0 without -shift), which bounds the variance for the inverse of the standard deviation, 1024 for every feature if empty")
+	flag.Parse()
+
+	if *mode != "two-round" && *mode != "one-round" && *mode != "samples" {
+		panic(fmt.Sprintf("unknown mode %q", *mode))
+	}
+	shift, err := ParseValues(*shiftList)
+	if err != nil {
+		panic(err)
+	}
+	bounds, err := ParseValues(*boundList)
+	if err != nil {
+		panic(err)
+	}
+
 	// Set encryption parameters for CKKS
-	var err error
-	var params ckks.Parameters
-
-	if params, err = ckks.NewParametersFromLiteral(
-		ckks.ParametersLiteral{
-			LogN: 15,                                     			  				 // log2(ring degree)
-			LogQ: []int{55, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45, 45}, // log2(primes Q) (ciphertext modulus)
-			LogP:            []int{61},                             				 // log2(primes P) (auxiliary modulus)
-			LogDefaultScale: 45,
-		}); err != nil {
+	params, err := cf.Params()
+	if err != nil {
 		panic(err)
 	}
 
+	// A party process only holds its own data and secret key and answers the aggregator's rounds
+	if cf.Role == "party" {
+		if err = cf.ServeParty(params, GenZscoreParty); err != nil {
+			panic(err)
+		}
+		return
+	}
+
+	var parties []*Party
+	if cf.Role == "sim" {
+		// Create each party and their secret keys
+		if parties, err = cf.SimParties(params, GenZscoreParties); err != nil {
+			panic(err)
+		}
 
-	crs, err := sampling.NewKeyedPRNG([]byte{'l', 'a', 't', 't', 'i', 'g', 'o'})
+		// See the parties' inputs
+		PrintZscorePartyInputs(parties)
+	}
+
+	cohort, closeCohort, err := cf.Cohort(params, parties)
 	if err != nil {
 		panic(err)
 	}
+	defer closeCohort()
 
-	// Number of parties
-	N := 4
+	session, err := NewSession(params, cohort, DefaultCRSSeed)
+	if err != nil {
+		panic(err)
+	}
 
-	// Create each party and their secret keys
-	parties := GenZscoreParties(params, N)
+	// The samples mode packs sample i of feature j in slot i*B + j, B being the number of features rounded up to a power of two
+	NFeatures := cf.NumFeatures(parties)
+	B := 1
+	for B < NFeatures {
+		B <<= 1
+	}
+	S := params.MaxSlots() / B
 
-	// See the parties' inputs
-	PrintZscorePartyInputs(parties)
+	// The variance of values within the bound of the shift is at most the square of the bound
+	if len(bounds) == 0 {
+		bounds = make([]float64, NFeatures)
+		for i := range bounds {
+			bounds[i] = defaultBound
+		}
+	}
+	if len(bounds) < NFeatures {
+		panic(fmt.Sprintf("%d bounds for %d features", len(bounds), NFeatures))
+	}
+	log2max, err := VarianceLogBound(bounds[:NFeatures])
+	if err != nil {
+		panic(err)
+	}
 
 	// 1) Collective key generations
 
-	// Collective Public Key
-	pk := CollectiveKeyGen(params, crs, parties)
+	// Collective Public Key (published to the parties for encrypting their inputs), Relinearization Key and Refresh Protocol,
+	// in the samples mode the GaloisKeys of the rotations summing the S groups of B slots, and in the one round mode those
+	// of the rotations of the packed statistics
+	var galEls []uint64
+	var layout *SlotLayout
+	if *mode == "samples" {
+		galEls = InnerSumGaloisElements(params, B, S)
+	} else if *mode == "one-round" {
+		if layout, err = oneRoundLayout(params, NFeatures, shift); err != nil {
+			panic(err)
+		}
+		galEls = layout.GaloisElements(params)
+
+		fmt.Printf("\n")
+		fmt.Printf("Slot layout: \n%s", layout)
+	}
+	if err = session.Setup(galEls...); err != nil {
+		panic(err)
+	}
+
+	// Threshold mode: the secret keys are shared so that the t active parties alone can decrypt and refresh
+	if err = cf.Thresholdize(session); err != nil {
+		panic(err)
+	}
+
+
+	// The statistics are computed again without the parties that drop on the way, so that they aggregate the same parties
+	var meanValues, invStdValues []float64
+	contributors, err := session.Consistent(func() error {
+		var variance *rlwe.Ciphertext
+		var err error
+		if *mode == "one-round" {
+			meanValues, variance, err = oneRound(session, layout)
+		} else if *mode == "samples" {
+			meanValues, variance, err = samplesRound(session, shift, NFeatures, B, S, *chunks)
+		} else {
+			meanValues, variance, err = twoRounds(session)
+		}
+		if err != nil {
+			return err
+		}
+
+		// 6) Inverse of the standard deviation, the variance itself is never decrypted
+		invStd, err := inverseStd(session, variance, log2max)
+		if err != nil {
+			return err
+		}
+
+		invStdValues, err = session.RevealValues(invStd)
+		return err
+	})
+	if err != nil {
+		panic(err)
+	}
+
+	// The floor of the variance is removed and constant features are left unscaled, as (x - mean) / 1
+	stdValues, invStdValues, err := StdFromInverse(invStdValues, VarianceLogMin, log2max)
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Results:\n")
+	fmt.Printf("Mean: ")
+	PrintValues(meanValues)
 
-	// Collective Relinearization Key
-	rlk := RelinearizationKeyGeneration(params, crs, parties)
+	fmt.Printf("Std: ")
+	PrintValues(stdValues)
 
-	// Evaluation Key
-	evk := rlwe.NewMemEvaluationKeySet(rlk) 
+	fmt.Printf("1/Std: ")
+	PrintValues(invStdValues)
 
-	// Refresh Protocol (instance of bootstrapping.Bootstrapper)
-	refresher := NewRefresher(params, parties, crs, N)
+	fmt.Printf("\n")
+	fmt.Printf("Contributors: %v\n", contributors)
+	PrintReleases(session.Releases)
+
+	// The aggregator does not hold the parties' data in the networked mode
+	if parties != nil {
+		// Validation against the mean and standard deviation of the pooled data of the contributors in plaintext
+		contributing := PartiesOf(parties, contributors)
+		expectedMean, expectedVariance := PooledMean(contributing), PooledVariance(contributing)
+
+		expectedStd := make([]float64, len(expectedVariance))
+		expectedInvStd := make([]float64, len(expectedVariance))
+		for i, v := range expectedVariance {
+			// Constant features are left unscaled, as by the protocol
+			expectedStd[i] = 1
+			if v >= math.Exp2(ConstantVarianceLog) {
+				expectedStd[i] = math.Sqrt(v)
+			}
+			expectedInvStd[i] = 1 / expectedStd[i]
+		}
+
+		fmt.Printf("\n")
+		fmt.Printf("Validation:\n")
+		fmt.Printf("Mean: ")
+		PrintValues(expectedMean)
+		fmt.Printf("Std: ")
+		PrintValues(expectedStd)
 
+		session.Metrics.RecordPrecision("mean", meanValues, expectedMean)
+		session.Metrics.RecordPrecision("std", stdValues, expectedStd)
+		session.Metrics.RecordPrecision("1/std", invStdValues, expectedInvStd)
+
+		fmt.Printf("\n")
+		fmt.Printf("Precision:\n")
+		PrintPrecision(session.Metrics.Precision)
+	}
+
+	fmt.Printf("\n")
+	fmt.Printf("Metrics:\n")
+	PrintMetrics(session.Metrics)
+	if err = cf.WriteMetrics(session.Metrics); err != nil {
+		panic(err)
+	}
+}
+
+// Two round protocol: the mean is revealed, then each party returns its sum of (Xi - mean)^2
+func twoRounds(session *Session) (meanValues []float64, variance *rlwe.Ciphertext, err error) {
 
 	// 2) Encryption of each party's float64 values
-	inputCiphertexts, numberOfSamplesCiphertexts := EncryptZscoreValues(params, pk, parties)
+	inputCiphertexts, err := session.Input(Query{Name: QueryZscoreSum})
+	if err != nil {
+		return nil, nil, err
+	}
+	numberOfSamplesCiphertexts, err := session.Input(Query{Name: QueryZscoreCount})
+	if err != nil {
+		return nil, nil, err
+	}
 
 
 	// 3) Homomorphic operations for mean calculation
@@ -71,20 +233,27 @@ func main() {
 
 	// Finding the mean
 	// Each slot in mean represents the mean of that feature, each slot in noOfSamplesInverse represents the inverse of total number of data points for that feature 
-	mean, noOfSamplesInverse := average(params, inputCiphertexts, numberOfSamplesCiphertexts, evk, refresher, parties)
+	mean, noOfSamplesInverse, err := average(session, inputCiphertexts, numberOfSamplesCiphertexts)
+	if err != nil {
+		return nil, nil, err
+	}
 
 
 	// 4) Decryption of the mean and client side operations
 
 	// Decrypting the mean for client side operations
-	tsk, tpk := rlwe.NewKeyGenerator(params).GenKeyPairNew()
-	
-	meanValues := CollectiveDecryption(params, tsk, mean, tpk, parties)
+	meanValues, err = session.RevealValues(mean)
+	if err != nil {
+		return nil, nil, err
+	}
 
 	// Client Side partially summation by using mean sum(for i in range Kj -> (Xi - mean)^2), K is number of data points for Client j
 	// Each slot in party[j].TempVarianceSum represents the sum of (Xi - mean)^2 for that feature for client j
 	// Each slot in partialSumsCiphertexts[j] represents the encryption of sum of (Xi - mean)^2 for that feature for client j
-	partialSumsCiphertexts := clientSidePartialSums(params, meanValues, parties, pk)
+	partialSumsCiphertexts, err := session.Input(Query{Name: QueryZscorePartialSum, Args: meanValues})
+	if err != nil {
+		return nil, nil, err
+	}
 
 	
 	// 5) Homomorphic operations for variance calculation
@@ -93,157 +262,207 @@ func main() {
 	// Each slot in variance represents the variance of that feature
 	// Summing the partial summations that are calculated by the clients then dividing it with the total number of data points
 	// variance = 1/N * sum(for i in range N -> (Xi - mean)^2)
-	variance := variance(params, partialSumsCiphertexts, mean, noOfSamplesInverse, evk, refresher, parties)
+	if variance, err = varianceOf(session, partialSumsCiphertexts, noOfSamplesInverse); err != nil {
+		return nil, nil, err
+	}
+
+	return meanValues, variance, nil
+}
 
+// One round protocol: each party submits sum(Xi - K), sum((Xi - K)^2) and its number of samples at once, packed in
+// one ciphertext by the layout, K being the public shift of the feature in the layout (0 without shift), and nothing
+// is revealed before the results.
+// variance = E[(X - K)^2] - E[X - K]^2 and mean = E[X - K] + K
+func oneRound(session *Session, layout *SlotLayout) (meanValues []float64, variance *rlwe.Ciphertext, err error) {
+
+	// 2) Encryption of each party's shifted sums, sums of squares and number of samples, packed in one ciphertext
+	packedCiphertexts, err := session.InputLayout(layout)
+	if err != nil {
+		return nil, nil, err
+	}
+	total, err := session.Aggregate(packedCiphertexts[0]...)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	// 6) Decryption of the variance and printing the results
-	tsk2, tpk2 := rlwe.NewKeyGenerator(params).GenKeyPairNew()
+	// The statistics are moved to the slots of the features, the slots past the features count one sample of value 0
+	sums, err := session.Extract(layout, total, StatSum, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	sumSquares, err := session.Extract(layout, total, StatSumSquares, 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	counts, err := session.Extract(layout, total, StatCount, 1)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	varianceValues := CollectiveDecryption(params, tsk2, variance, tpk2, parties)
 
-	fmt.Printf("\n")
-	fmt.Printf("Results:\n")
-	fmt.Printf("Mean: ")
-	PrintValues(meanValues)
+	// 3) Homomorphic operations for the shifted mean and the variance
+	shiftedMean, noOfSamplesInverse, err := average(session, []*rlwe.Ciphertext{sums}, []*rlwe.Ciphertext{counts})
+	if err != nil {
+		return nil, nil, err
+	}
 
-	fmt.Printf("Variance: ")
-	PrintValues(varianceValues)
+	if variance, err = varianceFromSquares(session, []*rlwe.Ciphertext{sumSquares}, shiftedMean, noOfSamplesInverse); err != nil {
+		return nil, nil, err
+	}
 
-}
 
+	// 4) Decryption of the mean
+	if meanValues, err = session.RevealValues(shiftedMean); err != nil {
+		return nil, nil, err
+	}
+	for i := range layout.Shift {
+		meanValues[i] += layout.Shift[i]
+	}
 
-// Finding the mean of the encrypted features
-// mean = sum(Xi) / N , for each client and feature
-func average(params ckks.Parameters, inputCiphertexts []*rlwe.Ciphertext, numberOfSamplesCiphertexts []*rlwe.Ciphertext, evk rlwe.EvaluationKeySet, btp bootstrapping.Bootstrapper, parties []*Party) (mean *rlwe.Ciphertext, noOfSamples *rlwe.Ciphertext ) {
-	
-	fmt.Printf("\n")
-	fmt.Printf("Finding the Mean... \n")
+	return meanValues, variance, nil
+}
 
-	var err error
+// Slot layout of the one round mode: the shifted sums, the sums of squares and the numbers of samples of the features
+func oneRoundLayout(params ckks.Parameters, NFeatures int, shift []float64) (*SlotLayout, error) {
+	if len(shift) > 0 && len(shift) < NFeatures {
+		return nil, fmt.Errorf("%w: %d shifts for %d features", ErrInvalidQuery, len(shift), NFeatures)
+	}
 
-	// Evaluator
-	eval := ckks.NewEvaluator(params, evk)
+	layout := NewSlotLayout(params.MaxSlots(), NFeatures)
+	if len(shift) > 0 {
+		layout.Shift = shift[:NFeatures]
+	}
+	for _, stat := range []string{StatSum, StatSumSquares, StatCount} {
+		if err := layout.Add(stat); err != nil {
+			return nil, err
+		}
+	}
+	return layout, nil
+}
 
-	// Minimax evaluator
-	minEvl := minimax.NewEvaluator(params, eval, btp)
+// Samples protocol: each party encrypts its raw samples minus the public shift K, S samples of every feature per ciphertext,
+// and the aggregator squares them and sums the slots of each feature with rotations. No party side aggregation is needed,
+// the variance is then computed as in the one round protocol from the sums, the sums of squares and the counts.
+func samplesRound(session *Session, shift []float64, NFeatures, B, S, chunks int) (meanValues []float64, variance *rlwe.Ciphertext, err error) {
 
-	// Inverse evaluator
-	invEval := inverse.NewEvaluator(params, minEvl)
+	eval := ckks.NewEvaluator(session.Params, session.Evk)
 
-	// Summing the inputs
-	sumInputs := inputCiphertexts[0].CopyNew()
-	
-	for i := 1; i < len(inputCiphertexts); i++ {
-		
-		eval.Add(sumInputs, inputCiphertexts[i], sumInputs)
+	// 2) Encryption of each party's raw samples and squares of the samples under encryption
+	var sampleCiphertexts, squareCiphertexts []*rlwe.Ciphertext
+	for c := 0; c < chunks; c++ {
+		args := append([]float64{float64(B), float64(S), float64(c), float64(chunks)}, shift...)
+		cts, err := session.Input(Query{Name: QueryZscoreSamples, Args: args})
+		if err != nil {
+			return nil, nil, err
+		}
 
+		for _, ct := range cts {
+			square, err := eval.MulRelinNew(ct, ct)
+			if err != nil {
+				return nil, nil, err
+			}
+			if err = eval.Rescale(square, square); err != nil {
+				return nil, nil, err
+			}
+			squareCiphertexts = append(squareCiphertexts, square)
+		}
+		sampleCiphertexts = append(sampleCiphertexts, cts...)
+	}
+	numberOfSamplesCiphertexts, err := session.Input(Query{Name: QueryZscoreCount})
+	if err != nil {
+		return nil, nil, err
 	}
 
+	fmt.Printf("\n")
+	fmt.Printf("Summing the Samples... \n")
 
-	// Summing the no of samples
-	sumNoOfSamples := numberOfSamplesCiphertexts[0].CopyNew()
-	for i := 1; i < len(numberOfSamplesCiphertexts); i++ {
-		eval.Add(sumNoOfSamples, numberOfSamplesCiphertexts[i], sumNoOfSamples)
+	// 3) Sums over the parties and the slots, the sum of feature j ends in slot j and the other slots are cleared
+	mask := make([]float64, NFeatures)
+	for j := range mask {
+		mask[j] = 1
 	}
-
-	// Inverse of No of samples
-	logmin := -30.0
-	logmax := 30.0
-	var noSamplesInverse *rlwe.Ciphertext
-	
-	if noSamplesInverse, err = invEval.EvaluatePositiveDomainNew(sumNoOfSamples, logmin, logmax); err != nil {
-		panic(err)
+	sums := make([]*rlwe.Ciphertext, 2)
+	for i, cts := range [][]*rlwe.Ciphertext{sampleCiphertexts, squareCiphertexts} {
+		total, err := session.Aggregate(cts...)
+		if err != nil {
+			return nil, nil, err
+		}
+		if total, err = session.InnerSum(total, B, S); err != nil {
+			return nil, nil, err
+		}
+		if sums[i], err = session.Combine([]*rlwe.Ciphertext{total}, [][]float64{mask}); err != nil {
+			return nil, nil, err
+		}
 	}
 
+	// 4) Homomorphic operations for the shifted mean and the variance
+	shiftedMean, noOfSamplesInverse, err := average(session, sums[:1], numberOfSamplesCiphertexts)
+	if err != nil {
+		return nil, nil, err
+	}
 
+	if variance, err = varianceFromSquares(session, sums[1:], shiftedMean, noOfSamplesInverse); err != nil {
+		return nil, nil, err
+	}
 
-	// Bootstrapping the result of inverse
-	if noSamplesInverse, err = btp.Bootstrap(noSamplesInverse); err != nil {
-		panic(err)
+	// 5) Decryption of the mean
+	if meanValues, err = session.RevealValues(shiftedMean); err != nil {
+		return nil, nil, err
+	}
+	for i := range shift {
+		meanValues[i] += shift[i]
 	}
 
+	return meanValues, variance, nil
+}
 
-	// Multiply
-	var average *rlwe.Ciphertext
+// Finding the mean of the encrypted features
+// mean = sum(Xi) / N , for each client and feature
+func average(session *Session, inputCiphertexts []*rlwe.Ciphertext, numberOfSamplesCiphertexts []*rlwe.Ciphertext) (mean *rlwe.Ciphertext, noOfSamplesInverse *rlwe.Ciphertext, err error) {
+	
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Mean... \n")
 
-	average, err = eval.MulRelinNew(sumInputs, noSamplesInverse)
-	if err != nil {
-		panic(err)
+	// Inverse of No of samples
+	if noOfSamplesInverse, err = session.CountInverse(numberOfSamplesCiphertexts); err != nil {
+		return nil, nil, err
 	}
-	if err = eval.Rescale(average, average); err != nil {
-		panic(err)
+
+	// Summing the inputs and multiplying by the inverse
+	if mean, err = session.Average(inputCiphertexts, noOfSamplesInverse); err != nil {
+		return nil, nil, err
 	}
 
-	return average, noSamplesInverse
+	return mean, noOfSamplesInverse, nil
 }
 
 // Calculating the variance of the encrypted features
 // 1/N * sum(for i in range N -> (Xi - mean)^2)
-func variance(params ckks.Parameters, partialSumsCiphertexts []*rlwe.Ciphertext, mean *rlwe.Ciphertext, noOfSamplesInverse *rlwe.Ciphertext, evk rlwe.EvaluationKeySet, btp bootstrapping.Bootstrapper, parties []*Party) *rlwe.Ciphertext {
+func varianceOf(session *Session, partialSumsCiphertexts []*rlwe.Ciphertext, noOfSamplesInverse *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
 	
 	fmt.Printf("\n")
 	fmt.Printf("Finding the Variance... \n")
-	
-	var err error
-	
-	// Evaluator
-	eval := ckks.NewEvaluator(params, evk)
 
-	// Summing the partialSums --- sum(for i in range N -> (Xi - mean)^2)
-	totalSum := partialSumsCiphertexts[0].CopyNew()
-	for i := 1; i < len(partialSumsCiphertexts); i++ {
-		eval.Add(totalSum, partialSumsCiphertexts[i], totalSum)
-	}
+	// Summing the partialSums then multiplying --- variance = 1/N * sum(for i in range N -> (Xi - mean)^2)
+	return session.Average(partialSumsCiphertexts, noOfSamplesInverse)
+}
 
+// Calculating the variance of the encrypted features from the sums of squares
+// 1/N * sum(for i in range N -> Xi^2) - mean^2
+func varianceFromSquares(session *Session, sumSquaresCiphertexts []*rlwe.Ciphertext, mean *rlwe.Ciphertext, noOfSamplesInverse *rlwe.Ciphertext) (*rlwe.Ciphertext, error) {
 
-	// Multiply --- variance = 1/N * totalSum
-	var variance *rlwe.Ciphertext
-	
-	variance, err = eval.MulRelinNew(totalSum, noOfSamplesInverse)
-	if err != nil {
-		panic(err)
-	}
-	if err = eval.Rescale(variance, variance); err != nil {
-		panic(err)
-	}
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Variance... \n")
 
-	
-	return variance
+	return session.VarianceFromSquares(sumSquaresCiphertexts, mean, noOfSamplesInverse)
 }
 
-// This is a simulation for client side operation, since the values are not encrypted.
-// Each client calculates the sum of (Xi - mean)^2 for each feature
-// Later, these partial sums are summed and divided by the total number of data points to calculate variance
-func clientSidePartialSums(params ckks.Parameters, mean []float64, parties []*Party, pk *rlwe.PublicKey) ([]*rlwe.Ciphertext){
-
-	// This is a simulation for the operation, values are hand made
-	for i, pi := range parties {
-		pi.TempVarianceSum = make([]float64, params.MaxSlots())
-		for j := range pi.TempVarianceSum {
-			if j < 4 {
-				pi.TempVarianceSum[j] = (25.0 * float64(i+1)) - (float64(j) * 50.0)
-			} else{
-				pi.TempVarianceSum[j] = (25.0 * float64(i+1))
-			}
-		}
-	}
-
-	// Encrpyting the results for sending to the cloud
-	encryptor := ckks.NewEncryptor(params, pk)
-	encoder := ckks.NewEncoder(params)
-
-	partialSumsCiphertexts := make([]*rlwe.Ciphertext, len(parties))
-	for i, pi := range parties {
-		plaintext := ckks.NewPlaintext(params, params.MaxLevel())
+// Calculating the inverse of the standard deviation of the encrypted features, of variance at most 2^log2max
+// 1/std = 1/sqrt(variance)
+func inverseStd(session *Session, variance *rlwe.Ciphertext, log2max float64) (*rlwe.Ciphertext, error) {
 
-		var err error
-		if err = encoder.Encode(pi.TempVarianceSum, plaintext); err != nil {
-			panic(err)
-		}
-		if partialSumsCiphertexts[i], err = encryptor.EncryptNew(plaintext); err != nil {
-			panic(err)
-		}
-	}
+	fmt.Printf("\n")
+	fmt.Printf("Finding the Inverse of the Standard Deviation... \n")
 
-	return partialSumsCiphertexts	
+	return session.InverseStd(variance, VarianceLogMin, log2max)
 }
@@ -0,0 +1,100 @@
+package main
+
+import (
+	. "encryption/pkg"
+	"encryption/pkg/pkgtest"
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/tuneinsight/lattigo/v6/core/rlwe"
+)
+
+// Relative errors tolerated on the mean and on the inverse standard deviation
+const (
+	meanTolerance   = 1e-3
+	invStdTolerance = 1e-2
+)
+
+// Shift close to the means of the generated features
+var testShift = []float64{50, 45, 40, 35}
+
+// Every mode of the protocol against the pooled mean and variance, for 2 to 4 generated parties. The variance is in the
+// default domain, or in that of the bound of the distance of the generated values to the shift.
+func TestZscore(t *testing.T) {
+	for _, tc := range []struct {
+		mode  string
+		shift []float64
+		bound float64
+		N     int
+		short bool
+	}{
+		{"two-round", nil, defaultBound, 2, true},
+		{"one-round", nil, defaultBound, 3, false},
+		{"one-round", testShift, defaultBound, 4, false},
+		{"one-round", testShift, 32, 2, false},
+		{"samples", testShift, defaultBound, 3, false},
+	} {
+		t.Run(fmt.Sprintf("%s/shift=%t/bound=%v/parties=%d", tc.mode, tc.shift != nil, tc.bound, tc.N), func(t *testing.T) {
+			pkgtest.SkipLong(t, tc.short)
+			params := pkgtest.Params(t, 12)
+			parties := GenZscoreParties(params, tc.N)
+
+			// The samples mode packs the samples of the 4 features in groups of B = 4 slots, in as many ciphertexts as
+			// the party with the most samples needs
+			NFeatures, B := 4, 4
+			S := params.MaxSlots() / B
+			chunks := 1
+			for _, pi := range parties {
+				for _, samples := range pi.Data.Samples {
+					chunks = max(chunks, (len(samples)+S-1)/S)
+				}
+			}
+			var galEls []uint64
+			var layout *SlotLayout
+			var err error
+			if tc.mode == "samples" {
+				galEls = InnerSumGaloisElements(params, B, S)
+			} else if tc.mode == "one-round" {
+				if layout, err = oneRoundLayout(params, NFeatures, tc.shift); err != nil {
+					t.Fatal(err)
+				}
+				galEls = layout.GaloisElements(params)
+			}
+			session := pkgtest.NewSession(t, params, parties, galEls...)
+
+			var meanValues []float64
+			var variance *rlwe.Ciphertext
+			switch tc.mode {
+			case "two-round":
+				meanValues, variance, err = twoRounds(session)
+			case "one-round":
+				meanValues, variance, err = oneRound(session, layout)
+			case "samples":
+				meanValues, variance, err = samplesRound(session, tc.shift, NFeatures, B, S, chunks)
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			log2max, err := VarianceLogBound([]float64{tc.bound})
+			if err != nil {
+				t.Fatal(err)
+			}
+			invStd, err := inverseStd(session, variance, log2max)
+			if err != nil {
+				t.Fatal(err)
+			}
+			invStdValues, err := session.RevealValues(invStd)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			expectedInvStd := PooledVariance(parties)
+			for i, v := range expectedInvStd {
+				expectedInvStd[i] = 1 / math.Sqrt(v)
+			}
+			pkgtest.CheckRelative(t, "mean", meanValues, PooledMean(parties), meanTolerance)
+			pkgtest.CheckRelative(t, "1/std", invStdValues, expectedInvStd, invStdTolerance)
+		})
+	}
+}